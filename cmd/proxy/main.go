@@ -2,23 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"flag"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/advanced"
 	"github.com/surukanti/reverse-proxy/internal/config"
+	"github.com/surukanti/reverse-proxy/internal/metrics"
 	"github.com/surukanti/reverse-proxy/internal/middleware"
 	"github.com/surukanti/reverse-proxy/internal/proxy"
-	"github.com/surukanti/reverse-proxy/internal/router"
+	"github.com/surukanti/reverse-proxy/internal/server"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
 	configFile := flag.String("config", "config.yaml", "Configuration file")
+	watchConfig := flag.Bool("watch", true, "Reload the configuration file when it changes")
 	flag.Parse()
 
 	// Load configuration
@@ -33,70 +45,101 @@ func main() {
 
 	// Create proxy
 	p := proxy.NewProxy()
-
-	// Setup backends
-	backends := make(map[string]*backend.Pool)
-	for _, backendCfg := range cfg.Backends {
-		log.Printf("Setting up backend: %s", backendCfg.ID)
-		pool := backend.NewPool()
-		for i, serverURL := range backendCfg.Servers {
-			log.Printf("  Adding server %d: %s", i, serverURL)
-			server, err := pool.AddServer(serverURL, 1)
-			if err != nil {
-				log.Printf("  Failed to add server: %v", err)
-				continue
-			}
-			log.Printf("  Server added successfully: URL=%v", server.URL)
-		}
-		log.Printf("Backend %s has %d servers", backendCfg.ID, len(pool.Servers))
-
-		// Setup health checking
-		if backendCfg.HealthCheck.Enabled {
-			interval := 30 * time.Second
-			timeout := 5 * time.Second
-			hc := backend.NewHealthChecker(pool, interval, timeout, backendCfg.HealthCheck.Path)
-			hc.Start(context.Background())
+	if err := p.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid server config: %v", err)
+	}
+	p.SetPreserveHost(cfg.Server.PreserveHost)
+	for status, file := range cfg.Server.ErrorPages {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Invalid error page for %d: %v", status, err)
 		}
-
-		backends[backendCfg.ID] = pool
+		p.SetErrorPage(status, body, mime.TypeByExtension(filepath.Ext(file)))
 	}
 
-	// Setup routes
-	for _, routeCfg := range cfg.Routes {
-		pool, ok := backends[routeCfg.BackendID]
-		if !ok {
-			log.Printf("Backend %s not found for route %s", routeCfg.BackendID, routeCfg.Name)
-			continue
-		}
+	// Setup backends, routes and reloadable policies
+	if err := p.Reload(cfg); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
 
-		route := &router.Route{
-			Name:       routeCfg.Name,
-			Pattern:    routeCfg.Pattern,
-			PathPrefix: routeCfg.PathPrefix,
-			Subdomain:  routeCfg.Subdomain,
-			Headers:    routeCfg.Headers,
-			Methods:    routeCfg.Methods,
-			Backend:    pool,
-			Priority:   routeCfg.Priority,
-		}
+	// Setup middleware
+	p.AddMiddleware(middleware.NewRequestIDMiddleware().Handle)
 
-		err := p.AddRoute(route)
+	if cfg.Policies.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Policies.Timeout)
 		if err != nil {
-			log.Printf("Failed to add route: %v", err)
+			log.Fatalf("Invalid request timeout '%s': %v", cfg.Policies.Timeout, err)
 		}
+		p.AddMiddleware(middleware.NewTimeoutMiddleware(timeout).Handle)
 	}
 
-	// Setup middleware
 	if cfg.Policies.CORS.Enabled {
-		corsMiddleware := middleware.NewCORSMiddleware(cfg.Policies.CORS.AllowedOrigins)
+		if cfg.Policies.CORS.AllowCredentials && slices.Contains(cfg.Policies.CORS.AllowedOrigins, "*") {
+			log.Fatalf("CORS allow_credentials requires explicit allowed_origins, not '*'")
+		}
+		corsMiddleware := middleware.NewCORSMiddlewareWithOptions(middleware.CORSOptions{
+			AllowedOrigins:   cfg.Policies.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.Policies.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.Policies.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.Policies.CORS.ExposedHeaders,
+			AllowCredentials: cfg.Policies.CORS.AllowCredentials,
+			MaxAge:           cfg.Policies.CORS.MaxAge,
+			ReflectRequest:   cfg.Policies.CORS.ReflectRequest,
+		})
 		p.AddMiddleware(corsMiddleware.Handle)
 	}
 
 	if cfg.Policies.Auth.Enabled {
-		authMiddleware := middleware.NewAuthMiddleware(func(token string) bool {
-			return token != ""
+		switch cfg.Policies.Auth.Type {
+		case "jwt":
+			if cfg.Policies.Auth.Secret == "" {
+				log.Fatalf("JWT auth requires a secret")
+			}
+			jwtMiddleware := middleware.NewJWTAuthMiddleware([]byte(cfg.Policies.Auth.Secret))
+			p.AddMiddleware(jwtMiddleware.Handle)
+		case "introspection":
+			auth := cfg.Policies.Auth
+			if auth.IntrospectionURL == "" {
+				log.Fatalf("Introspection auth requires an introspection_url")
+			}
+			var opts []middleware.IntrospectionOption
+			if auth.CacheTTL != "" {
+				ttl, err := time.ParseDuration(auth.CacheTTL)
+				if err != nil {
+					log.Fatalf("Invalid introspection cache_ttl '%s': %v", auth.CacheTTL, err)
+				}
+				opts = append(opts, middleware.WithIntrospectionTTL(ttl))
+			}
+			introspectionMiddleware := middleware.NewIntrospectionMiddleware(auth.IntrospectionURL, auth.ClientID, auth.ClientSecret, opts...)
+			p.AddMiddleware(introspectionMiddleware.Handle)
+		case "bearer":
+			// Legacy: any non-empty bearer token is accepted
+			authMiddleware := middleware.NewAuthMiddleware(func(token string) bool {
+				return token != ""
+			})
+			p.AddMiddleware(authMiddleware.Handle)
+		default:
+			log.Fatalf("Unknown auth type '%s' (want jwt, introspection or bearer)", cfg.Policies.Auth.Type)
+		}
+	}
+
+	if headers := cfg.Policies.SecurityHeaders; headers.Enabled {
+		securityMiddleware := middleware.NewSecurityHeadersMiddleware(middleware.SecurityHeadersOptions{
+			StrictTransportSecurity: headers.StrictTransportSecurity,
+			ContentTypeOptions:      headers.ContentTypeOptions,
+			FrameOptions:            headers.FrameOptions,
+			ReferrerPolicy:          headers.ReferrerPolicy,
+			ContentSecurityPolicy:   headers.ContentSecurityPolicy,
+		})
+		p.AddMiddleware(securityMiddleware.Handle)
+	}
+
+	if headers := cfg.Policies.Headers; headers.Enabled {
+		headerMiddleware := middleware.NewHeaderRewriteMiddleware(middleware.HeaderRewriteOptions{
+			Request:  middleware.HeaderRules(headers.Request),
+			Response: middleware.HeaderRules(headers.Response),
 		})
-		p.AddMiddleware(authMiddleware.Handle)
+		p.AddMiddleware(headerMiddleware.Handle)
 	}
 
 	// Setup logging
@@ -104,15 +147,44 @@ func main() {
 		log.Println(msg)
 	})
 	p.AddMiddleware(loggingMiddleware.Handle)
+	p.AddAfterHook(loggingMiddleware.After)
 
-	// Setup rate limiting
-	if cfg.Policies.RateLimit.Enabled {
-		window, err := time.ParseDuration(cfg.Policies.RateLimit.Window)
-		if err != nil {
-			log.Printf("Invalid rate limit window duration '%s', using 1 minute: %v", cfg.Policies.RateLimit.Window, err)
-			window = time.Minute
+	// Setup rate limiting; Reload applied the configured limit
+	p.RateLimiter().Start(context.Background())
+
+	// Setup per-tenant rate limiting
+	if tenants := cfg.Policies.TenantRateLimit; tenants.Enabled {
+		limiter := advanced.NewTenantRateLimiter()
+		parseWindow := func(name string, limit config.TenantLimit) time.Duration {
+			window, err := time.ParseDuration(limit.Window)
+			if err != nil || window <= 0 {
+				log.Fatalf("Invalid tenant rate limit window '%s' for %s", limit.Window, name)
+			}
+			return window
+		}
+		if tenants.Default.MaxRequests > 0 {
+			limiter.SetDefaultLimit(tenants.Default.MaxRequests, parseWindow("default", tenants.Default))
+		}
+		for id, limit := range tenants.Tenants {
+			limiter.SetTenantLimit(id, limit.MaxRequests, parseWindow("tenant "+id, limit))
+		}
+		limiter.Start(context.Background())
+		p.SetTenantRateLimit(limiter, proxy.TenantFromHeader(tenants.Header))
+	}
+
+	// Setup cache maintenance
+	if cache := cfg.Policies.Cache; cache.Enabled {
+		reapInterval := time.Minute
+		if cache.ReapInterval != "" {
+			if reapInterval, err = time.ParseDuration(cache.ReapInterval); err != nil {
+				log.Fatalf("Invalid cache reap_interval '%s': %v", cache.ReapInterval, err)
+			}
+		}
+		p.StartCacheReaper(context.Background(), reapInterval)
+
+		if cache.PurgeToken != "" {
+			p.SetPurgeAuthorizer(bearerToken(cache.PurgeToken))
 		}
-		p.SetRateLimit(cfg.Policies.RateLimit.MaxRequests, window)
 	}
 
 	// Setup event handlers
@@ -124,18 +196,135 @@ func main() {
 		log.Printf("Cache hit: %s %s", event.Request.Method, event.Request.URL.Path)
 	})
 
+	p.On("request_retried", func(event proxy.Event) {
+		log.Printf("Retrying %s %s on %s", event.Request.Method, event.Request.URL.Path, event.Server.URL)
+	})
+
 	p.On("proxy_error", func(event proxy.Event) {
 		log.Printf("Proxy error: %v", event.Error)
 	})
 
-	// Start server
-	addr := cfg.Server.Host + ":" + cfg.Server.Port
-	server := &http.Server{
-		Addr:    addr,
-		Handler: p,
+	p.On("backend_state_changed", func(event proxy.Event) {
+		log.Printf("Backend %s healthy=%v", event.Server.URL, event.Healthy)
+	})
+
+	p.On("circuit_breaker_state_changed", func(event proxy.Event) {
+		log.Printf("Circuit breaker for %s: %s -> %s", event.Server.URL, event.PreviousState, event.State)
+	})
+
+	// Setup metrics
+	var handler http.Handler = p
+	if cfg.Metrics.Enabled {
+		m := metrics.New()
+		m.Observe(p)
+
+		path := cfg.Metrics.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, m.Handler())
+
+		if cfg.Metrics.Address != "" {
+			go func() {
+				log.Printf("Serving metrics on %s%s", cfg.Metrics.Address, path)
+				if err := http.ListenAndServe(cfg.Metrics.Address, mux); err != nil {
+					log.Fatalf("Metrics server error: %v", err)
+				}
+			}()
+		} else {
+			mux.Handle("/", p)
+			handler = mux
+		}
+	}
+
+	// Setup liveness and readiness probes
+	var shutdownDelay time.Duration
+	if probes := cfg.Probes; probes.Enabled {
+		livenessPath, readinessPath := probes.LivenessPath, probes.ReadinessPath
+		if livenessPath == "" {
+			livenessPath = "/livez"
+		}
+		if readinessPath == "" {
+			readinessPath = "/readyz"
+		}
+		if probes.ShutdownDelay != "" {
+			if shutdownDelay, err = time.ParseDuration(probes.ShutdownDelay); err != nil {
+				log.Fatalf("Invalid probes shutdown_delay '%s': %v", probes.ShutdownDelay, err)
+			}
+		}
+		handler = p.ProbesHandler(handler, livenessPath, readinessPath)
+	}
+
+	// Setup admin endpoints
+	if cfg.Admin.Enabled {
+		adminAddr := cfg.Admin.Address
+		if adminAddr == "" {
+			adminAddr = "localhost:9091"
+		}
+		if cfg.Admin.Token != "" {
+			p.SetAdminAuthorizer(bearerToken(cfg.Admin.Token))
+		}
+		go func() {
+			log.Printf("Serving admin endpoints on %s", adminAddr)
+			if err := http.ListenAndServe(adminAddr, p.AdminHandler()); err != nil {
+				log.Fatalf("Admin server error: %v", err)
+			}
+		}()
 	}
 
-	log.Printf("Starting reverse proxy on %s", addr)
+	// Setup tracing
+	var tracerProvider *sdktrace.TracerProvider
+	if cfg.Tracing.Enabled {
+		tracerProvider = newTracerProvider(cfg.Tracing)
+		p.SetTracer(tracerProvider.Tracer("github.com/surukanti/reverse-proxy"))
+	}
+
+	// Start listeners
+	listeners := []server.Listener{{
+		Address:  cfg.Server.Host + ":" + cfg.Server.Port,
+		TLS:      cfg.Server.TLS,
+		CertFile: cfg.Server.CertFile,
+		KeyFile:  cfg.Server.KeyFile,
+	}}
+	if len(cfg.Server.Listeners) > 0 {
+		listeners = listeners[:0]
+		for _, l := range cfg.Server.Listeners {
+			listeners = append(listeners, server.Listener(l))
+		}
+	}
+	servers, err := server.NewGroup(handler, listeners)
+	if err != nil {
+		log.Fatalf("Invalid server config: %v", err)
+	}
+	if err := servers.Start(); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	for _, l := range listeners {
+		log.Printf("Starting reverse proxy on %s (tls=%v, redirect_https=%v)", l.Address, l.TLS, l.RedirectHTTPS)
+	}
+
+	// Reload routes, backends and policies on SIGHUP and, if enabled, when
+	// the config file changes
+	logReload := func(newCfg *config.Config, err error) {
+		if err != nil {
+			log.Printf("Reload failed, keeping the running config: %v", err)
+			return
+		}
+		log.Printf("Config reloaded: %d backends, %d routes", len(newCfg.Backends), len(newCfg.Routes))
+	}
+	go func() {
+		hupch := make(chan os.Signal, 1)
+		signal.Notify(hupch, syscall.SIGHUP)
+		for range hupch {
+			logReload(p.ReloadFile(*configFile))
+		}
+	}()
+	if *watchConfig {
+		if err := p.WatchConfig(context.Background(), *configFile, proxy.DefaultWatchDebounce, logReload); err != nil {
+			log.Printf("Failed to watch config file: %v", err)
+		}
+	}
 
 	// Graceful shutdown
 	go func() {
@@ -144,22 +333,63 @@ func main() {
 		<-sigch
 
 		log.Println("Shutting down...")
+		p.BeginShutdown()
+		if shutdownDelay > 0 {
+			time.Sleep(shutdownDelay)
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		if err := server.Shutdown(ctx); err != nil {
+		if err := servers.Shutdown(ctx); err != nil {
 			log.Fatalf("Server shutdown error: %v", err)
 		}
+		if tracerProvider != nil {
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				log.Printf("Tracer shutdown error: %v", err)
+			}
+		}
 	}()
 
-	// Start HTTP server
-	if cfg.Server.TLS {
-		if err := server.ListenAndServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
-	} else {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
+	if err := servers.Wait(); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+// bearerToken authorizes requests carrying token as their bearer token
+func bearerToken(token string) func(*http.Request) bool {
+	want := sha256.Sum256([]byte(token))
+	return func(r *http.Request) bool {
+		given := sha256.Sum256([]byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+		return subtle.ConstantTimeCompare(given[:], want[:]) == 1
+	}
+}
+
+// newTracerProvider builds an OTLP/HTTP span exporter from the tracing config
+func newTracerProvider(cfg config.TracingConfig) *sdktrace.TracerProvider {
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
 	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		log.Fatalf("Failed to create trace exporter: %v", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "reverse-proxy"
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
 }
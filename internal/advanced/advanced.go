@@ -1,8 +1,12 @@
 package advanced
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -10,27 +14,63 @@ import (
 	"github.com/surukanti/reverse-proxy/internal/middleware"
 )
 
-// ABTestManager manages A/B testing
+// ABTestManager manages A/B testing. It is safe for concurrent use.
 type ABTestManager struct {
-	tests map[string]*ABTest
+	mu        sync.RWMutex
+	tests     map[string]*ABTest
+	onPromote func(testName string, winner VariantStats)
 }
 
-// ABTest represents an A/B test
+// ABTest represents an A/B test with two or more variants
 type ABTest struct {
-	Name         string
-	VariantA     *backend.Pool
-	VariantB     *backend.Pool
-	SplitPercent float64 // 0-100, percentage for variant B
-	ErrorRateA   float64
-	ErrorRateB   float64
-	SuccessRateA float64
-	SuccessRateB float64
-	requestsA    int64
-	requestsB    int64
-	successA     int64
-	successB     int64
-	errorsA      int64
-	errorsB      int64
+	Name     string
+	Variants []*Variant
+	mu       sync.RWMutex // guards variant weights once the test is added
+	winner   string       // name of the promoted variant, if any
+}
+
+// Variant is one arm of an A/B test
+type Variant struct {
+	Name      string
+	Pool      *backend.Pool
+	Weight    float64 // share of traffic; a test's weights normally sum to 100
+	requests  int64
+	successes int64
+	errors    int64
+}
+
+// VariantStats reports the traffic and outcomes of one variant
+type VariantStats struct {
+	Name        string
+	Weight      float64
+	Requests    int64
+	Successes   int64
+	Errors      int64
+	SuccessRate float64
+	ErrorRate   float64
+}
+
+// NewABTest creates a two-variant test named "A" and "B" sending
+// splitPercent (clamped to 0-100) of traffic to B
+func NewABTest(name string, variantA, variantB *backend.Pool, splitPercent float64) *ABTest {
+	split := math.Max(0, math.Min(100, splitPercent))
+	return &ABTest{
+		Name: name,
+		Variants: []*Variant{
+			{Name: "A", Pool: variantA, Weight: 100 - split},
+			{Name: "B", Pool: variantB, Weight: split},
+		},
+	}
+}
+
+// variant returns the named variant, or nil if none exists
+func (t *ABTest) variant(name string) *Variant {
+	for _, v := range t.Variants {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
 }
 
 // NewABTestManager creates a new A/B test manager
@@ -40,16 +80,34 @@ func NewABTestManager() *ABTestManager {
 	}
 }
 
-// AddTest adds a new A/B test
+// AddTest adds a new A/B test, replacing any test with the same name.
+// Negative variant weights are treated as zero.
 func (atm *ABTestManager) AddTest(test *ABTest) {
+	for _, v := range test.Variants {
+		v.Weight = math.Max(0, v.Weight)
+	}
+
+	atm.mu.Lock()
+	defer atm.mu.Unlock()
 	atm.tests[test.Name] = test
 }
 
-// SelectVariant selects the variant for a request
-func (atm *ABTestManager) SelectVariant(testName string, req *http.Request) *backend.Pool {
-	test, ok := atm.tests[testName]
-	if !ok {
-		return test.VariantA
+// test returns the named test
+func (atm *ABTestManager) test(name string) (*ABTest, bool) {
+	atm.mu.RLock()
+	defer atm.mu.RUnlock()
+	test, ok := atm.tests[name]
+	return test, ok
+}
+
+// SelectVariant selects the variant for a request, or returns nil if the
+// test doesn't exist or has no variants. The same user always lands on the
+// same variant; users are spread across variants in proportion to their
+// weights.
+func (atm *ABTestManager) SelectVariant(testName string, req *http.Request) *Variant {
+	test, ok := atm.test(testName)
+	if !ok || len(test.Variants) == 0 {
+		return nil
 	}
 
 	// Use user ID or cookie for consistent routing
@@ -60,87 +118,173 @@ func (atm *ABTestManager) SelectVariant(testName string, req *http.Request) *bac
 		}
 	}
 
-	hash := HashString(userID)
-	if hash%100 < int64(test.SplitPercent) {
-		atomic.AddInt64(&test.requestsB, 1)
-		return test.VariantB
+	test.mu.RLock()
+	total := 0.0
+	for _, v := range test.Variants {
+		total += v.Weight
 	}
 
-	atomic.AddInt64(&test.requestsA, 1)
-	return test.VariantA
+	// Map the user onto [0, total) and pick the variant whose weight range
+	// contains it
+	point := float64(HashString(userID)%10000) / 10000 * total
+	selected := test.Variants[len(test.Variants)-1]
+	for _, v := range test.Variants {
+		if point < v.Weight {
+			selected = v
+			break
+		}
+		point -= v.Weight
+	}
+	test.mu.RUnlock()
+
+	atomic.AddInt64(&selected.requests, 1)
+	return selected
 }
 
-// RecordSuccess records a successful request
-func (atm *ABTestManager) RecordSuccess(testName string, variantB bool) {
-	test, ok := atm.tests[testName]
+// RecordSuccess records a successful request to a variant
+func (atm *ABTestManager) RecordSuccess(testName, variant string) {
+	test, ok := atm.test(testName)
 	if !ok {
 		return
 	}
-
-	if variantB {
-		atomic.AddInt64(&test.successB, 1)
-	} else {
-		atomic.AddInt64(&test.successA, 1)
+	if v := test.variant(variant); v != nil {
+		atomic.AddInt64(&v.successes, 1)
 	}
 }
 
-// RecordError records a failed request
-func (atm *ABTestManager) RecordError(testName string, variantB bool) {
-	test, ok := atm.tests[testName]
+// RecordError records a failed request to a variant
+func (atm *ABTestManager) RecordError(testName, variant string) {
+	test, ok := atm.test(testName)
 	if !ok {
 		return
 	}
+	if v := test.variant(variant); v != nil {
+		atomic.AddInt64(&v.errors, 1)
+	}
+}
 
-	if variantB {
-		atomic.AddInt64(&test.errorsB, 1)
-	} else {
-		atomic.AddInt64(&test.errorsA, 1)
+// GetStats returns stats for each variant of a test, or nil if the test
+// doesn't exist
+func (atm *ABTestManager) GetStats(testName string) []VariantStats {
+	test, ok := atm.test(testName)
+	if !ok {
+		return nil
+	}
+
+	test.mu.RLock()
+	defer test.mu.RUnlock()
+
+	stats := make([]VariantStats, 0, len(test.Variants))
+	for _, v := range test.Variants {
+		vs := VariantStats{
+			Name:      v.Name,
+			Weight:    v.Weight,
+			Requests:  atomic.LoadInt64(&v.requests),
+			Successes: atomic.LoadInt64(&v.successes),
+			Errors:    atomic.LoadInt64(&v.errors),
+		}
+		if vs.Requests > 0 {
+			vs.SuccessRate = float64(vs.Successes) / float64(vs.Requests)
+			vs.ErrorRate = float64(vs.Errors) / float64(vs.Requests)
+		}
+		stats = append(stats, vs)
 	}
+	return stats
 }
 
-// GetStats returns stats for a test
-func (atm *ABTestManager) GetStats(testName string) (requestsA, requestsB, successA, successB, errorsA, errorsB int64) {
-	test, ok := atm.tests[testName]
+// OnPromote sets a handler called when EvaluateAndPromote promotes a
+// variant
+func (atm *ABTestManager) OnPromote(handler func(testName string, winner VariantStats)) {
+	atm.mu.Lock()
+	defer atm.mu.Unlock()
+	atm.onPromote = handler
+}
+
+// EvaluateAndPromote promotes the variant with the highest success rate,
+// routing all of the test's traffic to it, once every variant has at least
+// minSamples requests and the winner's success rate beats every other
+// variant's by more than margin (0-1). It returns the promoted variant, or
+// "" if the test has no clear winner yet. Once a test is promoted, later
+// calls return its winner without evaluating it again.
+func (atm *ABTestManager) EvaluateAndPromote(testName string, minSamples int64, margin float64) string {
+	test, ok := atm.test(testName)
 	if !ok {
-		return
+		return ""
+	}
+	test.mu.RLock()
+	promoted := test.winner
+	test.mu.RUnlock()
+	if promoted != "" {
+		return promoted
 	}
 
-	requestsA = atomic.LoadInt64(&test.requestsA)
-	requestsB = atomic.LoadInt64(&test.requestsB)
-	successA = atomic.LoadInt64(&test.successA)
-	successB = atomic.LoadInt64(&test.successB)
-	errorsA = atomic.LoadInt64(&test.errorsA)
-	errorsB = atomic.LoadInt64(&test.errorsB)
+	stats := atm.GetStats(testName)
+	if len(stats) < 2 {
+		return ""
+	}
 
-	// Calculate rates
-	if requestsA > 0 {
-		test.SuccessRateA = float64(successA) / float64(requestsA)
-		test.ErrorRateA = float64(errorsA) / float64(requestsA)
+	best, runnerUp := -1, -1
+	for i, vs := range stats {
+		if vs.Requests < minSamples {
+			return ""
+		}
+		switch {
+		case best < 0 || vs.SuccessRate > stats[best].SuccessRate:
+			best, runnerUp = i, best
+		case runnerUp < 0 || vs.SuccessRate > stats[runnerUp].SuccessRate:
+			runnerUp = i
+		}
 	}
-	if requestsB > 0 {
-		test.SuccessRateB = float64(successB) / float64(requestsB)
-		test.ErrorRateB = float64(errorsB) / float64(requestsB)
+	if stats[best].SuccessRate-stats[runnerUp].SuccessRate <= margin {
+		return ""
 	}
 
-	return
+	winner := stats[best]
+	test.mu.Lock()
+	if test.winner != "" {
+		test.mu.Unlock()
+		return test.winner
+	}
+	test.winner = winner.Name
+	for _, v := range test.Variants {
+		v.Weight = 0
+		if v.Name == winner.Name {
+			v.Weight = 100
+		}
+	}
+	test.mu.Unlock()
+	winner.Weight = 100
+
+	atm.mu.RLock()
+	onPromote := atm.onPromote
+	atm.mu.RUnlock()
+	if onPromote != nil {
+		onPromote(testName, winner)
+	}
+	return winner.Name
 }
 
-// BlueGreenManager manages blue-green deployments
+// BlueGreenManager manages blue-green deployments. It is safe for
+// concurrent use.
 type BlueGreenManager struct {
-	blue          *backend.Pool
-	green         *backend.Pool
-	activeVersion string  // "blue" or "green"
-	trafficShift  float64 // 0-100, percentage to shift to new version
-	startTime     time.Time
-	shiftDuration time.Duration
+	mu              sync.RWMutex
+	blue            *backend.Pool
+	green           *backend.Pool
+	activeVersion   string  // "blue" or "green"
+	previousVersion string  // version active before the last shift or switch
+	trafficShift    float64 // 0-100, percentage shifted away from the active version
+	startTime       time.Time
+	shiftDuration   time.Duration
+	stopShift       chan struct{} // closed to stop an in-progress gradual shift
 }
 
 // NewBlueGreenManager creates a new blue-green manager
 func NewBlueGreenManager(blue, green *backend.Pool) *BlueGreenManager {
 	return &BlueGreenManager{
-		blue:          blue,
-		green:         green,
-		activeVersion: "blue",
+		blue:            blue,
+		green:           green,
+		activeVersion:   "blue",
+		previousVersion: "blue",
 	}
 }
 
@@ -154,6 +298,9 @@ func (bgm *BlueGreenManager) SelectBackend(req *http.Request) *backend.Pool {
 		}
 	}
 
+	bgm.mu.RLock()
+	defer bgm.mu.RUnlock()
+
 	hash := HashString(userID)
 	if hash%100 < int64(bgm.trafficShift) {
 		if bgm.activeVersion == "blue" {
@@ -168,31 +315,97 @@ func (bgm *BlueGreenManager) SelectBackend(req *http.Request) *backend.Pool {
 	return bgm.green
 }
 
-// StartGradualShift starts a gradual traffic shift
+// StartGradualShift starts a gradual traffic shift, replacing any shift in
+// progress
 func (bgm *BlueGreenManager) StartGradualShift(targetVersion string, duration time.Duration) {
+	bgm.mu.Lock()
+	defer bgm.mu.Unlock()
+
+	bgm.cancelShift()
+	bgm.previousVersion = bgm.activeVersion
 	bgm.startTime = time.Now()
 	bgm.shiftDuration = duration
+	stop := make(chan struct{})
+	bgm.stopShift = stop
 
 	go func() {
 		ticker := time.NewTicker(100 * time.Millisecond)
 		defer ticker.Stop()
 
-		for range ticker.C {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			bgm.mu.Lock()
+			// A newer shift, switch or rollback may have won the lock
+			select {
+			case <-stop:
+				bgm.mu.Unlock()
+				return
+			default:
+			}
 			elapsed := time.Since(bgm.startTime)
 			if elapsed >= duration {
-				bgm.trafficShift = 100
 				bgm.activeVersion = targetVersion
+				bgm.trafficShift = 0
+				bgm.stopShift = nil
+				bgm.mu.Unlock()
 				return
 			}
-
-			progress := float64(elapsed.Milliseconds()) / float64(duration.Milliseconds())
-			bgm.trafficShift = progress * 100
+			bgm.trafficShift = float64(elapsed.Milliseconds()) / float64(duration.Milliseconds()) * 100
+			bgm.mu.Unlock()
 		}
 	}()
 }
 
+// SwitchNow sends all traffic to targetVersion immediately, stopping any
+// gradual shift in progress
+func (bgm *BlueGreenManager) SwitchNow(targetVersion string) {
+	bgm.mu.Lock()
+	defer bgm.mu.Unlock()
+
+	bgm.cancelShift()
+	bgm.previousVersion = bgm.activeVersion
+	bgm.activeVersion = targetVersion
+	bgm.trafficShift = 0
+}
+
+// Rollback stops any gradual shift in progress and sends all traffic back
+// to the version that was active before the last shift or switch
+func (bgm *BlueGreenManager) Rollback() {
+	bgm.mu.Lock()
+	defer bgm.mu.Unlock()
+
+	bgm.cancelShift()
+	bgm.activeVersion = bgm.previousVersion
+	bgm.trafficShift = 0
+}
+
+// Stop stops any gradual shift in progress, leaving traffic where it is.
+// Call it when the manager is no longer used.
+func (bgm *BlueGreenManager) Stop() {
+	bgm.mu.Lock()
+	defer bgm.mu.Unlock()
+	bgm.cancelShift()
+}
+
+// cancelShift stops the gradual shift goroutine, if any (must be called
+// with the lock held)
+func (bgm *BlueGreenManager) cancelShift() {
+	if bgm.stopShift != nil {
+		close(bgm.stopShift)
+		bgm.stopShift = nil
+	}
+}
+
 // GetStatus returns the current status
 func (bgm *BlueGreenManager) GetStatus() map[string]interface{} {
+	bgm.mu.RLock()
+	defer bgm.mu.RUnlock()
+
 	return map[string]interface{}{
 		"active_version": bgm.activeVersion,
 		"traffic_shift":  bgm.trafficShift,
@@ -201,8 +414,22 @@ func (bgm *BlueGreenManager) GetStatus() map[string]interface{} {
 	}
 }
 
-// CircuitBreaker implements circuit breaker pattern
+// ErrCircuitOpen is returned by CircuitBreaker.Call while the breaker is
+// open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker implements circuit breaker pattern. It is safe for
+// concurrent use; fn runs outside the lock, so calls proceed in parallel.
 type CircuitBreaker struct {
+	// OnStateChange, if set, is called after every state transition, outside
+	// the lock. Set it before the breaker is used.
+	OnStateChange func(from, to string)
+	// Fallback, if set, is called in place of a rejected call and its result
+	// returned by Call instead of ErrCircuitOpen. Set it before the breaker
+	// is used.
+	Fallback func() error
+
+	mu               sync.Mutex
 	state            string // "closed", "open", "half-open"
 	failureCount     int64
 	successCount     int64
@@ -210,8 +437,14 @@ type CircuitBreaker struct {
 	successThreshold int64
 	timeout          time.Duration
 	lastFailureTime  time.Time
+	maxTrials        int64 // concurrent calls admitted while half-open
+	trials           int64 // half-open calls in flight
 }
 
+// DefaultHalfOpenTrials is how many calls a half-open breaker admits at once
+// unless set with SetHalfOpenTrials
+const DefaultHalfOpenTrials = 1
+
 // NewCircuitBreaker creates a new circuit breaker
 func NewCircuitBreaker(failureThreshold, successThreshold int64, timeout time.Duration) *CircuitBreaker {
 	return &CircuitBreaker{
@@ -219,51 +452,149 @@ func NewCircuitBreaker(failureThreshold, successThreshold int64, timeout time.Du
 		failureThreshold: failureThreshold,
 		successThreshold: successThreshold,
 		timeout:          timeout,
+		maxTrials:        DefaultHalfOpenTrials,
 	}
 }
 
-// Call executes a call with circuit breaker protection
+// SetHalfOpenTrials sets how many trial calls a half-open breaker lets
+// through at once; the rest are rejected as if it were open
+func (cb *CircuitBreaker) SetHalfOpenTrials(n int64) {
+	if n < 1 {
+		n = DefaultHalfOpenTrials
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.maxTrials = n
+}
+
+// stateChange is a transition to report to OnStateChange
+type stateChange struct {
+	from, to string
+}
+
+// Call executes a call with circuit breaker protection. While the breaker
+// is open, fn isn't called and Call returns the result of Fallback, or
+// ErrCircuitOpen if there is none.
 func (cb *CircuitBreaker) Call(fn func() error) error {
-	if cb.state == "open" {
-		if time.Since(cb.lastFailureTime) > cb.timeout {
-			cb.state = "half-open"
-			cb.successCount = 0
-		} else {
-			return fmt.Errorf("circuit breaker is open")
+	trial, change, err := cb.admit()
+	cb.notify(change)
+	if err != nil {
+		if cb.Fallback != nil {
+			return cb.Fallback()
 		}
+		return err
 	}
 
-	err := fn()
+	err = fn()
+	cb.notify(cb.record(err, trial))
+	return err
+}
 
-	if err != nil {
-		atomic.AddInt64(&cb.failureCount, 1)
-		cb.lastFailureTime = time.Now()
+// setState moves the breaker to state, returning the transition if it
+// changed. Must be called with mu held.
+func (cb *CircuitBreaker) setState(state string) *stateChange {
+	if cb.state == state {
+		return nil
+	}
+	change := &stateChange{from: cb.state, to: state}
+	cb.state = state
+	return change
+}
 
-		if atomic.LoadInt64(&cb.failureCount) >= cb.failureThreshold {
-			cb.state = "open"
-		}
+// notify reports change to OnStateChange, if both are set
+func (cb *CircuitBreaker) notify(change *stateChange) {
+	if change != nil && cb.OnStateChange != nil {
+		cb.OnStateChange(change.from, change.to)
+	}
+}
 
-		return err
+// admit rejects a call while the breaker is open, moving it to half-open
+// once the timeout has passed. Half-open calls beyond the trial limit are
+// rejected too; trial reports whether the admitted call counts against it.
+func (cb *CircuitBreaker) admit() (trial bool, change *stateChange, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == "open" {
+		if time.Since(cb.lastFailureTime) <= cb.timeout {
+			return false, nil, ErrCircuitOpen
+		}
+		change = cb.setState("half-open")
+		cb.successCount = 0
+	}
+	if cb.state == "half-open" {
+		if cb.trials >= cb.maxTrials {
+			return false, change, ErrCircuitOpen
+		}
+		cb.trials++
+		return true, change, nil
 	}
+	return false, nil, nil
+}
 
-	atomic.AddInt64(&cb.successCount, 1)
+// record updates the state machine with the outcome of a call, returning
+// the transition it caused, if any
+func (cb *CircuitBreaker) record(err error, trial bool) *stateChange {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
 
-	if cb.state == "half-open" && atomic.LoadInt64(&cb.successCount) >= cb.successThreshold {
-		cb.state = "closed"
-		atomic.StoreInt64(&cb.failureCount, 0)
+	if trial {
+		cb.trials--
+	}
+	if err != nil {
+		cb.failureCount++
+		cb.lastFailureTime = time.Now()
+		if cb.failureCount >= cb.failureThreshold {
+			return cb.setState("open")
+		}
+		return nil
 	}
 
+	cb.successCount++
+	switch cb.state {
+	case "closed":
+		// Only consecutive failures open the breaker
+		cb.failureCount = 0
+	case "half-open":
+		if cb.successCount >= cb.successThreshold {
+			cb.failureCount = 0
+			return cb.setState("closed")
+		}
+	}
 	return nil
 }
 
+// Open reports whether Call would currently reject calls: the breaker is
+// open and its timeout hasn't elapsed, or it is half-open with every trial
+// slot taken
+func (cb *CircuitBreaker) Open() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case "open":
+		return time.Since(cb.lastFailureTime) <= cb.timeout
+	case "half-open":
+		return cb.trials >= cb.maxTrials
+	}
+	return false
+}
+
 // GetState returns the current state
 func (cb *CircuitBreaker) GetState() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
 	return cb.state
 }
 
-// RateLimitByTenant implements per-tenant rate limiting
+// TenantRateLimiter implements per-tenant rate limiting. It is safe for
+// concurrent use.
 type TenantRateLimiter struct {
+	mu           sync.RWMutex
 	tenantLimits map[string]*middleware.RateLimiter
+	// defaultLimit applies to tenants without a limit of their own, each
+	// getting its own buckets; nil allows them everything
+	defaultLimit *middleware.RateLimiter
+	sweepCtx     context.Context // set by Start
 }
 
 // NewTenantRateLimiter creates a new tenant rate limiter
@@ -273,29 +604,96 @@ func NewTenantRateLimiter() *TenantRateLimiter {
 	}
 }
 
-// SetTenantLimit sets the rate limit for a tenant
+// SetTenantLimit sets the rate limit for a tenant. Changing an existing
+// tenant's limit keeps the state of its buckets.
 func (trl *TenantRateLimiter) SetTenantLimit(tenantID string, maxRequests int, window time.Duration) {
+	trl.mu.Lock()
+	defer trl.mu.Unlock()
+
+	if limiter, ok := trl.tenantLimits[tenantID]; ok {
+		limiter.SetLimit(maxRequests, window)
+		return
+	}
 	trl.tenantLimits[tenantID] = middleware.NewRateLimiter(maxRequests, window)
 }
 
+// RemoveTenant removes a tenant's limit; it falls back to the default limit
+func (trl *TenantRateLimiter) RemoveTenant(tenantID string) {
+	trl.mu.Lock()
+	defer trl.mu.Unlock()
+	delete(trl.tenantLimits, tenantID)
+}
+
+// SetDefaultLimit sets the rate limit for tenants without one of their own.
+// A maxRequests of 0 or less removes it, allowing them every request.
+func (trl *TenantRateLimiter) SetDefaultLimit(maxRequests int, window time.Duration) {
+	trl.mu.Lock()
+	defer trl.mu.Unlock()
+
+	switch {
+	case maxRequests <= 0:
+		if trl.defaultLimit != nil {
+			trl.defaultLimit.Stop()
+		}
+		trl.defaultLimit = nil
+	case trl.defaultLimit != nil:
+		trl.defaultLimit.SetLimit(maxRequests, window)
+	default:
+		trl.defaultLimit = middleware.NewRateLimiter(maxRequests, window)
+		if trl.sweepCtx != nil {
+			trl.defaultLimit.Start(trl.sweepCtx)
+		}
+	}
+}
+
+// Start evicts the idle buckets of tenants under the default limit until
+// ctx is done. Without it they are kept forever, one per tenant ever seen.
+func (trl *TenantRateLimiter) Start(ctx context.Context) {
+	trl.mu.Lock()
+	defer trl.mu.Unlock()
+
+	trl.sweepCtx = ctx
+	if trl.defaultLimit != nil {
+		trl.defaultLimit.Start(ctx)
+	}
+}
+
+// HasLimit reports whether tenantID has a limit of its own
+func (trl *TenantRateLimiter) HasLimit(tenantID string) bool {
+	trl.mu.RLock()
+	defer trl.mu.RUnlock()
+	_, ok := trl.tenantLimits[tenantID]
+	return ok
+}
+
 // Check checks if a request is allowed for a tenant
 func (trl *TenantRateLimiter) Check(tenantID, identifier string) bool {
+	result, limited := trl.Limit(tenantID, identifier)
+	return !limited || result.Allowed
+}
+
+// Limit consumes a request from identifier's quota within the tenant and
+// reports the state of its bucket. limited is false, and the request
+// allowed, if neither the tenant nor the default has a limit.
+func (trl *TenantRateLimiter) Limit(tenantID, identifier string) (result middleware.RateLimitResult, limited bool) {
+	trl.mu.RLock()
 	limiter, ok := trl.tenantLimits[tenantID]
+	if !ok && trl.defaultLimit != nil {
+		// Tenants share the default limiter, so keep their buckets apart
+		limiter, ok = trl.defaultLimit, true
+		identifier = tenantID + "\x00" + identifier
+	}
+	trl.mu.RUnlock()
 	if !ok {
-		return true // No limit set
+		return middleware.RateLimitResult{Allowed: true}, false
 	}
 
-	return limiter.Handle(identifier)
+	return limiter.Check(identifier), true
 }
 
-// HashString is a simple hash function for consistent routing
+// HashString hashes a string with FNV-1a for consistent routing
 func HashString(s string) int64 {
-	hash := int64(0)
-	for _, c := range s {
-		hash = hash*31 + int64(c)
-	}
-	if hash < 0 {
-		hash = -hash
-	}
-	return hash
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return int64(h.Sum64() & math.MaxInt64)
 }
@@ -1,6 +1,13 @@
 package advanced
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +21,215 @@ func TestABTestManager(t *testing.T) {
 	}
 }
 
+func userRequest(id int) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", fmt.Sprintf("user-%d", id))
+	return req
+}
+
+func TestABTestManagerSelectVariant(t *testing.T) {
+	manager := NewABTestManager()
+	variantA, variantB := backend.NewPool(), backend.NewPool()
+	manager.AddTest(NewABTest("checkout", variantA, variantB, 50))
+
+	for i := 0; i < 100; i++ {
+		v := manager.SelectVariant("checkout", userRequest(i))
+		if v.Name == "B" {
+			manager.RecordSuccess("checkout", v.Name)
+		} else {
+			manager.RecordError("checkout", v.Name)
+		}
+		if again := manager.SelectVariant("checkout", userRequest(i)); again != v {
+			t.Fatal("expected a user to keep landing on the same variant")
+		}
+	}
+
+	stats := manager.GetStats("checkout")
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 variants, got %d", len(stats))
+	}
+	a, b := stats[0], stats[1]
+	if a.Requests+b.Requests != 200 || a.Requests == 0 || b.Requests == 0 {
+		t.Fatalf("expected requests split across both variants, got %d and %d", a.Requests, b.Requests)
+	}
+	if a.Successes != 0 || b.Successes != b.Requests/2 || a.Errors != a.Requests/2 || b.Errors != 0 {
+		t.Errorf("unexpected counts: A %+v, B %+v", a, b)
+	}
+	if a.ErrorRate != 0.5 || b.SuccessRate != 0.5 {
+		t.Errorf("unexpected rates: A %+v, B %+v", a, b)
+	}
+}
+
+func TestABTestManagerMultipleVariants(t *testing.T) {
+	manager := NewABTestManager()
+	manager.AddTest(&ABTest{
+		Name: "pricing",
+		Variants: []*Variant{
+			{Name: "control", Pool: backend.NewPool(), Weight: 50},
+			{Name: "discount", Pool: backend.NewPool(), Weight: 30},
+			{Name: "bundle", Pool: backend.NewPool(), Weight: 20},
+		},
+	})
+
+	const users = 10000
+	for i := 0; i < users; i++ {
+		manager.SelectVariant("pricing", userRequest(i))
+	}
+
+	for _, vs := range manager.GetStats("pricing") {
+		share := float64(vs.Requests) / users * 100
+		if share < vs.Weight-3 || share > vs.Weight+3 {
+			t.Errorf("expected variant %s to get about %v%% of users, got %.1f%%", vs.Name, vs.Weight, share)
+		}
+	}
+}
+
+func TestABTestManagerSelectVariantMissingTest(t *testing.T) {
+	manager := NewABTestManager()
+	req, _ := http.NewRequest("GET", "/", nil)
+	if v := manager.SelectVariant("missing", req); v != nil {
+		t.Error("expected no variant for an unknown test")
+	}
+	if stats := manager.GetStats("missing"); stats != nil {
+		t.Error("expected no stats for an unknown test")
+	}
+}
+
+func TestABTestManagerClampsSplit(t *testing.T) {
+	manager := NewABTestManager()
+	variantA, variantB := backend.NewPool(), backend.NewPool()
+	manager.AddTest(NewABTest("all-b", variantA, variantB, 150))
+	manager.AddTest(NewABTest("all-a", variantA, variantB, -20))
+
+	for i := 0; i < 50; i++ {
+		if v := manager.SelectVariant("all-b", userRequest(i)); v.Pool != variantB {
+			t.Fatal("expected a split above 100 to send everyone to variant B")
+		}
+		if v := manager.SelectVariant("all-a", userRequest(i)); v.Pool != variantA {
+			t.Fatal("expected a split below 0 to send everyone to variant A")
+		}
+	}
+}
+
+// recordOutcomes records successes out of requests for variant
+func recordOutcomes(manager *ABTestManager, testName, variant string, requests, successes int) {
+	for i := 0; i < requests; i++ {
+		if i < successes {
+			manager.RecordSuccess(testName, variant)
+		} else {
+			manager.RecordError(testName, variant)
+		}
+	}
+}
+
+func TestABTestManagerEvaluateAndPromote(t *testing.T) {
+	manager := NewABTestManager()
+	variantA, variantB := backend.NewPool(), backend.NewPool()
+	manager.AddTest(NewABTest("checkout", variantA, variantB, 50))
+
+	var promoted []VariantStats
+	manager.OnPromote(func(testName string, winner VariantStats) {
+		if testName != "checkout" {
+			t.Errorf("unexpected test %s", testName)
+		}
+		promoted = append(promoted, winner)
+	})
+
+	for i := 0; i < 400; i++ {
+		manager.SelectVariant("checkout", userRequest(i))
+	}
+	var requestsA, requestsB int
+	for _, vs := range manager.GetStats("checkout") {
+		if vs.Name == "A" {
+			requestsA = int(vs.Requests)
+		} else {
+			requestsB = int(vs.Requests)
+		}
+	}
+	recordOutcomes(manager, "checkout", "A", requestsA, requestsA*70/100)
+	recordOutcomes(manager, "checkout", "B", requestsB, requestsB*95/100)
+
+	if winner := manager.EvaluateAndPromote("checkout", 1000, 0.1); winner != "" {
+		t.Fatalf("expected no promotion below the sample threshold, got %s", winner)
+	}
+	if winner := manager.EvaluateAndPromote("checkout", 100, 0.5); winner != "" {
+		t.Fatalf("expected no promotion within the margin, got %s", winner)
+	}
+	if winner := manager.EvaluateAndPromote("checkout", 100, 0.1); winner != "B" {
+		t.Fatalf("expected variant B to be promoted, got %q", winner)
+	}
+
+	if len(promoted) != 1 || promoted[0].Name != "B" || promoted[0].Weight != 100 {
+		t.Errorf("expected one promotion event for B, got %+v", promoted)
+	}
+	for i := 0; i < 100; i++ {
+		if v := manager.SelectVariant("checkout", userRequest(i)); v.Pool != variantB {
+			t.Fatal("expected all traffic to go to the winner")
+		}
+	}
+
+	if winner := manager.EvaluateAndPromote("checkout", 100, 0.1); winner != "B" || len(promoted) != 1 {
+		t.Errorf("expected a promoted test to keep its winner without a new event, got %q", winner)
+	}
+}
+
+func TestABTestManagerEvaluateMultipleVariants(t *testing.T) {
+	manager := NewABTestManager()
+	manager.AddTest(&ABTest{
+		Name: "pricing",
+		Variants: []*Variant{
+			{Name: "control", Pool: backend.NewPool(), Weight: 34},
+			{Name: "discount", Pool: backend.NewPool(), Weight: 33},
+			{Name: "bundle", Pool: backend.NewPool(), Weight: 33},
+		},
+	})
+	for i := 0; i < 3000; i++ {
+		manager.SelectVariant("pricing", userRequest(i))
+	}
+	for _, vs := range manager.GetStats("pricing") {
+		rate := map[string]int{"control": 80, "discount": 85, "bundle": 60}[vs.Name]
+		recordOutcomes(manager, "pricing", vs.Name, int(vs.Requests), int(vs.Requests)*rate/100)
+	}
+
+	if winner := manager.EvaluateAndPromote("pricing", 100, 0.1); winner != "" {
+		t.Errorf("expected no promotion when the runner-up is within the margin, got %s", winner)
+	}
+	if winner := manager.EvaluateAndPromote("pricing", 100, 0.02); winner != "discount" {
+		t.Errorf("expected discount to be promoted, got %q", winner)
+	}
+}
+
+func TestABTestManagerConcurrentUse(t *testing.T) {
+	manager := NewABTestManager()
+	pool := backend.NewPool()
+	for j := 0; j < 10; j++ {
+		manager.AddTest(NewABTest(fmt.Sprintf("test-%d", j), pool, pool, 50))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				manager.AddTest(NewABTest(fmt.Sprintf("test-%d", j%10), pool, pool, 50))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("test-%d", j%10)
+				v := manager.SelectVariant(name, userRequest(j))
+				manager.RecordSuccess(name, v.Name)
+				manager.RecordError(name, v.Name)
+				manager.GetStats(name)
+				manager.EvaluateAndPromote(name, 10, 0.5)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestCircuitBreaker(t *testing.T) {
 	cb := NewCircuitBreaker(5, 3, 1*time.Second)
 	if cb == nil {
@@ -21,6 +237,210 @@ func TestCircuitBreaker(t *testing.T) {
 	}
 }
 
+func TestCircuitBreakerOpen(t *testing.T) {
+	cb := NewCircuitBreaker(2, 1, 50*time.Millisecond)
+	failure := errors.New("upstream failed")
+
+	cb.Call(func() error { return failure })
+	if cb.Open() {
+		t.Fatal("expected the breaker to stay closed below the threshold")
+	}
+	cb.Call(func() error { return failure })
+	if !cb.Open() {
+		t.Fatal("expected the breaker to open at the threshold")
+	}
+	if err := cb.Call(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if cb.Open() {
+		t.Error("expected the breaker to admit a trial call after its timeout")
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	cb := NewCircuitBreaker(2, 1, time.Minute)
+	failure := errors.New("upstream failed")
+
+	for i := 0; i < 10; i++ {
+		cb.Call(func() error { return failure })
+		cb.Call(func() error { return nil })
+	}
+	if state := cb.GetState(); state != "closed" {
+		t.Fatalf("expected alternating results to keep the breaker closed, got %s", state)
+	}
+
+	cb.Call(func() error { return failure })
+	cb.Call(func() error { return failure })
+	if state := cb.GetState(); state != "open" {
+		t.Fatalf("expected consecutive failures to open the breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerConcurrentCalls(t *testing.T) {
+	cb := NewCircuitBreaker(5, 3, time.Millisecond)
+	failure := errors.New("upstream failed")
+	valid := map[string]bool{"closed": true, "open": true, "half-open": true}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				cb.Call(func() error {
+					if (i+j)%3 == 0 {
+						return failure
+					}
+					return nil
+				})
+				if state := cb.GetState(); !valid[state] {
+					t.Errorf("invalid state %q", state)
+					return
+				}
+				cb.Open()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCircuitBreakerHalfOpenCloses(t *testing.T) {
+	cb := NewCircuitBreaker(1, 2, 20*time.Millisecond)
+	cb.Call(func() error { return errors.New("upstream failed") })
+	if state := cb.GetState(); state != "open" {
+		t.Fatalf("expected open, got %s", state)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	cb.Call(func() error { return nil })
+	if state := cb.GetState(); state != "half-open" {
+		t.Fatalf("expected half-open after one trial success, got %s", state)
+	}
+	cb.Call(func() error { return nil })
+	if state := cb.GetState(); state != "closed" {
+		t.Fatalf("expected closed after enough trial successes, got %s", state)
+	}
+}
+
+// openBreaker returns a breaker that has just left its open timeout, so
+// the next call makes it half-open
+func openBreaker(t *testing.T, successThreshold, trials int64) *CircuitBreaker {
+	t.Helper()
+	cb := NewCircuitBreaker(1, successThreshold, 10*time.Millisecond)
+	cb.SetHalfOpenTrials(trials)
+	cb.Call(func() error { return errors.New("upstream failed") })
+	time.Sleep(20 * time.Millisecond)
+	return cb
+}
+
+func TestCircuitBreakerHalfOpenTrialLimit(t *testing.T) {
+	cb := openBreaker(t, 2, 2)
+
+	release := make(chan struct{})
+	var entered, rejected int64
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cb.Call(func() error {
+				atomic.AddInt64(&entered, 1)
+				<-release
+				return nil
+			})
+			if errors.Is(err, ErrCircuitOpen) {
+				atomic.AddInt64(&rejected, 1)
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(&entered)+atomic.LoadInt64(&rejected) < 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&entered); n != 2 {
+		t.Errorf("expected 2 trial calls through at once, got %d", n)
+	}
+	if !cb.Open() {
+		t.Error("expected a half-open breaker with no free trial slot to report open")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt64(&rejected); n != 4 {
+		t.Errorf("expected the other 4 calls to be rejected, got %d", n)
+	}
+	if state := cb.GetState(); state != "closed" {
+		t.Errorf("expected the successful trials to close the breaker, got %s", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrialFailureReopens(t *testing.T) {
+	cb := openBreaker(t, 1, 1)
+
+	if err := cb.Call(func() error { return errors.New("still failing") }); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected the trial call to be admitted")
+	}
+	if state := cb.GetState(); state != "open" {
+		t.Errorf("expected a failed trial to reopen the breaker, got %s", state)
+	}
+	if err := cb.Call(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected calls to be rejected after reopening, got %v", err)
+	}
+}
+
+func TestCircuitBreakerOnStateChange(t *testing.T) {
+	cb := NewCircuitBreaker(2, 1, 10*time.Millisecond)
+	var transitions []string
+	cb.OnStateChange = func(from, to string) {
+		transitions = append(transitions, from+"->"+to)
+	}
+
+	fail := func() error { return errors.New("upstream failed") }
+	cb.Call(fail)
+	cb.Call(fail)
+	cb.Call(fail) // rejected while open
+	time.Sleep(20 * time.Millisecond)
+	cb.Call(func() error { return nil })
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	cb := NewCircuitBreaker(1, 1, time.Minute)
+	errFallback := errors.New("served from fallback")
+	var fallbacks int
+	cb.Fallback = func() error {
+		fallbacks++
+		return errFallback
+	}
+
+	upstreamErr := errors.New("upstream failed")
+	if err := cb.Call(func() error { return upstreamErr }); err != upstreamErr {
+		t.Fatalf("expected the call's error while closed, got %v", err)
+	}
+	if fallbacks != 0 {
+		t.Fatal("expected no fallback while the breaker is closed")
+	}
+
+	called := false
+	err := cb.Call(func() error {
+		called = true
+		return nil
+	})
+	if err != errFallback || fallbacks != 1 {
+		t.Errorf("expected the fallback's result while open, got %v after %d fallbacks", err, fallbacks)
+	}
+	if called {
+		t.Error("expected the call to be skipped while open")
+	}
+}
+
 func TestBlueGreenManager(t *testing.T) {
 	pool1 := backend.NewPool()
 	pool2 := backend.NewPool()
@@ -30,9 +450,223 @@ func TestBlueGreenManager(t *testing.T) {
 	}
 }
 
+// assertAllTo checks that every user is routed to pool
+func assertAllTo(t *testing.T, manager *BlueGreenManager, pool *backend.Pool, msg string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if manager.SelectBackend(userRequest(i)) != pool {
+			t.Fatal(msg)
+		}
+	}
+}
+
+func TestBlueGreenSwitchNow(t *testing.T) {
+	blue, green := backend.NewPool(), backend.NewPool()
+	manager := NewBlueGreenManager(blue, green)
+
+	manager.SwitchNow("green")
+	assertAllTo(t, manager, green, "expected all traffic on green after switching")
+	if status := manager.GetStatus(); status["active_version"] != "green" {
+		t.Errorf("expected green to be active, got %v", status["active_version"])
+	}
+
+	manager.Rollback()
+	assertAllTo(t, manager, blue, "expected all traffic back on blue after rollback")
+}
+
+func TestBlueGreenRollbackGradualShift(t *testing.T) {
+	blue, green := backend.NewPool(), backend.NewPool()
+	manager := NewBlueGreenManager(blue, green)
+
+	manager.StartGradualShift("green", time.Second)
+	time.Sleep(450 * time.Millisecond)
+	shift := manager.GetStatus()["traffic_shift"].(float64)
+	if shift <= 0 || shift >= 100 {
+		t.Fatalf("expected the shift to be partway through, got %v", shift)
+	}
+
+	manager.Rollback()
+	assertAllTo(t, manager, blue, "expected all traffic back on blue after rollback")
+
+	// The shift must not resume or complete after being rolled back
+	time.Sleep(800 * time.Millisecond)
+	status := manager.GetStatus()
+	if status["active_version"] != "blue" || status["traffic_shift"].(float64) != 0 {
+		t.Errorf("expected the rolled back shift to stay stopped, got %v", status)
+	}
+	assertAllTo(t, manager, blue, "expected traffic to stay on blue")
+}
+
+func TestBlueGreenGradualShiftCompletes(t *testing.T) {
+	blue, green := backend.NewPool(), backend.NewPool()
+	manager := NewBlueGreenManager(blue, green)
+
+	manager.StartGradualShift("green", 200*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	assertAllTo(t, manager, green, "expected all traffic on green once the shift completes")
+
+	manager.Rollback()
+	assertAllTo(t, manager, blue, "expected rollback to return to blue after a completed shift")
+}
+
+func TestBlueGreenShiftConcurrentWithSelect(t *testing.T) {
+	blue, green := backend.NewPool(), backend.NewPool()
+	manager := NewBlueGreenManager(blue, green)
+	manager.StartGradualShift("green", 300*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deadline := time.Now().Add(400 * time.Millisecond)
+			for j := 0; time.Now().Before(deadline); j++ {
+				if pool := manager.SelectBackend(userRequest(j)); pool != blue && pool != green {
+					t.Error("expected blue or green")
+					return
+				}
+				manager.GetStatus()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertAllTo(t, manager, green, "expected all traffic on green once the shift completes")
+}
+
+func TestBlueGreenStop(t *testing.T) {
+	blue, green := backend.NewPool(), backend.NewPool()
+	manager := NewBlueGreenManager(blue, green)
+
+	manager.StartGradualShift("green", 300*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	manager.Stop()
+	shift := manager.GetStatus()["traffic_shift"]
+
+	time.Sleep(300 * time.Millisecond)
+	status := manager.GetStatus()
+	if status["traffic_shift"] != shift || status["active_version"] != "blue" {
+		t.Errorf("expected the shift to stop where it was, got %v", status)
+	}
+}
+
 func TestTenantRateLimiter(t *testing.T) {
 	limiter := NewTenantRateLimiter()
 	if limiter == nil {
 		t.Fatal("expected tenant rate limiter to be non-nil")
 	}
 }
+
+// allowedOf counts how many of n requests from identifier are allowed
+func allowedOf(limiter *TenantRateLimiter, tenantID, identifier string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if limiter.Check(tenantID, identifier) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTenantRateLimiterUpdateKeepsBuckets(t *testing.T) {
+	limiter := NewTenantRateLimiter()
+	limiter.SetTenantLimit("acme", 3, time.Hour)
+	if n := allowedOf(limiter, "acme", "client", 5); n != 3 {
+		t.Fatalf("expected 3 of 5 requests allowed, got %d", n)
+	}
+
+	limiter.SetTenantLimit("acme", 5, time.Hour)
+	if n := allowedOf(limiter, "acme", "client", 5); n != 2 {
+		t.Errorf("expected only the raised headroom to be allowed, got %d", n)
+	}
+}
+
+func TestTenantRateLimiterRemoveTenant(t *testing.T) {
+	limiter := NewTenantRateLimiter()
+	limiter.SetTenantLimit("acme", 1, time.Hour)
+	allowedOf(limiter, "acme", "client", 1)
+
+	limiter.RemoveTenant("acme")
+	if n := allowedOf(limiter, "acme", "client", 10); n != 10 {
+		t.Errorf("expected a removed tenant to be unlimited, got %d of 10 allowed", n)
+	}
+}
+
+func TestTenantRateLimiterDefaultLimit(t *testing.T) {
+	limiter := NewTenantRateLimiter()
+	if n := allowedOf(limiter, "unknown", "client", 10); n != 10 {
+		t.Fatalf("expected unknown tenants to be unlimited without a default, got %d of 10", n)
+	}
+
+	limiter.SetDefaultLimit(2, time.Hour)
+	limiter.SetTenantLimit("acme", 5, time.Hour)
+	if n := allowedOf(limiter, "other", "client", 5); n != 2 {
+		t.Errorf("expected the default limit for a tenant without one, got %d of 5", n)
+	}
+	if n := allowedOf(limiter, "another", "client", 5); n != 2 {
+		t.Errorf("expected tenants not to share default buckets, got %d of 5", n)
+	}
+	if n := allowedOf(limiter, "acme", "client", 10); n != 5 {
+		t.Errorf("expected the tenant's own limit to apply, got %d of 10", n)
+	}
+
+	limiter.SetDefaultLimit(0, 0)
+	if n := allowedOf(limiter, "other", "client", 10); n != 10 {
+		t.Errorf("expected clearing the default to allow everything, got %d of 10", n)
+	}
+}
+
+func TestTenantRateLimiterHasLimit(t *testing.T) {
+	limiter := NewTenantRateLimiter()
+	limiter.SetDefaultLimit(1, time.Hour)
+	limiter.SetTenantLimit("acme", 1, time.Hour)
+
+	if !limiter.HasLimit("acme") {
+		t.Error("expected a tenant with its own limit to have one")
+	}
+	if limiter.HasLimit("other") {
+		t.Error("expected the default limit not to count as a tenant's own")
+	}
+}
+
+func TestTenantRateLimiterStartWithChangingDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limiter := NewTenantRateLimiter()
+	limiter.Start(ctx)
+	// Default limiters are swept however they come and go
+	limiter.SetDefaultLimit(1, time.Millisecond)
+	limiter.SetDefaultLimit(0, 0)
+	limiter.SetDefaultLimit(1, time.Hour)
+	if !limiter.Check("tenant", "") || limiter.Check("tenant", "") {
+		t.Error("expected the replaced default limit to apply")
+	}
+}
+
+func TestTenantRateLimiterConcurrentProvisioning(t *testing.T) {
+	limiter := NewTenantRateLimiter()
+	limiter.SetDefaultLimit(1000, time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				tenant := fmt.Sprintf("tenant-%d", j%10)
+				limiter.SetTenantLimit(tenant, 100+j, time.Second)
+				if j%7 == 0 {
+					limiter.RemoveTenant(tenant)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				limiter.Check(fmt.Sprintf("tenant-%d", j%10), "client")
+			}
+		}()
+	}
+	wg.Wait()
+}
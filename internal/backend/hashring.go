@@ -0,0 +1,76 @@
+package backend
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// ringReplicas is the number of virtual nodes placed on the ring per unit of weight
+const ringReplicas = 100
+
+// hashRing maps keys onto servers using consistent hashing
+type hashRing struct {
+	points []uint32
+	owners map[uint32]*Server
+}
+
+// newHashRing builds a ring with virtual nodes proportional to each server's weight
+func newHashRing(servers []*Server) *hashRing {
+	ring := &hashRing{
+		points: make([]uint32, 0),
+		owners: make(map[uint32]*Server),
+	}
+
+	for _, server := range servers {
+		weight := int(atomic.LoadInt32(&server.Weight))
+		if weight < 1 {
+			weight = 1
+		}
+		base := server.URL.String()
+		for i := 0; i < weight*ringReplicas; i++ {
+			point := hashKey(base + "#" + strconv.Itoa(i))
+			if _, taken := ring.owners[point]; taken {
+				continue
+			}
+			ring.owners[point] = server
+			ring.points = append(ring.points, point)
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// lookup walks the ring clockwise from the key's position and returns the
+// first server accepted by the filter
+func (r *hashRing) lookup(key string, accept func(*Server) bool) *Server {
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+
+	seen := make(map[*Server]bool)
+	for i := 0; i < len(r.points); i++ {
+		server := r.owners[r.points[(start+i)%len(r.points)]]
+		if seen[server] {
+			continue
+		}
+		if accept(server) {
+			return server
+		}
+		seen[server] = true
+	}
+
+	return nil
+}
+
+// hashKey hashes a string with FNV-1a
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"time"
+)
+
+// OutlierConfig controls automatic ejection of servers whose error rate
+// crosses a threshold, similar to Envoy's outlier detection
+type OutlierConfig struct {
+	ErrorRateThreshold float64       // eject when failures/requests reaches this (0-1), 0 disables
+	MinRequests        int64         // requests needed in the interval before evaluating
+	Interval           time.Duration // length of the rolling error rate window
+	BaseEjectionTime   time.Duration // ejection time, multiplied by the number of ejections
+	MaxEjectionTime    time.Duration // cap on the ejection time, 0 = uncapped
+	OnEvent            func(server *Server, ejected bool)
+}
+
+// outlierState is the per-server outlier detection state (guarded by Server.mu)
+type outlierState struct {
+	windowStart time.Time
+	requests    int64
+	failures    int64
+	ejections   int
+	ejected     bool
+}
+
+// SetOutlierDetection enables outlier detection on results reported through
+// RecordResult
+func (p *Pool) SetOutlierDetection(cfg OutlierConfig) {
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 5
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.BaseEjectionTime <= 0 {
+		cfg.BaseEjectionTime = 30 * time.Second
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.outlier = cfg
+}
+
+// ejected reports whether outlier detection has ejected the server. An
+// ejected server is also marked unhealthy, unless that was overridden.
+func (s *Server) ejected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.outlier.ejected
+}
+
+// detectOutlier updates the server's rolling error rate and ejects it when
+// the threshold is crossed
+func (p *Pool) detectOutlier(server *Server, success bool, cfg OutlierConfig) {
+	server.mu.Lock()
+	state := &server.outlier
+	if state.ejected {
+		server.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(state.windowStart) > cfg.Interval {
+		// A clean interval earns back one step of the ejection multiplier
+		if !state.windowStart.IsZero() && state.failures == 0 && state.ejections > 0 {
+			state.ejections--
+		}
+		state.windowStart = now
+		state.requests = 0
+		state.failures = 0
+	}
+
+	state.requests++
+	if !success {
+		state.failures++
+	}
+
+	eject := state.requests >= cfg.MinRequests &&
+		float64(state.failures)/float64(state.requests) >= cfg.ErrorRateThreshold
+
+	var duration time.Duration
+	if eject {
+		state.ejections++
+		state.ejected = true
+		duration = cfg.BaseEjectionTime * time.Duration(state.ejections)
+		if cfg.MaxEjectionTime > 0 && duration > cfg.MaxEjectionTime {
+			duration = cfg.MaxEjectionTime
+		}
+	}
+	server.mu.Unlock()
+
+	if !eject {
+		return
+	}
+
+	p.setHealth(server, false)
+	if cfg.OnEvent != nil {
+		cfg.OnEvent(server, true)
+	}
+
+	time.AfterFunc(duration, func() {
+		server.mu.Lock()
+		server.outlier.ejected = false
+		server.outlier.windowStart = time.Now()
+		server.outlier.requests = 0
+		server.outlier.failures = 0
+		// A server the active health check has found down since stays out
+		// until a check passes
+		if !server.checkDown {
+			p.setHealth(server, true)
+		}
+		server.mu.Unlock()
+
+		if cfg.OnEvent != nil {
+			cfg.OnEvent(server, false)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetectionEjects(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetOutlierDetection(OutlierConfig{
+		ErrorRateThreshold: 0.5,
+		MinRequests:        4,
+		Interval:           time.Minute,
+		BaseEjectionTime:   time.Hour,
+	})
+
+	pool.RecordResult(server, true)
+	pool.RecordResult(server, false)
+	pool.RecordResult(server, true)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected no ejection below the minimum request volume")
+	}
+
+	pool.RecordResult(server, false)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected ejection once the error rate reaches the threshold")
+	}
+}
+
+func TestOutlierEjectedServerNotAvailable(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetOutlierDetection(OutlierConfig{ErrorRateThreshold: 0.5, MinRequests: 2, BaseEjectionTime: time.Hour})
+
+	pool.RecordResult(server, false)
+	pool.RecordResult(server, false)
+	// Marked healthy by hand, the server is still ejected
+	pool.SetServerHealth(server, true)
+
+	status := pool.Status()
+	if !status.Servers[0].Ejected || status.Available != 0 {
+		t.Errorf("expected the ejected server not to be available: %+v", status)
+	}
+}
+
+func TestOutlierDetectionBelowThreshold(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetOutlierDetection(OutlierConfig{ErrorRateThreshold: 0.5, MinRequests: 4, BaseEjectionTime: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		pool.RecordResult(server, i%4 != 0)
+	}
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected a 25% error rate not to trigger ejection")
+	}
+}
+
+func TestOutlierDetectionReadmitsWithGrowingEjection(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	var mu sync.Mutex
+	var events []bool
+	pool.SetOutlierDetection(OutlierConfig{
+		ErrorRateThreshold: 0.5,
+		MinRequests:        2,
+		Interval:           time.Minute,
+		BaseEjectionTime:   30 * time.Millisecond,
+		OnEvent: func(s *Server, ejected bool) {
+			mu.Lock()
+			events = append(events, ejected)
+			mu.Unlock()
+		},
+	})
+
+	burst := func() {
+		pool.RecordResult(server, false)
+		pool.RecordResult(server, false)
+	}
+
+	burst()
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected first burst to eject the server")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server to be re-admitted after the base ejection time")
+	}
+
+	// The second ejection lasts twice as long
+	burst()
+	time.Sleep(40 * time.Millisecond)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected second ejection to outlast the base ejection time")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server to be re-admitted after the doubled ejection time")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []bool{true, false, true, false}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, events)
+		}
+	}
+}
+
+func TestOutlierDetectionIgnoresResultsWhileEjected(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	ejections := 0
+	pool.SetOutlierDetection(OutlierConfig{
+		ErrorRateThreshold: 0.5,
+		MinRequests:        1,
+		BaseEjectionTime:   time.Hour,
+		OnEvent: func(s *Server, ejected bool) {
+			if ejected {
+				ejections++
+			}
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		pool.RecordResult(server, false)
+	}
+	if ejections != 1 {
+		t.Errorf("expected a single ejection, got %d", ejections)
+	}
+}
+
+func TestOutlierDetectionKeepsMultiplierAfterFailingWindow(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetOutlierDetection(OutlierConfig{ErrorRateThreshold: 0.5, MinRequests: 4, Interval: time.Minute})
+
+	endWindow := func(failures int64) int {
+		server.mu.Lock()
+		server.outlier.ejections = 1
+		server.outlier.windowStart = time.Now().Add(-time.Hour)
+		server.outlier.requests = 4
+		server.outlier.failures = failures
+		server.mu.Unlock()
+
+		pool.RecordResult(server, true)
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.outlier.ejections
+	}
+
+	if n := endWindow(1); n != 1 {
+		t.Errorf("expected a window with failures to keep the multiplier, got %d ejections", n)
+	}
+	if n := endWindow(0); n != 0 {
+		t.Errorf("expected a clean window to earn back a step, got %d ejections", n)
+	}
+}
+
+func TestOutlierDetectionRespectsActiveHealthCheck(t *testing.T) {
+	var up atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+	hc := NewHealthChecker(pool, time.Hour, time.Second, "/health")
+	pool.SetOutlierDetection(OutlierConfig{
+		ErrorRateThreshold: 0.5,
+		MinRequests:        1,
+		BaseEjectionTime:   30 * time.Millisecond,
+	})
+
+	pool.RecordResult(server, false)
+	hc.checkServer(server)
+	time.Sleep(60 * time.Millisecond)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected a server the health check found down to stay out after its ejection")
+	}
+
+	up.Store(true)
+	hc.checkServer(server)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected a passing health check to re-admit the server")
+	}
+
+	// A passing check doesn't cut an ejection short
+	pool.SetOutlierDetection(OutlierConfig{ErrorRateThreshold: 0.5, MinRequests: 1, BaseEjectionTime: time.Hour})
+	pool.RecordResult(server, false)
+	hc.checkServer(server)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected an ejected server to stay out despite a passing health check")
+	}
+}
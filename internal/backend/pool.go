@@ -1,9 +1,18 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,11 +20,203 @@ import (
 
 // Server represents a backend server
 type Server struct {
-	URL      *url.URL
-	Weight   int32
-	Healthy  int32 // 1 = healthy, 0 = unhealthy
-	mu       sync.RWMutex
-	metadata map[string]interface{}
+	ID           string // stable identifier derived from the URL, used for sticky sessions
+	URL          *url.URL
+	Weight       int32
+	Healthy      int32 // 1 = healthy, 0 = unhealthy
+	MaxConns     int32 // concurrent request cap, 0 = unlimited
+	mu           sync.RWMutex
+	metadata     map[string]interface{}
+	failures     int
+	firstFailure time.Time
+	recoveredAt  int64   // unix nanos of the last unhealthy to healthy transition
+	slowStart    int64   // ramp duration in effect since recoveredAt
+	wrrCurrent   float64 // smooth weighted round-robin state, guarded by Pool.wrrMu
+	outlier      outlierState
+	inFlight     int64
+	requests     int64
+	successes    int64
+	errors       int64
+	lastLatency  int64  // time.Duration of the most recent response
+	ewma         uint64 // float64 bits of the latency moving average in nanoseconds
+	draining     int32  // 1 = draining, no new requests are routed to it
+	checkDown    bool   // the last active health check failed (guarded by mu)
+	drained      chan struct{}
+}
+
+// available reports whether the server can take new requests
+func (s *Server) available() bool {
+	return atomic.LoadInt32(&s.Healthy) == 1 && atomic.LoadInt32(&s.draining) == 0 && !s.saturated()
+}
+
+// saturated reports whether the server is at its concurrent request cap
+func (s *Server) saturated() bool {
+	maxConns := atomic.LoadInt32(&s.MaxConns)
+	return maxConns > 0 && atomic.LoadInt64(&s.inFlight) >= int64(maxConns)
+}
+
+// Acquire marks a request as in flight on the server, regardless of
+// MaxConns
+func (s *Server) Acquire() {
+	atomic.AddInt64(&s.requests, 1)
+	atomic.AddInt64(&s.inFlight, 1)
+}
+
+// TryAcquire marks a request as in flight on the server unless that would
+// exceed MaxConns, and reports whether it did. Selection only skips
+// saturated servers, so a request must hold a slot reserved this way
+// before it is sent.
+func (s *Server) TryAcquire() bool {
+	for {
+		n := atomic.LoadInt64(&s.inFlight)
+		if maxConns := atomic.LoadInt32(&s.MaxConns); maxConns > 0 && n >= int64(maxConns) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.inFlight, n, n+1) {
+			atomic.AddInt64(&s.requests, 1)
+			return true
+		}
+	}
+}
+
+// Release marks an in-flight request as finished
+func (s *Server) Release() {
+	if atomic.AddInt64(&s.inFlight, -1) > 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drained != nil {
+		close(s.drained)
+		s.drained = nil
+	}
+}
+
+// InFlight returns the number of requests currently in flight on the server
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// Draining reports whether the server has been marked as draining
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// WaitDrained blocks until the server has no requests in flight or the
+// context is done
+func (s *Server) WaitDrained(ctx context.Context) error {
+	s.mu.Lock()
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	if atomic.LoadInt64(&s.inFlight) <= 0 {
+		return nil
+	}
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// minSlowStartFactor is the share of its weight a just-recovered server receives
+const minSlowStartFactor = 0.05
+
+// effectiveWeight returns the server's weight, linearly ramped from near zero
+// while it is within its slow-start window after recovering
+func (s *Server) effectiveWeight(now time.Time) float64 {
+	weight := float64(atomic.LoadInt32(&s.Weight))
+	if weight < 1 {
+		weight = 1
+	}
+
+	window := time.Duration(atomic.LoadInt64(&s.slowStart))
+	if window <= 0 {
+		return weight
+	}
+
+	elapsed := now.Sub(time.Unix(0, atomic.LoadInt64(&s.recoveredAt)))
+	if elapsed >= window {
+		return weight
+	}
+
+	factor := float64(elapsed) / float64(window)
+	if factor < minSlowStartFactor {
+		factor = minSlowStartFactor
+	}
+	return weight * factor
+}
+
+// PassiveHealthConfig controls ejection of servers that fail real traffic
+type PassiveHealthConfig struct {
+	MaxFails      int                // consecutive failures before ejecting, 0 disables
+	FailWindow    time.Duration      // failures must occur within this window, 0 means unbounded
+	RecoveryDelay time.Duration      // delay before an ejected server is probed
+	Probe         func(*Server) bool // recovery probe, nil re-admits without probing
+}
+
+// Strategy selects how a pool picks a backend server
+type Strategy int
+
+const (
+	// StrategyRoundRobin cycles through healthy servers in order
+	StrategyRoundRobin Strategy = iota
+	// StrategyConsistentHash maps request keys onto a hash ring so the same
+	// key keeps landing on the same server
+	StrategyConsistentHash
+	// StrategyIPHash maps client IPs onto the hash ring for cookie-less
+	// session stickiness
+	StrategyIPHash
+	// StrategyWeightedRoundRobin spreads requests in proportion to each
+	// server's effective weight
+	StrategyWeightedRoundRobin
+	// StrategyEWMA prefers the server with the lowest moving average latency
+	StrategyEWMA
+	// StrategyLeastConnections prefers the server with the fewest requests
+	// in flight
+	StrategyLeastConnections
+)
+
+// strategyNames maps config names onto strategies
+var strategyNames = map[string]Strategy{
+	"round_robin":          StrategyRoundRobin,
+	"consistent_hash":      StrategyConsistentHash,
+	"ip_hash":              StrategyIPHash,
+	"weighted_round_robin": StrategyWeightedRoundRobin,
+	"ewma":                 StrategyEWMA,
+	"least_connections":    StrategyLeastConnections,
+}
+
+// ParseStrategy returns the strategy with the given config name, such as
+// "round_robin" or "least_connections". An empty name is round-robin.
+func ParseStrategy(name string) (Strategy, error) {
+	if name == "" {
+		return StrategyRoundRobin, nil
+	}
+	strategy, ok := strategyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown load balancing strategy '%s'", name)
+	}
+	return strategy, nil
+}
+
+const (
+	// DefaultEWMADecay is the weight given to each new latency sample
+	DefaultEWMADecay = 0.3
+	// ewmaExploreRate is the share of EWMA selections sent to a random server
+	// so slower servers keep being measured and traffic doesn't herd
+	ewmaExploreRate = 0.05
+)
+
+// hashed reports whether the strategy selects servers from the hash ring
+func (s Strategy) hashed() bool {
+	return s == StrategyConsistentHash || s == StrategyIPHash
 }
 
 // Pool manages multiple backend servers
@@ -24,13 +225,27 @@ type Pool struct {
 	current    uint32
 	mu         sync.RWMutex
 	healthChan chan *Server
+	strategy   Strategy
+	ring       *hashRing
+	passive    PassiveHealthConfig
+	outlier    OutlierConfig
+	slowStart  int64  // time.Duration, accessed atomically
+	ewmaDecay  uint64 // float64 bits, accessed atomically
+	wrrMu      sync.Mutex
+	sticky     string // sticky session cookie name, empty = disabled
+	transport  http.RoundTripper
+	retries    int32 // attempts per request, 0 = the proxy's retry policy
 }
 
+// DefaultStickyCookie is the cookie used to pin clients to a server
+const DefaultStickyCookie = "srv_id"
+
 // NewPool creates a new backend pool
 func NewPool() *Pool {
 	return &Pool{
 		Servers:    make([]*Server, 0),
 		healthChan: make(chan *Server, 100),
+		ewmaDecay:  math.Float64bits(DefaultEWMADecay),
 	}
 }
 
@@ -42,6 +257,7 @@ func (p *Pool) AddServer(rawURL string, weight int32) (*Server, error) {
 	}
 
 	server := &Server{
+		ID:       serverID(u),
 		URL:      u,
 		Weight:   weight,
 		Healthy:  1,
@@ -50,29 +266,425 @@ func (p *Pool) AddServer(rawURL string, weight int32) (*Server, error) {
 
 	p.mu.Lock()
 	p.Servers = append(p.Servers, server)
+	p.rebuildRing()
 	p.mu.Unlock()
 
 	return server, nil
 }
 
-// GetServer returns a healthy backend server using round-robin
+// serverID derives a stable server identifier from its URL
+func serverID(u *url.URL) string {
+	h := fnv.New64a()
+	h.Write([]byte(u.String()))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// SetTransport sets the transport used to reach this pool's servers, for
+// proxying and health checks; nil means the proxy's default
+func (p *Pool) SetTransport(transport http.RoundTripper) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transport = transport
+}
+
+// Transport returns the pool's transport, or nil if none was set
+func (p *Pool) Transport() http.RoundTripper {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.transport
+}
+
+// SetRetryAttempts sets how many servers a failed request may be tried on,
+// overriding the proxy's retry policy for this pool; 0 restores the default
+func (p *Pool) SetRetryAttempts(attempts int) {
+	atomic.StoreInt32(&p.retries, int32(attempts))
+}
+
+// RetryAttempts returns the pool's attempt count, or 0 if it has none
+func (p *Pool) RetryAttempts() int {
+	return int(atomic.LoadInt32(&p.retries))
+}
+
+// EnableStickySessions pins clients to a server using the named cookie
+// (DefaultStickyCookie when empty)
+func (p *Pool) EnableStickySessions(cookieName string) {
+	if cookieName == "" {
+		cookieName = DefaultStickyCookie
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sticky = cookieName
+}
+
+// StickyCookie returns the sticky session cookie name and whether sticky
+// sessions are enabled
+func (p *Pool) StickyCookie() (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.sticky, p.sticky != ""
+}
+
+// StickyServer returns the available server the request's sticky cookie
+// points at, or nil if there is none
+func (p *Pool) StickyServer(req *http.Request) *Server {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.sticky == "" {
+		return nil
+	}
+	cookie, err := req.Cookie(p.sticky)
+	if err != nil {
+		return nil
+	}
+
+	for _, server := range p.Servers {
+		if server.ID == cookie.Value && server.available() {
+			return server
+		}
+	}
+	return nil
+}
+
+// SetStickyCookie pins the client to the server by setting the sticky cookie
+func (p *Pool) SetStickyCookie(w http.ResponseWriter, server *Server) {
+	name, ok := p.StickyCookie()
+	if !ok {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    server.ID,
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
+
+// RemoveServer removes the server with the given URL from the pool and
+// reports whether it was found
+func (p *Pool) RemoveServer(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, server := range p.Servers {
+		if server.URL.String() != u.String() {
+			continue
+		}
+
+		servers := make([]*Server, 0, len(p.Servers)-1)
+		servers = append(servers, p.Servers[:i]...)
+		p.Servers = append(servers, p.Servers[i+1:]...)
+
+		// Keep the round-robin cursor within the shrunken slice
+		if len(p.Servers) > 0 {
+			atomic.StoreUint32(&p.current, atomic.LoadUint32(&p.current)%uint32(len(p.Servers)))
+		} else {
+			atomic.StoreUint32(&p.current, 0)
+		}
+		p.rebuildRing()
+		return true
+	}
+
+	return false
+}
+
+// ServerByURL returns the server with the given URL, or nil if the pool
+// has none
+func (p *Pool) ServerByURL(rawURL string) *Server {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, server := range p.Servers {
+		if server.URL.String() == u.String() {
+			return server
+		}
+	}
+	return nil
+}
+
+// DrainServer stops routing new requests to the server while letting
+// in-flight requests finish. Use Server.WaitDrained to wait for them.
+func (p *Pool) DrainServer(server *Server) {
+	atomic.StoreInt32(&server.draining, 1)
+}
+
+// SetWeight updates a server's weight; it takes effect on the next selection
+func (p *Pool) SetWeight(server *Server, weight int32) {
+	atomic.StoreInt32(&server.Weight, weight)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rebuildRing()
+}
+
+// SetStrategy sets the load balancing strategy
+func (p *Pool) SetStrategy(strategy Strategy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.strategy = strategy
+	p.rebuildRing()
+}
+
+// Strategy returns the load balancing strategy
+func (p *Pool) Strategy() Strategy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.strategy
+}
+
+// SetSlowStart sets how long a recovered server takes to ramp up to its full
+// weight under weighted round-robin. Zero disables slow start.
+func (p *Pool) SetSlowStart(d time.Duration) {
+	atomic.StoreInt64(&p.slowStart, int64(d))
+}
+
+// GetServer returns a healthy backend server using the pool's strategy
 func (p *Pool) GetServer() *Server {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if len(p.Servers) == 0 {
+	return p.selectServer()
+}
+
+// SetEWMADecay sets the weight (0-1] given to each new latency sample
+func (p *Pool) SetEWMADecay(decay float64) {
+	if decay <= 0 || decay > 1 {
+		decay = DefaultEWMADecay
+	}
+	atomic.StoreUint64(&p.ewmaDecay, math.Float64bits(decay))
+}
+
+// RecordLatency feeds an observed response latency into the server's
+// moving average
+func (p *Pool) RecordLatency(server *Server, d time.Duration) {
+	atomic.StoreInt64(&server.lastLatency, int64(d))
+
+	decay := math.Float64frombits(atomic.LoadUint64(&p.ewmaDecay))
+	for {
+		old := atomic.LoadUint64(&server.ewma)
+		avg := float64(d)
+		if old != 0 {
+			avg = decay*float64(d) + (1-decay)*math.Float64frombits(old)
+		}
+		if atomic.CompareAndSwapUint64(&server.ewma, old, math.Float64bits(avg)) {
+			return
+		}
+	}
+}
+
+// Latency returns the server's moving average response latency
+func (s *Server) Latency() time.Duration {
+	return time.Duration(math.Float64frombits(atomic.LoadUint64(&s.ewma)))
+}
+
+// selectServer picks a server using the non-keyed strategies (must be called with read lock)
+func (p *Pool) selectServer() *Server {
+	switch p.strategy {
+	case StrategyWeightedRoundRobin:
+		return p.weightedRoundRobin()
+	case StrategyEWMA:
+		return p.lowestLatency()
+	case StrategyLeastConnections:
+		return p.leastConnections()
+	}
+	return p.roundRobin()
+}
+
+// leastConnections picks the available server with the fewest requests in
+// flight (must be called with read lock)
+func (p *Pool) leastConnections() *Server {
+	healthy := p.getHealthyServers()
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	// Start at a random offset so ties don't always favor the same server
+	offset := rand.Intn(len(healthy))
+	best := healthy[offset]
+	for i := 1; i < len(healthy); i++ {
+		server := healthy[(offset+i)%len(healthy)]
+		if server.InFlight() < best.InFlight() {
+			best = server
+		}
+	}
+	return best
+}
+
+// lowestLatency picks the available server with the lowest latency moving
+// average, occasionally choosing at random (must be called with read lock)
+func (p *Pool) lowestLatency() *Server {
+	healthy := p.getHealthyServers()
+	if len(healthy) == 0 {
 		return nil
 	}
 
-	// Get healthy servers
-	healthyServers := p.getHealthyServers()
-	if len(healthyServers) == 0 {
+	if rand.Float64() < ewmaExploreRate {
+		return healthy[rand.Intn(len(healthy))]
+	}
+
+	// Start at a random offset so ties don't always favor the same server
+	offset := rand.Intn(len(healthy))
+	best := healthy[offset]
+	for i := 1; i < len(healthy); i++ {
+		server := healthy[(offset+i)%len(healthy)]
+		if server.Latency() < best.Latency() {
+			best = server
+		}
+	}
+	return best
+}
+
+// GetServerForKey returns a healthy backend server for the given key. With
+// StrategyConsistentHash or StrategyIPHash the same key maps to the same
+// server; when that server is unhealthy the next server on the ring is used,
+// so only keys owned by an unhealthy server are redistributed. Other
+// strategies ignore the key.
+func (p *Pool) GetServerForKey(key string) *Server {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.ring != nil {
+		return p.ring.lookup(key, (*Server).available)
+	}
+
+	return p.selectServer()
+}
+
+// roundRobin picks the next healthy server (must be called with read lock)
+func (p *Pool) roundRobin() *Server {
+	n := uint32(len(p.Servers))
+	if n == 0 {
 		return nil
 	}
 
-	// Simple round-robin
-	idx := atomic.AddUint32(&p.current, 1) % uint32(len(healthyServers))
-	return healthyServers[idx]
+	// Advance a cursor over the full server list and skip unavailable
+	// entries, so the cycle order stays stable as servers flap
+	for i := uint32(0); i < n; i++ {
+		idx := (atomic.AddUint32(&p.current, 1) - 1) % n
+		if server := p.Servers[idx]; server.available() {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// weightedRoundRobin picks a healthy server using smooth weighted
+// round-robin over effective weights (must be called with read lock)
+func (p *Pool) weightedRoundRobin() *Server {
+	p.wrrMu.Lock()
+	defer p.wrrMu.Unlock()
+
+	now := time.Now()
+	total := 0.0
+	var best *Server
+	for _, server := range p.Servers {
+		if !server.available() {
+			continue
+		}
+		weight := server.effectiveWeight(now)
+		server.wrrCurrent += weight
+		total += weight
+		if best == nil || server.wrrCurrent > best.wrrCurrent {
+			best = server
+		}
+	}
+
+	if best != nil {
+		best.wrrCurrent -= total
+	}
+	return best
+}
+
+// PoolStatus is a snapshot of a pool's server states
+type PoolStatus struct {
+	Total     int
+	Healthy   int
+	Draining  int
+	Available int // servers taking new requests: healthy, not draining and not ejected
+	Servers   []ServerStatus
+}
+
+// ServerStatus is a snapshot of a single server's state
+type ServerStatus struct {
+	URL      string
+	Healthy  bool
+	Draining bool
+	Ejected  bool // by outlier detection
+	InFlight int64
+}
+
+// Status returns a consistent snapshot of the pool's server states
+func (p *Pool) Status() PoolStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	status := PoolStatus{
+		Total:   len(p.Servers),
+		Servers: make([]ServerStatus, 0, len(p.Servers)),
+	}
+	for _, server := range p.Servers {
+		s := ServerStatus{
+			URL:      server.URL.String(),
+			Healthy:  atomic.LoadInt32(&server.Healthy) == 1,
+			Draining: server.Draining(),
+			Ejected:  server.ejected(),
+			InFlight: server.InFlight(),
+		}
+		if s.Healthy {
+			status.Healthy++
+		}
+		if s.Draining {
+			status.Draining++
+		}
+		if s.Healthy && !s.Draining && !s.Ejected {
+			status.Available++
+		}
+		status.Servers = append(status.Servers, s)
+	}
+	return status
+}
+
+// ServerStat is a snapshot of a server's traffic counters
+type ServerStat struct {
+	URL         string
+	Healthy     bool
+	Requests    int64
+	Successes   int64
+	Failures    int64
+	LastLatency time.Duration
+}
+
+// ServerStats returns a snapshot of per-server request statistics
+func (p *Pool) ServerStats() []ServerStat {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	stats := make([]ServerStat, 0, len(p.Servers))
+	for _, server := range p.Servers {
+		stats = append(stats, ServerStat{
+			URL:         server.URL.String(),
+			Healthy:     atomic.LoadInt32(&server.Healthy) == 1,
+			Requests:    atomic.LoadInt64(&server.requests),
+			Successes:   atomic.LoadInt64(&server.successes),
+			Failures:    atomic.LoadInt64(&server.errors),
+			LastLatency: time.Duration(atomic.LoadInt64(&server.lastLatency)),
+		})
+	}
+	return stats
 }
 
 // GetServerByIndex returns a specific server by index
@@ -87,11 +699,20 @@ func (p *Pool) GetServerByIndex(index int) *Server {
 	return p.Servers[index]
 }
 
-// getHealthyServers returns only healthy servers (must be called with read lock)
+// rebuildRing rebuilds the hash ring for hash-based strategies (must be called with write lock)
+func (p *Pool) rebuildRing() {
+	if !p.strategy.hashed() {
+		p.ring = nil
+		return
+	}
+	p.ring = newHashRing(p.Servers)
+}
+
+// getHealthyServers returns only servers available for new requests (must be called with read lock)
 func (p *Pool) getHealthyServers() []*Server {
 	healthy := make([]*Server, 0)
 	for _, server := range p.Servers {
-		if atomic.LoadInt32(&server.Healthy) == 1 {
+		if server.available() {
 			healthy = append(healthy, server)
 		}
 	}
@@ -100,11 +721,102 @@ func (p *Pool) getHealthyServers() []*Server {
 
 // SetServerHealth sets the health status of a server
 func (p *Pool) SetServerHealth(server *Server, healthy bool) {
+	p.setHealth(server, healthy)
+}
+
+// setHealth stores the health status and reports whether it changed
+func (p *Pool) setHealth(server *Server, healthy bool) bool {
 	val := int32(1)
 	if !healthy {
 		val = 0
 	}
-	atomic.StoreInt32(&server.Healthy, val)
+	if atomic.SwapInt32(&server.Healthy, val) == val {
+		return false
+	}
+
+	if healthy {
+		atomic.StoreInt64(&server.recoveredAt, time.Now().UnixNano())
+		atomic.StoreInt64(&server.slowStart, atomic.LoadInt64(&p.slowStart))
+	}
+	return true
+}
+
+// SetPassiveHealthCheck enables ejection of servers based on the results
+// reported through RecordResult
+func (p *Pool) SetPassiveHealthCheck(cfg PassiveHealthConfig) {
+	if cfg.RecoveryDelay <= 0 {
+		cfg.RecoveryDelay = 10 * time.Second
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.passive = cfg
+}
+
+// RecordResult records the outcome of a request proxied to a server. With
+// passive health checking enabled, a server that fails MaxFails consecutive
+// requests within FailWindow is marked unhealthy and probed for recovery.
+// With outlier detection enabled, a server whose error rate crosses the
+// threshold is ejected for a growing period.
+func (p *Pool) RecordResult(server *Server, success bool) {
+	if success {
+		atomic.AddInt64(&server.successes, 1)
+	} else {
+		atomic.AddInt64(&server.errors, 1)
+	}
+
+	p.mu.RLock()
+	passive := p.passive
+	outlier := p.outlier
+	p.mu.RUnlock()
+
+	if outlier.ErrorRateThreshold > 0 {
+		p.detectOutlier(server, success, outlier)
+	}
+	if passive.MaxFails > 0 {
+		p.recordPassive(server, success, passive)
+	}
+}
+
+// recordPassive tracks consecutive failures for passive health checking
+func (p *Pool) recordPassive(server *Server, success bool, cfg PassiveHealthConfig) {
+	server.mu.Lock()
+	if success {
+		server.failures = 0
+		server.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if server.failures == 0 || (cfg.FailWindow > 0 && now.Sub(server.firstFailure) > cfg.FailWindow) {
+		server.failures = 0
+		server.firstFailure = now
+	}
+	server.failures++
+	eject := server.failures >= cfg.MaxFails
+	if eject {
+		server.failures = 0
+	}
+	server.mu.Unlock()
+
+	if eject && p.setHealth(server, false) {
+		p.scheduleRecovery(server, cfg)
+	}
+}
+
+// scheduleRecovery probes an ejected server after the recovery delay and
+// re-admits it once the probe passes
+func (p *Pool) scheduleRecovery(server *Server, cfg PassiveHealthConfig) {
+	time.AfterFunc(cfg.RecoveryDelay, func() {
+		if p.GetServerHealth(server) {
+			return
+		}
+		if cfg.Probe == nil || cfg.Probe(server) {
+			p.setHealth(server, true)
+			return
+		}
+		p.scheduleRecovery(server, cfg)
+	})
 }
 
 // GetServerHealth returns the health status of a server
@@ -112,35 +824,140 @@ func (p *Pool) GetServerHealth(server *Server) bool {
 	return atomic.LoadInt32(&server.Healthy) == 1
 }
 
+// HealthCheckType selects how servers are probed
+type HealthCheckType string
+
+const (
+	// HealthCheckHTTP issues a request against the health path and expects 200
+	HealthCheckHTTP HealthCheckType = "http"
+	// HealthCheckTCP only requires a TCP connection to succeed
+	HealthCheckTCP HealthCheckType = "tcp"
+)
+
+// maxHealthBodySize caps how much of a health check response body is read
+const maxHealthBodySize = 64 * 1024
+
 // HealthChecker periodically checks backend health
 type HealthChecker struct {
-	pool     *Pool
-	interval time.Duration
-	timeout  time.Duration
-	path     string
-	stopCh   chan struct{}
-	client   *http.Client
+	pool              *Pool
+	interval          time.Duration
+	timeout           time.Duration
+	path              string
+	checkType         HealthCheckType
+	method            string
+	headers           map[string]string
+	expectedBody      string
+	expectedBodyRegex *regexp.Regexp
+	jitter            float64
+	rng               *rand.Rand
+	rngMu             sync.Mutex
+	afterFunc         func(time.Duration, func())
+	onStateChange     func(server *Server, healthy bool)
+	stopCh            chan struct{}
+	client            *http.Client
+}
+
+// HealthCheckOption configures optional health checker behavior
+type HealthCheckOption func(*HealthChecker)
+
+// WithCheckType sets how servers are probed (HTTP by default)
+func WithCheckType(checkType HealthCheckType) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.checkType = checkType
+	}
+}
+
+// WithMethod sets the HTTP method used for health check requests
+func WithMethod(method string) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.method = method
+	}
+}
+
+// WithHeaders sets headers sent with every health check request
+func WithHeaders(headers map[string]string) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.headers = headers
+	}
+}
+
+// WithJitter staggers probes: the first round spreads servers across the
+// whole interval and later rounds delay each probe by up to fraction of the
+// interval. A nil rng is seeded from the clock.
+func WithJitter(fraction float64, rng *rand.Rand) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		if rng == nil {
+			rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		hc.jitter = fraction
+		hc.rng = rng
+	}
+}
+
+// WithStateChangeHandler registers a callback fired only when a probe flips
+// a server between healthy and unhealthy
+func WithStateChangeHandler(fn func(server *Server, healthy bool)) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.onStateChange = fn
+	}
+}
+
+// WithExpectedBody requires the health response body to contain substr
+func WithExpectedBody(substr string) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.expectedBody = substr
+	}
+}
+
+// WithExpectedBodyRegex requires the health response body to match re
+func WithExpectedBodyRegex(re *regexp.Regexp) HealthCheckOption {
+	return func(hc *HealthChecker) {
+		hc.expectedBodyRegex = re
+	}
 }
 
 // NewHealthChecker creates a new health checker
-func NewHealthChecker(pool *Pool, interval, timeout time.Duration, path string) *HealthChecker {
+func NewHealthChecker(pool *Pool, interval, timeout time.Duration, path string, opts ...HealthCheckOption) *HealthChecker {
 	if path == "" {
 		path = "/health"
 	}
-	return &HealthChecker{
-		pool:     pool,
-		interval: interval,
-		timeout:  timeout,
-		path:     path,
-		stopCh:   make(chan struct{}),
+	hc := &HealthChecker{
+		pool:      pool,
+		interval:  interval,
+		timeout:   timeout,
+		path:      path,
+		checkType: HealthCheckHTTP,
+		method:    http.MethodGet,
+		afterFunc: func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		stopCh:    make(chan struct{}),
 		client: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: pool.Transport(),
 		},
 	}
+	for _, opt := range opts {
+		opt(hc)
+	}
+	return hc
+}
+
+// Interval returns how often servers are probed
+func (hc *HealthChecker) Interval() time.Duration {
+	return hc.interval
+}
+
+// Timeout returns how long a probe may take before the server is
+// considered unhealthy
+func (hc *HealthChecker) Timeout() time.Duration {
+	return hc.timeout
 }
 
 // Start begins health checking
 func (hc *HealthChecker) Start(ctx context.Context) {
+	if hc.jitter > 0 {
+		hc.scheduleRound(ctx, 1)
+	}
+
 	go func() {
 		ticker := time.NewTicker(hc.interval)
 		defer ticker.Stop()
@@ -152,7 +969,11 @@ func (hc *HealthChecker) Start(ctx context.Context) {
 			case <-hc.stopCh:
 				return
 			case <-ticker.C:
-				hc.checkHealth()
+				if hc.jitter > 0 {
+					hc.scheduleRound(ctx, hc.jitter)
+				} else {
+					hc.checkHealth()
+				}
 			}
 		}
 	}()
@@ -165,40 +986,129 @@ func (hc *HealthChecker) Stop() {
 
 // checkHealth checks the health of all servers
 func (hc *HealthChecker) checkHealth() {
+	for _, server := range hc.servers() {
+		go hc.checkServer(server)
+	}
+}
+
+// scheduleRound probes every server after a random delay of up to spread
+// times the interval
+func (hc *HealthChecker) scheduleRound(ctx context.Context, spread float64) {
+	for _, server := range hc.servers() {
+		server := server
+		hc.rngMu.Lock()
+		delay := time.Duration(hc.rng.Float64() * spread * float64(hc.interval))
+		hc.rngMu.Unlock()
+
+		hc.afterFunc(delay, func() {
+			select {
+			case <-ctx.Done():
+			case <-hc.stopCh:
+			default:
+				hc.checkServer(server)
+			}
+		})
+	}
+}
+
+// servers returns a snapshot of the pool's servers
+func (hc *HealthChecker) servers() []*Server {
 	hc.pool.mu.RLock()
+	defer hc.pool.mu.RUnlock()
+
 	servers := make([]*Server, len(hc.pool.Servers))
 	copy(servers, hc.pool.Servers)
-	hc.pool.mu.RUnlock()
-
-	for _, server := range servers {
-		go hc.checkServer(server)
-	}
+	return servers
 }
 
 // checkServer checks the health of a single server
 func (hc *HealthChecker) checkServer(server *Server) {
+	healthy := hc.Probe(server)
+	if hc.pool.recordCheck(server, healthy) && hc.onStateChange != nil {
+		hc.onStateChange(server, healthy)
+	}
+}
+
+// recordCheck applies an active health check verdict and reports whether
+// the server's health changed. A passing check doesn't re-admit a server
+// outlier detection has ejected; that waits for the ejection to end.
+func (p *Pool) recordCheck(server *Server, healthy bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.checkDown = !healthy
+	if healthy && server.outlier.ejected {
+		return false
+	}
+	return p.setHealth(server, healthy)
+}
+
+// Probe performs a single health check against a server without updating
+// its status
+func (hc *HealthChecker) Probe(server *Server) bool {
+	if hc.checkType == HealthCheckTCP {
+		return hc.probeTCP(server)
+	}
+	return hc.probeHTTP(server)
+}
+
+// probeTCP reports whether a TCP connection to the server can be opened
+func (hc *HealthChecker) probeTCP(server *Server) bool {
+	port := server.URL.Port()
+	if port == "" {
+		port = "80"
+		if server.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(server.URL.Hostname(), port), hc.timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// probeHTTP reports whether the health path answers with 200 OK
+func (hc *HealthChecker) probeHTTP(server *Server) bool {
 	healthURL := server.URL.Scheme + "://" + server.URL.Host + hc.path
 
 	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
+	req, err := http.NewRequestWithContext(ctx, hc.method, healthURL, nil)
 	if err != nil {
-		hc.pool.SetServerHealth(server, false)
-		return
+		return false
+	}
+	for key, value := range hc.headers {
+		req.Header.Set(key, value)
 	}
 
 	resp, err := hc.client.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		hc.pool.SetServerHealth(server, false)
-		if resp != nil {
-			resp.Body.Close()
-		}
-		return
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if hc.expectedBody == "" && hc.expectedBodyRegex == nil {
+		return true
 	}
-	resp.Body.Close()
 
-	hc.pool.SetServerHealth(server, true)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBodySize))
+	if err != nil {
+		return false
+	}
+	if hc.expectedBody != "" && !bytes.Contains(body, []byte(hc.expectedBody)) {
+		return false
+	}
+	if hc.expectedBodyRegex != nil && !hc.expectedBodyRegex.Match(body) {
+		return false
+	}
+	return true
 }
 
 // GetMetadata retrieves metadata for a server
@@ -1,9 +1,17 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -232,3 +240,1121 @@ func TestPoolConcurrency(t *testing.T) {
 		<-done
 	}
 }
+
+func TestConsistentHashSameKey(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	first := pool.GetServerForKey("/images/logo.png")
+	if first == nil {
+		t.Fatal("expected server to be non-nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		if got := pool.GetServerForKey("/images/logo.png"); got != first {
+			t.Fatalf("expected key to stay on %s, got %s", first.URL.Host, got.URL.Host)
+		}
+	}
+}
+
+func TestConsistentHashMinimalRemapping(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	for _, u := range []string{"http://server1:3000", "http://server2:3000", "http://server3:3000", "http://server4:3000"} {
+		pool.AddServer(u, 1)
+	}
+
+	keys := make([]string, 1000)
+	before := make(map[string]*Server)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/assets/%d", i)
+		before[keys[i]] = pool.GetServerForKey(keys[i])
+	}
+
+	ejected := pool.Servers[1]
+	pool.SetServerHealth(ejected, false)
+
+	moved := 0
+	for _, key := range keys {
+		after := pool.GetServerForKey(key)
+		if after == ejected {
+			t.Fatalf("key %s mapped to ejected server", key)
+		}
+		if before[key] != ejected && after != before[key] {
+			t.Fatalf("key %s moved from healthy server %s to %s", key, before[key].URL.Host, after.URL.Host)
+		}
+		if after != before[key] {
+			moved++
+		}
+	}
+
+	if moved == 0 || moved > len(keys)/2 {
+		t.Errorf("expected roughly a quarter of keys to move, got %d", moved)
+	}
+}
+
+func TestConsistentHashWeightedDistribution(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	heavy, _ := pool.AddServer("http://server1:3000", 3)
+	pool.AddServer("http://server2:3000", 1)
+
+	counts := make(map[*Server]int)
+	for i := 0; i < 4000; i++ {
+		counts[pool.GetServerForKey(fmt.Sprintf("/k/%d", i))]++
+	}
+
+	if counts[heavy] < 2400 {
+		t.Errorf("expected weight-3 server to own most keys, got %d of 4000", counts[heavy])
+	}
+}
+
+func TestConsistentHashAllUnhealthy(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetServerHealth(server, false)
+
+	if pool.GetServerForKey("/a") != nil {
+		t.Fatal("expected nil when all servers are unhealthy")
+	}
+}
+
+func TestIPHashSticky(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyIPHash)
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	first := pool.GetServerForKey("203.0.113.7")
+	for i := 0; i < 20; i++ {
+		if got := pool.GetServerForKey("203.0.113.7"); got != first {
+			t.Fatalf("expected IP to stay on %s, got %s", first.URL.Host, got.URL.Host)
+		}
+	}
+}
+
+func TestIPHashFallback(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyIPHash)
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	ip := "198.51.100.23"
+	primary := pool.GetServerForKey(ip)
+	pool.SetServerHealth(primary, false)
+
+	alternate := pool.GetServerForKey(ip)
+	if alternate == nil || alternate == primary {
+		t.Fatal("expected a different healthy server while primary is down")
+	}
+	for i := 0; i < 20; i++ {
+		if got := pool.GetServerForKey(ip); got != alternate {
+			t.Fatalf("expected stable alternate %s, got %s", alternate.URL.Host, got.URL.Host)
+		}
+	}
+
+	pool.SetServerHealth(primary, true)
+	if got := pool.GetServerForKey(ip); got != primary {
+		t.Errorf("expected IP to return to %s after recovery, got %s", primary.URL.Host, got.URL.Host)
+	}
+}
+
+func TestRecordResultEjectsAfterConsecutiveFailures(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetPassiveHealthCheck(PassiveHealthConfig{MaxFails: 3, FailWindow: time.Minute, RecoveryDelay: time.Hour})
+
+	pool.RecordResult(server, false)
+	pool.RecordResult(server, false)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server to stay healthy below threshold")
+	}
+
+	pool.RecordResult(server, false)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected server to be ejected after 3 consecutive failures")
+	}
+}
+
+func TestRecordResultSuccessResetsFailures(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetPassiveHealthCheck(PassiveHealthConfig{MaxFails: 2, RecoveryDelay: time.Hour})
+
+	pool.RecordResult(server, false)
+	pool.RecordResult(server, true)
+	pool.RecordResult(server, false)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected success to reset the consecutive failure count")
+	}
+}
+
+func TestRecordResultFailWindow(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.SetPassiveHealthCheck(PassiveHealthConfig{MaxFails: 2, FailWindow: 20 * time.Millisecond, RecoveryDelay: time.Hour})
+
+	pool.RecordResult(server, false)
+	time.Sleep(40 * time.Millisecond)
+	pool.RecordResult(server, false)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected failures outside the window not to accumulate")
+	}
+}
+
+func TestRecordResultRecoveryProbe(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	var probes int32
+	pool.SetPassiveHealthCheck(PassiveHealthConfig{
+		MaxFails:      1,
+		RecoveryDelay: 10 * time.Millisecond,
+		Probe: func(s *Server) bool {
+			return atomic.AddInt32(&probes, 1) >= 2
+		},
+	})
+
+	pool.RecordResult(server, false)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected server to be ejected")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server to be re-admitted once the probe passes")
+	}
+	if atomic.LoadInt32(&probes) != 2 {
+		t.Errorf("expected 2 probes, got %d", atomic.LoadInt32(&probes))
+	}
+}
+
+func TestRecordResultDisabled(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	for i := 0; i < 10; i++ {
+		pool.RecordResult(server, false)
+	}
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected passive checks to be disabled by default")
+	}
+}
+
+func TestHealthCheckerTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	pool := NewPool()
+	server, _ := pool.AddServer("tcp://"+listener.Addr().String(), 1)
+	pool.SetServerHealth(server, false)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "", WithCheckType(HealthCheckTCP))
+	hc.checkServer(server)
+
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server accepting TCP connections to be healthy")
+	}
+}
+
+func TestHealthCheckerTCPClosedPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer("tcp://"+addr, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "", WithCheckType(HealthCheckTCP))
+	hc.checkServer(server)
+
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected server on a closed port to be unhealthy")
+	}
+}
+
+func TestHealthCheckerExpectedBody(t *testing.T) {
+	body := `{"status":"degraded"}`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health", WithExpectedBody(`"status":"ok"`))
+	hc.checkServer(server)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected 200 with non-matching body to be unhealthy")
+	}
+
+	body = `{"status":"ok"}`
+	hc.checkServer(server)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected matching body to be healthy")
+	}
+}
+
+func TestHealthCheckerExpectedBodyRegex(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok", "uptime": 42}`))
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health", WithExpectedBodyRegex(regexp.MustCompile(`"status":\s*"ok"`)))
+	hc.checkServer(server)
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected body matching the regex to be healthy")
+	}
+}
+
+func TestHealthCheckerBodyReadIsCapped(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), maxHealthBodySize))
+		w.Write([]byte("ok"))
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health", WithExpectedBody("ok"))
+	hc.checkServer(server)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected content beyond the body cap to be ignored")
+	}
+}
+
+func TestRemoveServer(t *testing.T) {
+	pool := NewPool()
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	if !pool.RemoveServer("http://server2:3000") {
+		t.Fatal("expected server to be removed")
+	}
+	if len(pool.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(pool.Servers))
+	}
+
+	for i := 0; i < 20; i++ {
+		server := pool.GetServer()
+		if server == nil {
+			t.Fatal("expected server to be non-nil")
+		}
+		if server.URL.Host == "server2:3000" {
+			t.Fatal("expected removed server to never be returned")
+		}
+	}
+}
+
+func TestRemoveServerNotFound(t *testing.T) {
+	pool := NewPool()
+	pool.AddServer("http://server1:3000", 1)
+
+	if pool.RemoveServer("http://server9:3000") {
+		t.Fatal("expected remove to return false for unknown server")
+	}
+	if len(pool.Servers) != 1 {
+		t.Errorf("expected 1 server, got %d", len(pool.Servers))
+	}
+}
+
+func TestServerByURL(t *testing.T) {
+	pool := NewPool()
+	pool.AddServer("http://server1:3000", 1)
+	server2, _ := pool.AddServer("http://server2:3000", 1)
+
+	if got := pool.ServerByURL("http://server2:3000"); got != server2 {
+		t.Errorf("expected server2, got %v", got)
+	}
+	if got := pool.ServerByURL("http://server9:3000"); got != nil {
+		t.Errorf("expected nil for an unknown server, got %v", got.URL)
+	}
+}
+
+func TestRemoveServerConsistentHash(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	pool.RemoveServer("http://server1:3000")
+	for i := 0; i < 20; i++ {
+		server := pool.GetServerForKey(fmt.Sprintf("/k/%d", i))
+		if server == nil || server.URL.Host != "server2:3000" {
+			t.Fatal("expected all keys to map to the remaining server")
+		}
+	}
+}
+
+func TestRemoveServerConcurrent(t *testing.T) {
+	pool := NewPool()
+	for i := 0; i < 10; i++ {
+		pool.AddServer(fmt.Sprintf("http://server%d:3000", i), 1)
+	}
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			pool.GetServer()
+		}
+		done <- true
+	}()
+
+	for i := 0; i < 10; i++ {
+		pool.RemoveServer(fmt.Sprintf("http://server%d:3000", i))
+	}
+	<-done
+
+	if pool.GetServer() != nil {
+		t.Fatal("expected empty pool to return nil")
+	}
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyWeightedRoundRobin)
+	heavy, _ := pool.AddServer("http://server1:3000", 3)
+	pool.AddServer("http://server2:3000", 1)
+
+	counts := make(map[*Server]int)
+	for i := 0; i < 40; i++ {
+		counts[pool.GetServer()]++
+	}
+
+	if counts[heavy] != 30 {
+		t.Errorf("expected weight-3 server to get 30 of 40 selections, got %d", counts[heavy])
+	}
+}
+
+func TestEffectiveWeightRamp(t *testing.T) {
+	pool := NewPool()
+	pool.SetSlowStart(10 * time.Second)
+	server, _ := pool.AddServer("http://server1:3000", 10)
+
+	pool.SetServerHealth(server, false)
+	pool.SetServerHealth(server, true)
+	recovered := time.Unix(0, atomic.LoadInt64(&server.recoveredAt))
+
+	if w := server.effectiveWeight(recovered); w != 10*minSlowStartFactor {
+		t.Errorf("expected near-zero weight at recovery, got %f", w)
+	}
+	if w := server.effectiveWeight(recovered.Add(5 * time.Second)); w != 5 {
+		t.Errorf("expected half weight midway through the ramp, got %f", w)
+	}
+	if w := server.effectiveWeight(recovered.Add(20 * time.Second)); w != 10 {
+		t.Errorf("expected full weight after the ramp, got %f", w)
+	}
+}
+
+func TestSlowStartReducesTrafficToRecoveredServer(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyWeightedRoundRobin)
+	pool.SetSlowStart(time.Minute)
+	pool.AddServer("http://server1:3000", 1)
+	recovered, _ := pool.AddServer("http://server2:3000", 1)
+
+	pool.SetServerHealth(recovered, false)
+	pool.SetServerHealth(recovered, true)
+
+	counts := make(map[*Server]int)
+	for i := 0; i < 100; i++ {
+		counts[pool.GetServer()]++
+	}
+
+	if counts[recovered] == 0 || counts[recovered] > 10 {
+		t.Errorf("expected recovering server to get a small share of 100 selections, got %d", counts[recovered])
+	}
+}
+
+func TestSlowStartDisabled(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyWeightedRoundRobin)
+	pool.AddServer("http://server1:3000", 1)
+	recovered, _ := pool.AddServer("http://server2:3000", 1)
+
+	pool.SetServerHealth(recovered, false)
+	pool.SetServerHealth(recovered, true)
+
+	counts := make(map[*Server]int)
+	for i := 0; i < 100; i++ {
+		counts[pool.GetServer()]++
+	}
+
+	if counts[recovered] != 50 {
+		t.Errorf("expected an even split without slow start, got %d", counts[recovered])
+	}
+}
+
+func TestDrainServerStopsNewSelections(t *testing.T) {
+	pool := NewPool()
+	draining, _ := pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	pool.DrainServer(draining)
+	if !draining.Draining() {
+		t.Fatal("expected server to be draining")
+	}
+	if !pool.GetServerHealth(draining) {
+		t.Fatal("expected draining to be distinct from unhealthy")
+	}
+
+	for i := 0; i < 20; i++ {
+		if pool.GetServer() == draining {
+			t.Fatal("expected draining server to receive no new requests")
+		}
+	}
+}
+
+func TestWaitDrained(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	server.Acquire()
+	server.Acquire()
+	pool.DrainServer(server)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.WaitDrained(context.Background())
+	}()
+
+	server.Release()
+	select {
+	case <-done:
+		t.Fatal("expected WaitDrained to block while a request is in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	server.Release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitDrained to return once requests are released")
+	}
+}
+
+func TestWaitDrainedContextCancel(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	server.Acquire()
+	pool.DrainServer(server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := server.WaitDrained(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestWaitDrainedIdle(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.DrainServer(server)
+
+	if err := server.WaitDrained(context.Background()); err != nil {
+		t.Fatalf("expected idle server to be drained immediately, got %v", err)
+	}
+}
+
+func TestMaxConnsConcurrent(t *testing.T) {
+	pool := NewPool()
+	small, _ := pool.AddServer("http://server1:3000", 1)
+	large, _ := pool.AddServer("http://server2:3000", 1)
+	small.MaxConns = 2
+	large.MaxConns = 4
+
+	var peak [2]int64
+	var served int64
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				server := pool.GetServer()
+				if server == nil || !server.TryAcquire() {
+					continue
+				}
+				idx := 0
+				if server == large {
+					idx = 1
+				}
+				for n := server.InFlight(); ; {
+					old := atomic.LoadInt64(&peak[idx])
+					if n <= old || atomic.CompareAndSwapInt64(&peak[idx], old, n) {
+						break
+					}
+				}
+				atomic.AddInt64(&served, 1)
+				runtime.Gosched()
+				server.Release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak[0] > 2 || peak[1] > 4 {
+		t.Fatalf("expected in-flight never to exceed MaxConns, peaked at %d and %d", peak[0], peak[1])
+	}
+	if served == 0 {
+		t.Fatal("expected requests to be served")
+	}
+	if small.InFlight() != 0 || large.InFlight() != 0 {
+		t.Fatalf("expected every slot to be released, got %d and %d", small.InFlight(), large.InFlight())
+	}
+
+	small.Acquire()
+	small.Acquire()
+	if small.TryAcquire() {
+		t.Fatal("expected no slot on a saturated server")
+	}
+	for i := 0; i < 20; i++ {
+		if pool.GetServer() == small {
+			t.Fatal("expected saturated server to be skipped")
+		}
+	}
+}
+
+func TestRecordLatencyEWMA(t *testing.T) {
+	pool := NewPool()
+	pool.SetEWMADecay(0.5)
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	pool.RecordLatency(server, 100*time.Millisecond)
+	if server.Latency() != 100*time.Millisecond {
+		t.Fatalf("expected first sample to seed the average, got %v", server.Latency())
+	}
+
+	pool.RecordLatency(server, 200*time.Millisecond)
+	if server.Latency() != 150*time.Millisecond {
+		t.Errorf("expected average of 150ms, got %v", server.Latency())
+	}
+}
+
+func TestEWMAPrefersFasterServer(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyEWMA)
+	fast, _ := pool.AddServer("http://server1:3000", 1)
+	slow, _ := pool.AddServer("http://server2:3000", 1)
+
+	for i := 0; i < 5; i++ {
+		pool.RecordLatency(fast, 10*time.Millisecond)
+		pool.RecordLatency(slow, 100*time.Millisecond)
+	}
+
+	counts := make(map[*Server]int)
+	for i := 0; i < 1000; i++ {
+		counts[pool.GetServer()]++
+	}
+
+	if counts[fast] < 800 {
+		t.Errorf("expected faster server to get the majority of 1000 selections, got %d", counts[fast])
+	}
+	if counts[slow] == 0 {
+		t.Error("expected slower server to still receive some exploratory traffic")
+	}
+}
+
+func TestEWMASkipsUnhealthy(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyEWMA)
+	fast, _ := pool.AddServer("http://server1:3000", 1)
+	slow, _ := pool.AddServer("http://server2:3000", 1)
+	pool.RecordLatency(fast, time.Millisecond)
+	pool.RecordLatency(slow, time.Second)
+
+	pool.SetServerHealth(fast, false)
+	for i := 0; i < 20; i++ {
+		if pool.GetServer() != slow {
+			t.Fatal("expected only the healthy server to be selected")
+		}
+	}
+}
+
+func TestHealthCheckerMethodAndHeaders(t *testing.T) {
+	var gotMethod, gotAuth string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotAuth = r.Header.Get("Authorization")
+		if gotAuth != "Bearer health-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health",
+		WithMethod(http.MethodHead),
+		WithHeaders(map[string]string{"Authorization": "Bearer health-token"}))
+	hc.checkServer(server)
+
+	if gotMethod != http.MethodHead {
+		t.Errorf("expected HEAD request, got %s", gotMethod)
+	}
+	if gotAuth != "Bearer health-token" {
+		t.Errorf("expected Authorization header, got %q", gotAuth)
+	}
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected server to be healthy")
+	}
+}
+
+func TestHealthCheckerMissingRequiredHeader(t *testing.T) {
+	var gotMethod string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health")
+	hc.checkServer(server)
+
+	if gotMethod != http.MethodGet {
+		t.Errorf("expected default GET request, got %s", gotMethod)
+	}
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected server to be unhealthy without the required header")
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	server.Acquire()
+	pool.RecordLatency(server, 25*time.Millisecond)
+	pool.RecordResult(server, true)
+	server.Release()
+
+	server.Acquire()
+	pool.RecordResult(server, false)
+	server.Release()
+
+	stats := pool.ServerStats()
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 stats, got %d", len(stats))
+	}
+
+	stat := stats[0]
+	if stat.URL != "http://server1:3000" {
+		t.Errorf("unexpected URL %s", stat.URL)
+	}
+	if stat.Requests != 2 || stat.Successes != 1 || stat.Failures != 1 {
+		t.Errorf("unexpected counters: %+v", stat)
+	}
+	if stat.LastLatency != 25*time.Millisecond {
+		t.Errorf("expected last latency 25ms, got %v", stat.LastLatency)
+	}
+	if stats[1].Requests != 0 {
+		t.Errorf("expected idle server to have no requests, got %d", stats[1].Requests)
+	}
+}
+
+func TestServerID(t *testing.T) {
+	pool := NewPool()
+	server1, _ := pool.AddServer("http://server1:3000", 1)
+	server2, _ := pool.AddServer("http://server2:3000", 1)
+
+	if server1.ID == "" || server1.ID == server2.ID {
+		t.Fatalf("expected distinct non-empty IDs, got %q and %q", server1.ID, server2.ID)
+	}
+
+	other := NewPool()
+	again, _ := other.AddServer("http://server1:3000", 1)
+	if again.ID != server1.ID {
+		t.Error("expected ID to be stable for the same URL")
+	}
+}
+
+func TestStickyServer(t *testing.T) {
+	pool := NewPool()
+	pool.EnableStickySessions("")
+	pool.AddServer("http://server1:3000", 1)
+	pinned, _ := pool.AddServer("http://server2:3000", 1)
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultStickyCookie, Value: pinned.ID})
+
+	if got := pool.StickyServer(req); got != pinned {
+		t.Fatal("expected sticky cookie to select the pinned server")
+	}
+
+	pool.SetServerHealth(pinned, false)
+	if got := pool.StickyServer(req); got != nil {
+		t.Fatal("expected no sticky server when the pinned server is unhealthy")
+	}
+}
+
+func TestStickyServerDisabled(t *testing.T) {
+	pool := NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.AddCookie(&http.Cookie{Name: DefaultStickyCookie, Value: server.ID})
+
+	if pool.StickyServer(req) != nil {
+		t.Fatal("expected sticky sessions to be disabled by default")
+	}
+
+	w := httptest.NewRecorder()
+	pool.SetStickyCookie(w, server)
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Fatal("expected no cookie when sticky sessions are disabled")
+	}
+}
+
+func TestHealthCheckerJitterStaggersProbes(t *testing.T) {
+	pool := NewPool()
+	for i := 0; i < 8; i++ {
+		pool.AddServer(fmt.Sprintf("http://server%d:3000", i), 1)
+	}
+
+	interval := 10 * time.Second
+	hc := NewHealthChecker(pool, interval, time.Second, "/health", WithJitter(0.2, rand.New(rand.NewSource(1))))
+
+	var mu sync.Mutex
+	var delays []time.Duration
+	hc.afterFunc = func(d time.Duration, f func()) {
+		mu.Lock()
+		delays = append(delays, d)
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Initial round spreads probes across the whole interval
+	hc.scheduleRound(ctx, 1)
+	// Later rounds add up to 20% of the interval
+	hc.scheduleRound(ctx, hc.jitter)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delays) != 16 {
+		t.Fatalf("expected 16 scheduled probes, got %d", len(delays))
+	}
+
+	distinct := make(map[time.Duration]bool)
+	for i, d := range delays {
+		limit := interval
+		if i >= 8 {
+			limit = interval / 5
+		}
+		if d < 0 || d >= limit {
+			t.Errorf("probe %d delay %v outside [0, %v)", i, d, limit)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 12 {
+		t.Errorf("expected probe times not to be aligned, got %d distinct delays", len(distinct))
+	}
+}
+
+func TestHealthCheckerJitterDeterministic(t *testing.T) {
+	pool := NewPool()
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	record := func() []time.Duration {
+		hc := NewHealthChecker(pool, time.Second, time.Second, "/health", WithJitter(0.5, rand.New(rand.NewSource(42))))
+		var delays []time.Duration
+		hc.afterFunc = func(d time.Duration, f func()) { delays = append(delays, d) }
+		hc.scheduleRound(context.Background(), 1)
+		return delays
+	}
+
+	first, second := record(), record()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected identical delays for the same seed, got %v and %v", first, second)
+		}
+	}
+}
+
+func TestHealthCheckerJitterRunsProbes(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	hc := NewHealthChecker(pool, 50*time.Millisecond, time.Second, "/health", WithJitter(0.1, nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hc.Start(ctx)
+	defer hc.Stop()
+
+	time.Sleep(150 * time.Millisecond)
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected jittered probes to mark the server unhealthy")
+	}
+}
+
+func TestPoolStatus(t *testing.T) {
+	pool := NewPool()
+	server1, _ := pool.AddServer("http://server1:3000", 1)
+	server2, _ := pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	status := pool.Status()
+	if status.Total != 3 || status.Healthy != 3 || status.Draining != 0 || status.Available != 3 {
+		t.Fatalf("unexpected initial status: %+v", status)
+	}
+
+	pool.SetServerHealth(server1, false)
+	pool.DrainServer(server2)
+	server2.Acquire()
+
+	status = pool.Status()
+	if status.Total != 3 || status.Healthy != 2 || status.Draining != 1 || status.Available != 1 {
+		t.Fatalf("unexpected status after changes: %+v", status)
+	}
+	if status.Servers[0].URL != "http://server1:3000" || status.Servers[0].Healthy {
+		t.Errorf("expected server1 to be reported unhealthy: %+v", status.Servers[0])
+	}
+	if !status.Servers[1].Draining || status.Servers[1].InFlight != 1 {
+		t.Errorf("expected server2 draining with 1 in flight: %+v", status.Servers[1])
+	}
+}
+
+func TestRoundRobinStrictCycle(t *testing.T) {
+	pool := NewPool()
+	pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+	pool.AddServer("http://server3:3000", 1)
+
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		server := pool.GetServer()
+		expected := pool.Servers[i%3]
+		if server != expected {
+			t.Fatalf("selection %d: expected %s, got %s", i, expected.URL.Host, server.URL.Host)
+		}
+		counts[server.URL.Host]++
+	}
+
+	for host, count := range counts {
+		if count != 10 {
+			t.Errorf("expected %s to be selected 10 times, got %d", host, count)
+		}
+	}
+}
+
+func TestRoundRobinSkipsUnhealthy(t *testing.T) {
+	pool := NewPool()
+	server1, _ := pool.AddServer("http://server1:3000", 1)
+	server2, _ := pool.AddServer("http://server2:3000", 1)
+	server3, _ := pool.AddServer("http://server3:3000", 1)
+	pool.SetServerHealth(server2, false)
+
+	expected := []*Server{server1, server3, server1, server3}
+	for i, want := range expected {
+		if got := pool.GetServer(); got != want {
+			t.Fatalf("selection %d: expected %s, got %s", i, want.URL.Host, got.URL.Host)
+		}
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyWeightedRoundRobin)
+	boosted, _ := pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	count := func() int {
+		n := 0
+		for i := 0; i < 40; i++ {
+			if pool.GetServer() == boosted {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := count(); n != 20 {
+		t.Fatalf("expected an even split before the change, got %d of 40", n)
+	}
+
+	pool.SetWeight(boosted, 3)
+	if atomic.LoadInt32(&boosted.Weight) != 3 {
+		t.Fatal("expected weight to be updated")
+	}
+	if n := count(); n != 30 {
+		t.Errorf("expected a 3:1 split after the change, got %d of 40", n)
+	}
+}
+
+func TestSetWeightRebuildsRing(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyConsistentHash)
+	boosted, _ := pool.AddServer("http://server1:3000", 1)
+	pool.AddServer("http://server2:3000", 1)
+
+	pool.SetWeight(boosted, 9)
+
+	owned := 0
+	for i := 0; i < 1000; i++ {
+		if pool.GetServerForKey(fmt.Sprintf("/k/%d", i)) == boosted {
+			owned++
+		}
+	}
+	if owned < 800 {
+		t.Errorf("expected heavier server to own most keys after reweighting, got %d of 1000", owned)
+	}
+}
+
+func TestHealthCheckerStateChangeHandler(t *testing.T) {
+	var status int32 = http.StatusOK
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer mockServer.Close()
+
+	pool := NewPool()
+	server, _ := pool.AddServer(mockServer.URL, 1)
+
+	var transitions []bool
+	hc := NewHealthChecker(pool, time.Second, time.Second, "/health",
+		WithStateChangeHandler(func(s *Server, healthy bool) {
+			if s != server {
+				t.Errorf("unexpected server %s", s.URL)
+			}
+			transitions = append(transitions, healthy)
+		}))
+
+	sequence := []int32{200, 200, 503, 503, 200, 200, 503}
+	for _, code := range sequence {
+		atomic.StoreInt32(&status, code)
+		hc.checkServer(server)
+	}
+
+	expected := []bool{false, true, false}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("expected transitions %v, got %v", expected, transitions)
+		}
+	}
+}
+
+func TestPoolRetryAttempts(t *testing.T) {
+	pool := NewPool()
+	if n := pool.RetryAttempts(); n != 0 {
+		t.Errorf("expected no retry attempts by default, got %d", n)
+	}
+	pool.SetRetryAttempts(3)
+	if n := pool.RetryAttempts(); n != 3 {
+		t.Errorf("expected 3 retry attempts, got %d", n)
+	}
+}
+
+func TestLeastConnectionsPrefersIdleServer(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyLeastConnections)
+	busy, _ := pool.AddServer("http://server1:3000", 1)
+	idle, _ := pool.AddServer("http://server2:3000", 1)
+
+	busy.Acquire()
+	busy.Acquire()
+	idle.Acquire()
+	for i := 0; i < 20; i++ {
+		if pool.GetServer() != idle {
+			t.Fatal("expected the server with fewer requests in flight")
+		}
+	}
+
+	idle.Acquire()
+	idle.Acquire()
+	if pool.GetServer() != busy {
+		t.Error("expected selection to follow in-flight counts")
+	}
+}
+
+func TestLeastConnectionsSkipsUnhealthy(t *testing.T) {
+	pool := NewPool()
+	pool.SetStrategy(StrategyLeastConnections)
+	idle, _ := pool.AddServer("http://server1:3000", 1)
+	busy, _ := pool.AddServer("http://server2:3000", 1)
+	busy.Acquire()
+
+	pool.SetServerHealth(idle, false)
+	for i := 0; i < 20; i++ {
+		if pool.GetServer() != busy {
+			t.Fatal("expected only the healthy server to be selected")
+		}
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := map[string]Strategy{
+		"":                     StrategyRoundRobin,
+		"round_robin":          StrategyRoundRobin,
+		"weighted_round_robin": StrategyWeightedRoundRobin,
+		"least_connections":    StrategyLeastConnections,
+		"consistent_hash":      StrategyConsistentHash,
+		"ip_hash":              StrategyIPHash,
+		"ewma":                 StrategyEWMA,
+	}
+	for name, want := range tests {
+		got, err := ParseStrategy(name)
+		if err != nil || got != want {
+			t.Errorf("ParseStrategy(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
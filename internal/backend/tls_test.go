@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTLSBackend(t *testing.T) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, writePEM(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+func get(transport *http.Transport, url string) error {
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestNewTransportCustomCA(t *testing.T) {
+	server, caFile := newTLSBackend(t)
+
+	transport, err := NewTransport(TLSConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := get(transport, server.URL); err == nil {
+		t.Fatal("expected the handshake to fail without the CA")
+	}
+
+	transport, err = NewTransport(TLSConfig{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := get(transport, server.URL); err != nil {
+		t.Errorf("expected the custom CA to be trusted, got %v", err)
+	}
+}
+
+func TestNewTransportInsecureSkipVerify(t *testing.T) {
+	server, _ := newTLSBackend(t)
+
+	transport, err := NewTransport(TLSConfig{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := get(transport, server.URL); err != nil {
+		t.Errorf("expected verification to be skipped, got %v", err)
+	}
+}
+
+func TestNewTransportClientCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "proxy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, "client.pem", "CERTIFICATE", der)
+	keyFile := writePEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER)
+
+	clientCert, _ := x509.ParseCertificate(der)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	transport, _ := NewTransport(TLSConfig{InsecureSkipVerify: true})
+	if err := get(transport, server.URL); err == nil {
+		t.Fatal("expected the server to require a client certificate")
+	}
+
+	transport, err = NewTransport(TLSConfig{InsecureSkipVerify: true, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := get(transport, server.URL); err != nil {
+		t.Errorf("expected mutual TLS to succeed, got %v", err)
+	}
+}
+
+func TestNewTransportInvalidFiles(t *testing.T) {
+	if _, err := NewTransport(TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0o600)
+	if _, err := NewTransport(TLSConfig{CAFile: empty}); err == nil {
+		t.Error("expected an error for a CA file without certificates")
+	}
+
+	if _, err := NewTransport(TLSConfig{CertFile: empty}); err == nil {
+		t.Error("expected an error for an incomplete client key pair")
+	}
+}
@@ -2,35 +2,123 @@ package config
 
 import (
 	"encoding/json"
-	"io/ioutil"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
+	// Include lists files (globs allowed) whose routes and backends are
+	// merged into this config
+	Include  []string        `yaml:"include" json:"include"`
 	Server   ServerConfig    `yaml:"server" json:"server"`
 	Routes   []RouteConfig   `yaml:"routes" json:"routes"`
 	Backends []BackendConfig `yaml:"backends" json:"backends"`
 	Policies PoliciesConfig  `yaml:"policies" json:"policies"`
+	Metrics  MetricsConfig   `yaml:"metrics" json:"metrics"`
+	Tracing  TracingConfig   `yaml:"tracing" json:"tracing"`
+	Admin    AdminConfig     `yaml:"admin" json:"admin"`
+	Probes   ProbesConfig    `yaml:"probes" json:"probes"`
+
+	sources []string // see Sources
 }
 
 type ServerConfig struct {
-	Host     string `yaml:"host" json:"host"`
-	Port     string `yaml:"port" json:"port"`
-	TLS      bool   `yaml:"tls" json:"tls"`
-	CertFile string `yaml:"cert_file" json:"cert_file"`
-	KeyFile  string `yaml:"key_file" json:"key_file"`
+	Host           string   `yaml:"host" json:"host"`
+	Port           string   `yaml:"port" json:"port"`
+	TLS            bool     `yaml:"tls" json:"tls"`
+	CertFile       string   `yaml:"cert_file" json:"cert_file"`
+	KeyFile        string   `yaml:"key_file" json:"key_file"`
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+	PreserveHost   bool     `yaml:"preserve_host" json:"preserve_host"`
+	// ErrorPages maps a status code to a file served for the proxy's own
+	// error responses with that status
+	ErrorPages map[int]string `yaml:"error_pages" json:"error_pages"`
+	// Listeners replace the single Host/Port listener when set
+	Listeners []ListenerConfig `yaml:"listeners" json:"listeners"`
+}
+
+// ListenerConfig is one address the proxy serves on. A plaintext listener
+// with RedirectHTTPS redirects every request to the first TLS listener.
+type ListenerConfig struct {
+	Address       string `yaml:"address" json:"address"`
+	TLS           bool   `yaml:"tls" json:"tls"`
+	CertFile      string `yaml:"cert_file" json:"cert_file"`
+	KeyFile       string `yaml:"key_file" json:"key_file"`
+	RedirectHTTPS bool   `yaml:"redirect_https" json:"redirect_https"`
+}
+
+// MetricsConfig exposes Prometheus metrics on Path, served by the proxy
+// listener unless Address names a separate admin listener
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Path    string `yaml:"path" json:"path"`
+	Address string `yaml:"address" json:"address"`
+}
+
+// TracingConfig exports OpenTelemetry spans over OTLP/HTTP
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled" json:"enabled"`
+	Endpoint    string  `yaml:"endpoint" json:"endpoint"`
+	Insecure    bool    `yaml:"insecure" json:"insecure"`
+	ServiceName string  `yaml:"service_name" json:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" json:"sample_ratio"`
+}
+
+// AdminConfig serves the proxy's admin endpoints, such as /stats, on a
+// separate listener. Setting Token requires it as a bearer token on every
+// admin request and enables managing routes and backend servers through the
+// admin API.
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Address string `yaml:"address" json:"address"`
+	Token   string `yaml:"token" json:"token"`
+}
+
+// ProbesConfig serves liveness and readiness endpoints on the proxy
+// listeners, for orchestrators such as Kubernetes. On shutdown the proxy
+// reports not ready and keeps serving for ShutdownDelay before it stops.
+type ProbesConfig struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	LivenessPath  string `yaml:"liveness_path" json:"liveness_path"`
+	ReadinessPath string `yaml:"readiness_path" json:"readiness_path"`
+	ShutdownDelay string `yaml:"shutdown_delay" json:"shutdown_delay"`
 }
 
 type RouteConfig struct {
-	Name       string            `yaml:"name" json:"name"`
-	PathPrefix string            `yaml:"path_prefix" json:"path_prefix"`
-	Pattern    string            `yaml:"pattern" json:"pattern"`
-	Subdomain  string            `yaml:"subdomain" json:"subdomain"`
-	Headers    map[string]string `yaml:"headers" json:"headers"`
-	Methods    []string          `yaml:"methods" json:"methods"`
-	BackendID  string            `yaml:"backend_id" json:"backend_id"`
-	Priority   int               `yaml:"priority" json:"priority"`
+	Name            string            `yaml:"name" json:"name"`
+	PathPrefix      string            `yaml:"path_prefix" json:"path_prefix"`
+	StripPrefix     bool              `yaml:"strip_prefix" json:"strip_prefix"`
+	Pattern         string            `yaml:"pattern" json:"pattern"`
+	Glob            string            `yaml:"glob" json:"glob"`
+	Host            string            `yaml:"host" json:"host"`
+	Subdomain       string            `yaml:"subdomain" json:"subdomain"`
+	Headers         map[string]string `yaml:"headers" json:"headers"`
+	HeadersNot      map[string]string `yaml:"headers_not" json:"headers_not"`
+	HeadersRegex    map[string]string `yaml:"headers_regex" json:"headers_regex"`
+	Query           map[string]string `yaml:"query" json:"query"`
+	Methods         []string          `yaml:"methods" json:"methods"`
+	BackendID       string            `yaml:"backend_id" json:"backend_id"`
+	CanaryBackendID string            `yaml:"canary_backend_id" json:"canary_backend_id"`
+	CanaryPercent   float64           `yaml:"canary_percent" json:"canary_percent"`
+	Priority        int               `yaml:"priority" json:"priority"`
+	Timeout         string            `yaml:"timeout" json:"timeout"`
+	Enabled         *bool             `yaml:"enabled" json:"enabled"`
+	CaseInsensitive bool              `yaml:"case_insensitive" json:"case_insensitive"`
+	Rewrite         *RewriteConfig    `yaml:"rewrite" json:"rewrite"`
+	Redirect        *RedirectConfig   `yaml:"redirect" json:"redirect"`
+	PreserveHost    bool              `yaml:"preserve_host" json:"preserve_host"`
+	RequestHeaders  map[string]string `yaml:"request_headers" json:"request_headers"`
+}
+
+type RedirectConfig struct {
+	Target string `yaml:"target" json:"target"`
+	Status int    `yaml:"status" json:"status"`
+}
+
+type RewriteConfig struct {
+	StripPrefix string `yaml:"strip_prefix" json:"strip_prefix"`
+	Pattern     string `yaml:"pattern" json:"pattern"`
+	Replacement string `yaml:"replacement" json:"replacement"`
 }
 
 type BackendConfig struct {
@@ -39,20 +127,66 @@ type BackendConfig struct {
 	HealthCheck   HealthConfig   `yaml:"health_check" json:"health_check"`
 	LoadBalancing string         `yaml:"load_balancing" json:"load_balancing"`
 	Weights       map[string]int `yaml:"weights" json:"weights"`
+	MaxConns      map[string]int `yaml:"max_conns" json:"max_conns"`
+	SlowStart     string         `yaml:"slow_start" json:"slow_start"`
+	StickyCookie  string         `yaml:"sticky_cookie" json:"sticky_cookie"`
+	TLS           *BackendTLS    `yaml:"tls" json:"tls"`
+	RetryAttempts int            `yaml:"retry_attempts" json:"retry_attempts"`
+}
+
+// BackendTLS configures how the proxy verifies and authenticates to
+// backends served over HTTPS
+type BackendTLS struct {
+	CAFile             string `yaml:"ca_file" json:"ca_file"`
+	CertFile           string `yaml:"cert_file" json:"cert_file"`
+	KeyFile            string `yaml:"key_file" json:"key_file"`
+	ServerName         string `yaml:"server_name" json:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
 }
 
 type HealthConfig struct {
-	Enabled  bool   `yaml:"enabled" json:"enabled"`
-	Interval string `yaml:"interval" json:"interval"`
-	Timeout  string `yaml:"timeout" json:"timeout"`
-	Path     string `yaml:"path" json:"path"`
+	Enabled           bool              `yaml:"enabled" json:"enabled"`
+	Interval          string            `yaml:"interval" json:"interval"`
+	Timeout           string            `yaml:"timeout" json:"timeout"`
+	Path              string            `yaml:"path" json:"path"`
+	Type              string            `yaml:"type" json:"type"`
+	Method            string            `yaml:"method" json:"method"`
+	Headers           map[string]string `yaml:"headers" json:"headers"`
+	Jitter            float64           `yaml:"jitter" json:"jitter"`
+	ExpectedBody      string            `yaml:"expected_body" json:"expected_body"`
+	ExpectedBodyRegex string            `yaml:"expected_body_regex" json:"expected_body_regex"`
+	Passive           PassiveConfig     `yaml:"passive" json:"passive"`
+}
+
+type PassiveConfig struct {
+	MaxFails      int    `yaml:"max_fails" json:"max_fails"`
+	FailWindow    string `yaml:"fail_window" json:"fail_window"`
+	RecoveryDelay string `yaml:"recovery_delay" json:"recovery_delay"`
 }
 
 type PoliciesConfig struct {
-	RateLimit RateLimitPolicy `yaml:"rate_limit" json:"rate_limit"`
-	CORS      CORSPolicy      `yaml:"cors" json:"cors"`
-	Auth      AuthPolicy      `yaml:"auth" json:"auth"`
-	Cache     CachePolicy     `yaml:"cache" json:"cache"`
+	RateLimit       RateLimitPolicy       `yaml:"rate_limit" json:"rate_limit"`
+	TenantRateLimit TenantRateLimitPolicy `yaml:"tenant_rate_limit" json:"tenant_rate_limit"`
+	CORS            CORSPolicy            `yaml:"cors" json:"cors"`
+	Auth            AuthPolicy            `yaml:"auth" json:"auth"`
+	Cache           CachePolicy           `yaml:"cache" json:"cache"`
+	SecurityHeaders SecurityHeadersPolicy `yaml:"security_headers" json:"security_headers"`
+	Retry           RetryPolicy           `yaml:"retry" json:"retry"`
+	Timeout         string                `yaml:"timeout" json:"timeout"`
+	Headers         HeadersPolicy         `yaml:"headers" json:"headers"`
+	Response        ResponsePolicy        `yaml:"response" json:"response"`
+	CircuitBreaker  CircuitBreakerPolicy  `yaml:"circuit_breaker" json:"circuit_breaker"`
+}
+
+// CircuitBreakerPolicy opens a per-server circuit breaker after
+// FailureThreshold consecutive upstream failures; Timeout is how long it
+// stays open
+type CircuitBreakerPolicy struct {
+	Enabled          bool   `yaml:"enabled" json:"enabled"`
+	FailureThreshold int64  `yaml:"failure_threshold" json:"failure_threshold"`
+	SuccessThreshold int64  `yaml:"success_threshold" json:"success_threshold"`
+	HalfOpenRequests int64  `yaml:"half_open_requests" json:"half_open_requests"`
+	Timeout          string `yaml:"timeout" json:"timeout"`
 }
 
 type RateLimitPolicy struct {
@@ -61,49 +195,96 @@ type RateLimitPolicy struct {
 	Window      string `yaml:"window" json:"window"`
 }
 
+// TenantRateLimitPolicy limits requests per tenant, on top of the global
+// rate limit. The tenant is the authenticated client ID, or for
+// unauthenticated requests is named by Header. Authenticated tenants
+// without an entry in Tenants get Default; other tenants without one are
+// only globally limited.
+type TenantRateLimitPolicy struct {
+	Enabled bool                   `yaml:"enabled" json:"enabled"`
+	Header  string                 `yaml:"header" json:"header"`
+	Default TenantLimit            `yaml:"default" json:"default"`
+	Tenants map[string]TenantLimit `yaml:"tenants" json:"tenants"`
+}
+
+type TenantLimit struct {
+	MaxRequests int    `yaml:"max_requests" json:"max_requests"`
+	Window      string `yaml:"window" json:"window"`
+}
+
 type CORSPolicy struct {
-	Enabled        bool     `yaml:"enabled" json:"enabled"`
-	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	Enabled          bool     `yaml:"enabled" json:"enabled"`
+	AllowedOrigins   []string `yaml:"allowed_origins" json:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods" json:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers" json:"allowed_headers"`
+	ExposedHeaders   []string `yaml:"exposed_headers" json:"exposed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials" json:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age" json:"max_age"`
+	ReflectRequest   bool     `yaml:"reflect_request" json:"reflect_request"`
 }
 
 type AuthPolicy struct {
-	Enabled bool   `yaml:"enabled" json:"enabled"`
-	Type    string `yaml:"type" json:"type"`
-	Secret  string `yaml:"secret" json:"secret"`
+	Enabled          bool   `yaml:"enabled" json:"enabled"`
+	Type             string `yaml:"type" json:"type"`
+	Secret           string `yaml:"secret" json:"secret"`
+	IntrospectionURL string `yaml:"introspection_url" json:"introspection_url"`
+	ClientID         string `yaml:"client_id" json:"client_id"`
+	ClientSecret     string `yaml:"client_secret" json:"client_secret"`
+	CacheTTL         string `yaml:"cache_ttl" json:"cache_ttl"`
 }
 
-type CachePolicy struct {
-	Enabled bool     `yaml:"enabled" json:"enabled"`
-	TTL     string   `yaml:"ttl" json:"ttl"`
-	Methods []string `yaml:"methods" json:"methods"`
+type SecurityHeadersPolicy struct {
+	Enabled                 bool   `yaml:"enabled" json:"enabled"`
+	StrictTransportSecurity string `yaml:"strict_transport_security" json:"strict_transport_security"`
+	ContentTypeOptions      string `yaml:"content_type_options" json:"content_type_options"`
+	FrameOptions            string `yaml:"frame_options" json:"frame_options"`
+	ReferrerPolicy          string `yaml:"referrer_policy" json:"referrer_policy"`
+	ContentSecurityPolicy   string `yaml:"content_security_policy" json:"content_security_policy"`
 }
 
-func LoadFromYAML(filename string) (*Config, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
+type RetryPolicy struct {
+	Enabled  bool     `yaml:"enabled" json:"enabled"`
+	Attempts int      `yaml:"attempts" json:"attempts"`
+	Backoff  string   `yaml:"backoff" json:"backoff"`
+	Methods  []string `yaml:"methods" json:"methods"`
+}
 
-	config := &Config{}
-	err = yaml.Unmarshal(data, config)
-	if err != nil {
-		return nil, err
-	}
+type HeadersPolicy struct {
+	Enabled  bool        `yaml:"enabled" json:"enabled"`
+	Request  HeaderRules `yaml:"request" json:"request"`
+	Response HeaderRules `yaml:"response" json:"response"`
+}
+
+type HeaderRules struct {
+	Add    map[string]string `yaml:"add" json:"add"`
+	Set    map[string]string `yaml:"set" json:"set"`
+	Remove []string          `yaml:"remove" json:"remove"`
+}
+
+// ResponsePolicy edits backend response headers before they are cached or
+// returned; Remove entries ending in * match a header name prefix
+type ResponsePolicy struct {
+	Remove          []string          `yaml:"remove" json:"remove"`
+	Set             map[string]string `yaml:"set" json:"set"`
+	RewriteLocation bool              `yaml:"rewrite_location" json:"rewrite_location"`
+}
 
-	return config, nil
+type CachePolicy struct {
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	TTL          string   `yaml:"ttl" json:"ttl"`
+	Methods      []string `yaml:"methods" json:"methods"`
+	MaxEntries   int      `yaml:"max_entries" json:"max_entries"`
+	MaxBytes     int64    `yaml:"max_bytes" json:"max_bytes"`
+	ReapInterval string   `yaml:"reap_interval" json:"reap_interval"`
+	PurgeToken   string   `yaml:"purge_token" json:"purge_token"`
+
+	StaleWhileRevalidate string `yaml:"stale_while_revalidate" json:"stale_while_revalidate"`
+}
+
+func LoadFromYAML(filename string) (*Config, error) {
+	return load(filename, yaml.Unmarshal, nil)
 }
 
 func LoadFromJSON(filename string) (*Config, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	config := &Config{}
-	err = json.Unmarshal(data, config)
-	if err != nil {
-		return nil, err
-	}
-
-	return config, nil
+	return load(filename, json.Unmarshal, nil)
 }
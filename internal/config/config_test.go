@@ -356,3 +356,75 @@ policies:
 		t.Error("expected cache to be enabled")
 	}
 }
+
+func TestLoadFromYAMLRouteQuery(t *testing.T) {
+	yaml := `
+routes:
+  - name: v2
+    path_prefix: /api
+    query:
+      version: "2"
+      debug: ""
+    backend_id: backend1
+`
+
+	tmpfile, err := ioutil.TempFile("", "config*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(yaml); err != nil {
+		t.Fatalf("failed to write to temp file: %v", err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadFromYAML(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	query := cfg.Routes[0].Query
+	if query["version"] != "2" {
+		t.Errorf("expected version 2, got %q", query["version"])
+	}
+	if value, ok := query["debug"]; !ok || value != "" {
+		t.Errorf("expected debug present with empty value, got %q (present=%v)", value, ok)
+	}
+}
+
+func TestLoadFromYAMLRouteEnabled(t *testing.T) {
+	yaml := `
+routes:
+  - name: default
+    path_prefix: /
+    backend_id: backend1
+  - name: off
+    path_prefix: /off
+    enabled: false
+    backend_id: backend1
+`
+
+	tmpfile, err := ioutil.TempFile("", "config*.yaml")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(yaml); err != nil {
+		t.Fatalf("failed to write to temp file: %v", err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadFromYAML(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cfg.Routes[0].Enabled != nil {
+		t.Error("expected enabled to be unset when omitted")
+	}
+	if enabled := cfg.Routes[1].Enabled; enabled == nil || *enabled {
+		t.Error("expected enabled to be false")
+	}
+}
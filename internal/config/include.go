@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// load reads filename with unmarshal and merges in the routes and backends
+// of the files it includes. Include paths are relative to the including
+// file and may be globs; matches are loaded in sorted order. Entries from
+// later includes replace earlier ones, including the main file's, with the
+// same route name or backend ID. Only routes, backends and further includes
+// are taken from included files.
+func load(filename string, unmarshal func([]byte, interface{}) error, stack []string) (*Config, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, path) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack, path), " -> "))
+	}
+	stack = append(stack, path)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	config.sources = []string{path}
+
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		files := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			if files, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid include '%s': %w", pattern, err)
+			}
+			config.sources = append(config.sources, pattern)
+		}
+		for _, file := range files {
+			included, err := load(file, unmarshal, stack)
+			if err != nil {
+				return nil, err
+			}
+			config.merge(included)
+			config.sources = append(config.sources, included.sources...)
+		}
+	}
+
+	return config, nil
+}
+
+// merge adds the routes and backends of other, replacing entries with the
+// same route name or backend ID in place
+func (c *Config) merge(other *Config) {
+	for _, route := range other.Routes {
+		i := slices.IndexFunc(c.Routes, func(r RouteConfig) bool { return r.Name == route.Name })
+		if i < 0 {
+			c.Routes = append(c.Routes, route)
+		} else {
+			c.Routes[i] = route
+		}
+	}
+	for _, backend := range other.Backends {
+		i := slices.IndexFunc(c.Backends, func(b BackendConfig) bool { return b.ID == backend.ID })
+		if i < 0 {
+			c.Backends = append(c.Backends, backend)
+		} else {
+			c.Backends[i] = backend
+		}
+	}
+}
+
+// Sources returns the absolute paths of the files the config was loaded
+// from, the main file first, and of its include globs, which files created
+// later may match
+func (c *Config) Sources() []string {
+	return c.sources
+}
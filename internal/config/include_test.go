@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadFromYAMLInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": `
+include:
+  - routes/*.yaml
+server:
+  port: "8080"
+routes:
+  - name: main
+    path_prefix: /
+    backend_id: web
+backends:
+  - id: web
+    servers:
+      - http://localhost:3000
+`,
+		"routes/a.yaml": `
+routes:
+  - name: users
+    path_prefix: /users
+    backend_id: api
+backends:
+  - id: api
+    servers:
+      - http://localhost:4000
+`,
+		"routes/b.yaml": `
+routes:
+  - name: orders
+    path_prefix: /orders
+    backend_id: api
+  - name: users
+    path_prefix: /v2/users
+    backend_id: api
+backends:
+  - id: api
+    servers:
+      - http://localhost:5000
+`,
+	})
+
+	cfg, err := LoadFromYAML(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(cfg.Routes) != 3 {
+		t.Errorf("expected 3 routes, got %d", len(cfg.Routes))
+	}
+	if len(cfg.Backends) != 2 {
+		t.Errorf("expected 2 backends, got %d", len(cfg.Backends))
+	}
+	if cfg.Server.Port != "8080" {
+		t.Errorf("expected main file settings to be kept, got port %q", cfg.Server.Port)
+	}
+	for _, route := range cfg.Routes {
+		if route.Name == "users" && route.PathPrefix != "/v2/users" {
+			t.Errorf("expected later include to override route, got %s", route.PathPrefix)
+		}
+	}
+	for _, backend := range cfg.Backends {
+		if backend.ID == "api" && backend.Servers[0] != "http://localhost:5000" {
+			t.Errorf("expected later include to override backend, got %v", backend.Servers)
+		}
+	}
+}
+
+func TestLoadFromYAMLIncludeSources(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml":   "include: [base.yaml, routes/*.yaml]\n",
+		"base.yaml":     "routes: []\n",
+		"routes/a.yaml": "routes: []\n",
+	})
+
+	cfg, err := LoadFromYAML(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []string{
+		filepath.Join(dir, "config.yaml"),
+		filepath.Join(dir, "base.yaml"),
+		filepath.Join(dir, "routes", "*.yaml"),
+		filepath.Join(dir, "routes", "a.yaml"),
+	}
+	if !slices.Equal(cfg.Sources(), expected) {
+		t.Errorf("expected sources %v, got %v", expected, cfg.Sources())
+	}
+}
+
+func TestLoadFromYAMLIncludeCycle(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": "include: [a.yaml]\n",
+		"a.yaml":      "include: [b.yaml]\n",
+		"b.yaml":      "include: [a.yaml]\n",
+	})
+
+	_, err := LoadFromYAML(filepath.Join(dir, "config.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestLoadFromYAMLIncludeMissingFile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.yaml": "include: [missing.yaml]\n",
+	})
+
+	if _, err := LoadFromYAML(filepath.Join(dir, "config.yaml")); err == nil {
+		t.Error("expected error for missing include")
+	}
+}
+
+func TestLoadFromJSONInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"config.json": `{"include": ["routes.json"], "routes": [{"name": "main", "backend_id": "web"}]}`,
+		"routes.json": `{"routes": [{"name": "api", "backend_id": "web"}]}`,
+	})
+
+	cfg, err := LoadFromJSON(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(cfg.Routes) != 2 {
+		t.Errorf("expected 2 routes, got %d", len(cfg.Routes))
+	}
+}
@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/surukanti/reverse-proxy/internal/proxy"
+)
+
+// Metrics collects Prometheus metrics from proxy events. It keeps its own
+// registry so several proxies, or tests, don't collide on the default one.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	upstreamTime    *prometheus.HistogramVec
+	upstreamErrors  *prometheus.CounterVec
+	cacheHits       prometheus.Counter
+	cacheMisses     prometheus.Counter
+	rateLimited     prometheus.Counter
+	backendHealthy  *prometheus.GaugeVec
+}
+
+// New creates a metrics registry with all proxy metrics registered
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_requests_total",
+			Help: "Requests served, by route and response status.",
+		}, []string{"route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "proxy_request_duration_seconds",
+			Help:    "Time spent serving requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		upstreamTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "proxy_upstream_duration_seconds",
+			Help:    "Time spent on upstream requests, including retries, by backend.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"backend"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "proxy_upstream_errors_total",
+			Help: "Failed upstream requests, by backend and kind.",
+		}, []string{"backend", "kind"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "proxy_cache_hits_total",
+			Help: "Requests served from the response cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "proxy_cache_misses_total",
+			Help: "Requests not found in the response cache.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "proxy_rate_limited_total",
+			Help: "Requests rejected by the rate limiter.",
+		}),
+		backendHealthy: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "proxy_backend_healthy",
+			Help: "Whether a backend is healthy (1) or not (0), set when its state changes.",
+		}, []string{"backend"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.upstreamTime,
+		m.upstreamErrors,
+		m.cacheHits,
+		m.cacheMisses,
+		m.rateLimited,
+		m.backendHealthy,
+	)
+	return m
+}
+
+// Observe subscribes to the proxy's events
+func (m *Metrics) Observe(p *proxy.Proxy) {
+	p.On("request_completed", func(e proxy.Event) {
+		m.requests.WithLabelValues(e.Route, strconv.Itoa(e.Status)).Inc()
+		m.requestDuration.WithLabelValues(e.Route).Observe(e.Duration.Seconds())
+		if e.Server != nil {
+			m.upstreamTime.WithLabelValues(serverLabel(e)).Observe(e.UpstreamDuration.Seconds())
+		}
+	})
+	p.On("proxy_error", func(e proxy.Event) {
+		m.upstreamErrors.WithLabelValues(serverLabel(e), "error").Inc()
+	})
+	p.On("proxy_timeout", func(e proxy.Event) {
+		m.upstreamErrors.WithLabelValues(serverLabel(e), "timeout").Inc()
+	})
+	p.On("cache_hit", func(proxy.Event) {
+		m.cacheHits.Inc()
+	})
+	p.On("cache_miss", func(proxy.Event) {
+		m.cacheMisses.Inc()
+	})
+	p.On("rate_limit_exceeded", func(proxy.Event) {
+		m.rateLimited.Inc()
+	})
+	p.On("backend_state_changed", func(e proxy.Event) {
+		healthy := 0.0
+		if e.Healthy {
+			healthy = 1
+		}
+		m.backendHealthy.WithLabelValues(serverLabel(e)).Set(healthy)
+	})
+}
+
+// Gatherer returns the registry backing these metrics
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func serverLabel(e proxy.Event) string {
+	if e.Server == nil || e.Server.URL == nil {
+		return ""
+	}
+	return e.Server.URL.String()
+}
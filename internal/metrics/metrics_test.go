@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/proxy"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+// eventually polls cond, since proxy events are delivered asynchronously
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newObservedProxy(t *testing.T, handler http.HandlerFunc) (*proxy.Proxy, *Metrics, *backend.Pool) {
+	mockBackend := httptest.NewServer(handler)
+	t.Cleanup(mockBackend.Close)
+
+	p := proxy.NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	m := New()
+	m.Observe(p)
+	return p, m, pool
+}
+
+func serve(p *proxy.Proxy, path string) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+	p.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	p, m, _ := newObservedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < 3; i++ {
+		serve(p, "/api/users")
+	}
+	serve(p, "/missing")
+
+	eventually(t, func() bool {
+		return testutil.ToFloat64(m.requests.WithLabelValues("api", "200")) == 3 &&
+			testutil.ToFloat64(m.requests.WithLabelValues("", "404")) == 1
+	}, "expected 3 requests on api and 1 unmatched")
+
+	eventually(t, func() bool {
+		return testutil.ToFloat64(m.cacheMisses) == 3
+	}, "expected a cache miss per forwarded request")
+
+	families, err := m.Gatherer().Gather()
+	if err != nil {
+		t.Fatalf("gather failed: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "proxy_request_duration_seconds" {
+			for _, metric := range family.GetMetric() {
+				if metric.GetHistogram().GetSampleCount() == 0 {
+					t.Error("expected duration samples to be recorded")
+				}
+			}
+			return
+		}
+	}
+	t.Error("expected a request duration histogram")
+}
+
+func TestMetricsUpstreamErrors(t *testing.T) {
+	p := proxy.NewProxy()
+	pool := backend.NewPool()
+	server, _ := pool.AddServer("http://127.0.0.1:1", 1)
+	p.AddRoute(&router.Route{Name: "down", PathPrefix: "/", Backend: pool})
+
+	m := New()
+	m.Observe(p)
+
+	if code := serve(p, "/"); code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", code)
+	}
+	eventually(t, func() bool {
+		return testutil.ToFloat64(m.upstreamErrors.WithLabelValues(server.URL.String(), "error")) == 1
+	}, "expected an upstream error to be counted")
+}
+
+func TestMetricsRateLimited(t *testing.T) {
+	p, m, _ := newObservedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	p.SetRateLimit(1, time.Minute)
+
+	serve(p, "/api")
+	if code := serve(p, "/api"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	eventually(t, func() bool {
+		return testutil.ToFloat64(m.rateLimited) == 1
+	}, "expected a rate limit rejection to be counted")
+}
+
+func TestMetricsBackendHealth(t *testing.T) {
+	p, m, pool := newObservedProxy(t, func(w http.ResponseWriter, r *http.Request) {})
+	server := pool.GetServer()
+
+	gauge := m.backendHealthy.WithLabelValues(server.URL.String())
+
+	p.NotifyBackendState(server, true)
+	eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == 1
+	}, "expected backend to be reported healthy")
+
+	p.NotifyBackendState(server, false)
+	eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == 0
+	}, "expected backend to be reported unhealthy")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	p, m, _ := newObservedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve(p, "/api")
+	eventually(t, func() bool {
+		return testutil.ToFloat64(m.requests.WithLabelValues("api", "200")) == 1
+	}, "expected the request to be counted")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/metrics", nil)
+	m.Handler().ServeHTTP(w, req)
+
+	if !strings.Contains(w.Body.String(), `proxy_requests_total{route="api",status="200"} 1`) {
+		t.Errorf("expected request counter in exposition, got:\n%s", w.Body.String())
+	}
+}
+
+func TestMetricsUpstreamDuration(t *testing.T) {
+	p, m, _ := newObservedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve(p, "/api/users")
+	serve(p, "/missing")
+
+	eventually(t, func() bool {
+		return testutil.CollectAndCount(m.upstreamTime) == 1
+	}, "expected upstream time for the backend only")
+}
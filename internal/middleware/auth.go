@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type JWTAuthMiddleware struct {
+	secret []byte
+	leeway time.Duration
+	now    func() time.Time
+}
+
+type JWTOption func(*JWTAuthMiddleware)
+
+// WithJWTLeeway allows for clock skew when checking exp and nbf
+func WithJWTLeeway(leeway time.Duration) JWTOption {
+	return func(jm *JWTAuthMiddleware) {
+		jm.leeway = leeway
+	}
+}
+
+// WithJWTClock replaces the clock used to check exp and nbf
+func WithJWTClock(now func() time.Time) JWTOption {
+	return func(jm *JWTAuthMiddleware) {
+		jm.now = now
+	}
+}
+
+// NewJWTAuthMiddleware verifies HS256-signed bearer tokens against secret
+func NewJWTAuthMiddleware(secret []byte, opts ...JWTOption) *JWTAuthMiddleware {
+	jm := &JWTAuthMiddleware{
+		secret: secret,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(jm)
+	}
+	return jm
+}
+
+func (jm *JWTAuthMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, ErrUnauthorized
+	}
+
+	if !jm.verify(token) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, ErrForbidden
+	}
+
+	return r, nil
+}
+
+func (jm *JWTAuthMiddleware) verify(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if !decodeSegment(parts[0], &header) || header.Alg != "HS256" {
+		return false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, jm.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return false
+	}
+
+	var claims struct {
+		Exp *float64 `json:"exp"`
+		Nbf *float64 `json:"nbf"`
+	}
+	if !decodeSegment(parts[1], &claims) {
+		return false
+	}
+
+	now := jm.now()
+	if claims.Exp != nil && now.After(unixTime(*claims.Exp).Add(jm.leeway)) {
+		return false
+	}
+	if claims.Nbf != nil && now.Before(unixTime(*claims.Nbf).Add(-jm.leeway)) {
+		return false
+	}
+
+	return true
+}
+
+func decodeSegment(segment string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+const basicAuthRealm = "Restricted"
+
+type BasicAuthMiddleware struct {
+	verify func(user, pass string) bool
+}
+
+// NewBasicAuthMiddleware checks HTTP Basic credentials with verify
+func NewBasicAuthMiddleware(verify func(user, pass string) bool) *BasicAuthMiddleware {
+	return &BasicAuthMiddleware{verify: verify}
+}
+
+func (bm *BasicAuthMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || !bm.verify(user, pass) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+basicAuthRealm+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, ErrUnauthorized
+	}
+
+	return r, nil
+}
+
+type clientIDKey struct{}
+
+// ClientID returns the client id stored by an authenticating middleware
+func ClientID(r *http.Request) string {
+	id, _ := r.Context().Value(clientIDKey{}).(string)
+	return id
+}
+
+type apiKey struct {
+	digest   [sha256.Size]byte
+	clientID string
+}
+
+type APIKeyMiddleware struct {
+	keys       []apiKey
+	header     string
+	queryParam string
+}
+
+type APIKeyOption func(*APIKeyMiddleware)
+
+// WithAPIKeyHeader sets the header carrying the key, X-API-Key by default
+func WithAPIKeyHeader(name string) APIKeyOption {
+	return func(am *APIKeyMiddleware) {
+		am.header = name
+	}
+}
+
+// WithAPIKeyQueryParam sets the query parameter carrying the key, api_key by
+// default; an empty name disables query lookup
+func WithAPIKeyQueryParam(name string) APIKeyOption {
+	return func(am *APIKeyMiddleware) {
+		am.queryParam = name
+	}
+}
+
+// NewAPIKeyMiddleware authenticates requests by API key, where keys maps
+// each key to a client id
+func NewAPIKeyMiddleware(keys map[string]string, opts ...APIKeyOption) *APIKeyMiddleware {
+	am := &APIKeyMiddleware{
+		header:     "X-API-Key",
+		queryParam: "api_key",
+	}
+	for key, clientID := range keys {
+		am.keys = append(am.keys, apiKey{digest: sha256.Sum256([]byte(key)), clientID: clientID})
+	}
+	for _, opt := range opts {
+		opt(am)
+	}
+	return am
+}
+
+func (am *APIKeyMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	key := r.Header.Get(am.header)
+	if key == "" && am.queryParam != "" {
+		key = r.URL.Query().Get(am.queryParam)
+	}
+	if key == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, ErrUnauthorized
+	}
+
+	clientID, ok := am.lookup(key)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, ErrForbidden
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), clientIDKey{}, clientID))
+	return r, nil
+}
+
+// lookup compares digests of every configured key so the time taken does
+// not depend on which key, or how much of it, matched
+func (am *APIKeyMiddleware) lookup(key string) (string, bool) {
+	digest := sha256.Sum256([]byte(key))
+
+	var clientID string
+	found := 0
+	for _, k := range am.keys {
+		match := subtle.ConstantTimeCompare(digest[:], k.digest[:])
+		if match == 1 {
+			clientID = k.clientID
+		}
+		found |= match
+	}
+	return clientID, found == 1
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signToken(secret []byte, header, claims string) string {
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func jwtRequest(token string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestJWTAuthMiddlewareValidToken(t *testing.T) {
+	secret := []byte("secret")
+	jm := NewJWTAuthMiddleware(secret)
+
+	token := signToken(secret, `{"alg":"HS256","typ":"JWT"}`, `{"sub":"user","exp":4102444800}`)
+	w := httptest.NewRecorder()
+	if _, err := jm.Handle(w, jwtRequest(token)); err != nil {
+		t.Fatalf("expected valid token to pass, got %v", err)
+	}
+}
+
+func TestJWTAuthMiddlewareExpiredToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	jm := NewJWTAuthMiddleware(secret, WithJWTClock(func() time.Time { return now }))
+
+	token := signToken(secret, `{"alg":"HS256"}`, `{"exp":1699999990}`)
+	w := httptest.NewRecorder()
+	if _, err := jm.Handle(w, jwtRequest(token)); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for expired token, got %v", err)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+
+	jm = NewJWTAuthMiddleware(secret, WithJWTClock(func() time.Time { return now }), WithJWTLeeway(time.Minute))
+	if _, err := jm.Handle(httptest.NewRecorder(), jwtRequest(token)); err != nil {
+		t.Errorf("expected leeway to accept recently expired token, got %v", err)
+	}
+}
+
+func TestJWTAuthMiddlewareNotBefore(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1700000000, 0)
+	jm := NewJWTAuthMiddleware(secret, WithJWTClock(func() time.Time { return now }))
+
+	token := signToken(secret, `{"alg":"HS256"}`, `{"nbf":1700000100}`)
+	if _, err := jm.Handle(httptest.NewRecorder(), jwtRequest(token)); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for token not yet valid, got %v", err)
+	}
+}
+
+func TestJWTAuthMiddlewareTamperedSignature(t *testing.T) {
+	secret := []byte("secret")
+	jm := NewJWTAuthMiddleware(secret)
+
+	token := signToken(secret, `{"alg":"HS256"}`, `{"sub":"user"}`)
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+
+	if _, err := jm.Handle(httptest.NewRecorder(), jwtRequest(strings.Join(parts, "."))); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for tampered payload, got %v", err)
+	}
+
+	other := signToken([]byte("other"), `{"alg":"HS256"}`, `{"sub":"user"}`)
+	if _, err := jm.Handle(httptest.NewRecorder(), jwtRequest(other)); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for wrong secret, got %v", err)
+	}
+}
+
+func TestJWTAuthMiddlewareRejectsOtherAlgorithms(t *testing.T) {
+	jm := NewJWTAuthMiddleware([]byte("secret"))
+
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`)) + "."
+	if _, err := jm.Handle(httptest.NewRecorder(), jwtRequest(token)); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden for alg none, got %v", err)
+	}
+}
+
+func TestJWTAuthMiddlewareMissingToken(t *testing.T) {
+	jm := NewJWTAuthMiddleware([]byte("secret"))
+
+	w := httptest.NewRecorder()
+	if _, err := jm.Handle(w, jwtRequest("")); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
+
+func basicAuthMiddleware() *BasicAuthMiddleware {
+	return NewBasicAuthMiddleware(func(user, pass string) bool {
+		return user == "admin" && pass == "p:ss"
+	})
+}
+
+func TestBasicAuthMiddlewareValid(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.SetBasicAuth("admin", "p:ss")
+
+	if _, err := basicAuthMiddleware().Handle(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("expected valid credentials to pass, got %v", err)
+	}
+}
+
+func TestBasicAuthMiddlewareWrongPassword(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.SetBasicAuth("admin", "wrong")
+
+	w := httptest.NewRecorder()
+	if _, err := basicAuthMiddleware().Handle(w, req); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+	if challenge := w.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Basic realm=") {
+		t.Errorf("expected Basic challenge, got %q", challenge)
+	}
+}
+
+func TestBasicAuthMiddlewareMalformedHeader(t *testing.T) {
+	for _, header := range []string{"", "Basic", "Basic !!!notbase64", "Basic " + base64.StdEncoding.EncodeToString([]byte("nocolon")), "Bearer token"} {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		w := httptest.NewRecorder()
+		if _, err := basicAuthMiddleware().Handle(w, req); err != ErrUnauthorized {
+			t.Errorf("header %q: expected ErrUnauthorized, got %v", header, err)
+		}
+		if w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("header %q: expected WWW-Authenticate challenge", header)
+		}
+	}
+}
+
+func apiKeyMiddleware() *APIKeyMiddleware {
+	return NewAPIKeyMiddleware(map[string]string{
+		"key-one": "client-1",
+		"key-two": "client-2",
+	})
+}
+
+func TestAPIKeyMiddlewareHeader(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-API-Key", "key-two")
+
+	next, err := apiKeyMiddleware().Handle(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("expected valid key to pass, got %v", err)
+	}
+	if id := ClientID(next); id != "client-2" {
+		t.Errorf("expected client-2, got %q", id)
+	}
+}
+
+func TestAPIKeyMiddlewareQueryParam(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/?api_key=key-one", nil)
+
+	next, err := apiKeyMiddleware().Handle(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("expected valid key to pass, got %v", err)
+	}
+	if id := ClientID(next); id != "client-1" {
+		t.Errorf("expected client-1, got %q", id)
+	}
+}
+
+func TestAPIKeyMiddlewareUnknownKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-API-Key", "key-three")
+
+	w := httptest.NewRecorder()
+	if _, err := apiKeyMiddleware().Handle(w, req); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if id := ClientID(req); id != "" {
+		t.Errorf("expected no client id, got %q", id)
+	}
+}
+
+func TestAPIKeyMiddlewareMissingKey(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	if _, err := apiKeyMiddleware().Handle(httptest.NewRecorder(), req); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestAPIKeyMiddlewareOptions(t *testing.T) {
+	am := NewAPIKeyMiddleware(map[string]string{"key": "client"}, WithAPIKeyHeader("X-Token"), WithAPIKeyQueryParam(""))
+
+	req, _ := http.NewRequest("GET", "http://localhost/?api_key=key", nil)
+	if _, err := am.Handle(httptest.NewRecorder(), req); err != ErrUnauthorized {
+		t.Errorf("expected query lookup to be disabled, got %v", err)
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Token", "key")
+	if _, err := am.Handle(httptest.NewRecorder(), req); err != nil {
+		t.Errorf("expected custom header to be used, got %v", err)
+	}
+}
@@ -6,4 +6,11 @@ var (
 	ErrUnauthorized = errors.New("unauthorized")
 	ErrForbidden    = errors.New("forbidden")
 	ErrRateLimited  = errors.New("rate limited")
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrAuthUnavailable means credentials could not be checked because the
+	// authorization server did not answer
+	ErrAuthUnavailable = errors.New("authorization server unavailable")
+	// ErrHandled stops the chain after a middleware has written the full
+	// response itself, as for a CORS preflight
+	ErrHandled = errors.New("response already written")
 )
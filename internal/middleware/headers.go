@@ -0,0 +1,59 @@
+package middleware
+
+import "net/http"
+
+// HeaderRules edits a set of headers: Remove runs first, then Set replaces
+// any existing values and Add appends to them
+type HeaderRules struct {
+	Add    map[string]string
+	Set    map[string]string
+	Remove []string
+}
+
+func (hr HeaderRules) empty() bool {
+	return len(hr.Add) == 0 && len(hr.Set) == 0 && len(hr.Remove) == 0
+}
+
+func (hr HeaderRules) apply(h http.Header) {
+	for _, key := range hr.Remove {
+		h.Del(key)
+	}
+	for key, value := range hr.Set {
+		h.Set(key, value)
+	}
+	for key, value := range hr.Add {
+		h.Add(key, value)
+	}
+}
+
+type HeaderRewriteOptions struct {
+	Request  HeaderRules
+	Response HeaderRules
+}
+
+type HeaderRewriteMiddleware struct {
+	request  HeaderRules
+	response HeaderRules
+}
+
+// NewHeaderRewriteMiddleware edits request headers before they are
+// forwarded and response headers before they are sent to the client
+func NewHeaderRewriteMiddleware(opts HeaderRewriteOptions) *HeaderRewriteMiddleware {
+	return &HeaderRewriteMiddleware{request: opts.Request, response: opts.Response}
+}
+
+func (hm *HeaderRewriteMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	hm.request.apply(r.Header)
+
+	if hm.response.empty() {
+		return r, nil
+	}
+	// Upstream headers are only known once the response is written, so
+	// defer to the proxy's response writer when there is one
+	if rw, ok := w.(*ResponseWriter); ok {
+		rw.OnWriteHeader(hm.response.apply)
+	} else {
+		hm.response.apply(w.Header())
+	}
+	return r, nil
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func headerRewriteMiddleware() *HeaderRewriteMiddleware {
+	rules := HeaderRules{
+		Add:    map[string]string{"X-Env": "prod"},
+		Set:    map[string]string{"X-Version": "2"},
+		Remove: []string{"Server"},
+	}
+	return NewHeaderRewriteMiddleware(HeaderRewriteOptions{Request: rules, Response: rules})
+}
+
+func TestHeaderRewriteMiddlewareRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Version", "1")
+	req.Header.Set("Server", "client")
+
+	if _, err := headerRewriteMiddleware().Handle(httptest.NewRecorder(), req); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := req.Header.Get("X-Env"); got != "prod" {
+		t.Errorf("expected X-Env to be added, got %q", got)
+	}
+	if got := req.Header.Values("X-Version"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("expected X-Version to be overwritten, got %v", got)
+	}
+	if _, ok := req.Header["Server"]; ok {
+		t.Error("expected Server to be removed")
+	}
+}
+
+func TestHeaderRewriteMiddlewareResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+
+	headerRewriteMiddleware().Handle(rw, req)
+
+	// Headers set after the middleware ran, as an upstream response would
+	rw.Header().Set("Server", "nginx")
+	rw.Header().Set("X-Version", "1")
+	rw.WriteHeader(http.StatusOK)
+
+	if got := rec.Header().Get("X-Env"); got != "prod" {
+		t.Errorf("expected X-Env to be added, got %q", got)
+	}
+	if got := rec.Header().Values("X-Version"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("expected X-Version to be overwritten, got %v", got)
+	}
+	if _, ok := rec.Header()["Server"]; ok {
+		t.Error("expected Server to be removed")
+	}
+}
+
+func TestHeaderRewriteMiddlewareAddAppends(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Env", "staging")
+
+	headerRewriteMiddleware().Handle(httptest.NewRecorder(), req)
+
+	if got := req.Header.Values("X-Env"); len(got) != 2 {
+		t.Errorf("expected Add to keep the existing value, got %v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IntrospectionResult is the part of an RFC 7662 introspection response
+// the middleware acts on
+type IntrospectionResult struct {
+	Active   bool
+	Expires  time.Time // zero when the server sent no exp
+	ClientID string
+}
+
+// IntrospectionCache stores introspection results keyed by a digest of the
+// token, so raw tokens are never kept
+type IntrospectionCache interface {
+	Get(key string) (IntrospectionResult, bool)
+	Set(key string, result IntrospectionResult, ttl time.Duration)
+}
+
+type IntrospectionMiddleware struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	cache        IntrospectionCache
+	ttl          time.Duration
+	now          func() time.Time
+}
+
+type IntrospectionOption func(*IntrospectionMiddleware)
+
+// WithIntrospectionClient sets the HTTP client used to call the endpoint
+func WithIntrospectionClient(client *http.Client) IntrospectionOption {
+	return func(im *IntrospectionMiddleware) {
+		im.client = client
+	}
+}
+
+// WithIntrospectionCache replaces the in-memory result cache
+func WithIntrospectionCache(cache IntrospectionCache) IntrospectionOption {
+	return func(im *IntrospectionMiddleware) {
+		im.cache = cache
+	}
+}
+
+// WithIntrospectionTTL sets how long a result is cached, 30s by default.
+// Active results are never cached past the token's exp.
+func WithIntrospectionTTL(ttl time.Duration) IntrospectionOption {
+	return func(im *IntrospectionMiddleware) {
+		im.ttl = ttl
+	}
+}
+
+// WithIntrospectionClock replaces the clock used to check exp
+func WithIntrospectionClock(now func() time.Time) IntrospectionOption {
+	return func(im *IntrospectionMiddleware) {
+		im.now = now
+	}
+}
+
+// NewIntrospectionMiddleware validates opaque bearer tokens against an
+// RFC 7662 introspection endpoint, authenticating with client credentials
+func NewIntrospectionMiddleware(endpoint string, clientID, clientSecret string, opts ...IntrospectionOption) *IntrospectionMiddleware {
+	im := &IntrospectionMiddleware{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		ttl:          30 * time.Second,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(im)
+	}
+	if im.cache == nil {
+		im.cache = newMemoryIntrospectionCache(im.now)
+	}
+	return im
+}
+
+func (im *IntrospectionMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, ErrUnauthorized
+	}
+
+	result, err := im.lookup(r.Context(), token)
+	if err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil, fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
+	}
+	if !result.Active || (!result.Expires.IsZero() && !im.now().Before(result.Expires)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, ErrForbidden
+	}
+
+	if result.ClientID != "" {
+		r = r.WithContext(context.WithValue(r.Context(), clientIDKey{}, result.ClientID))
+	}
+	return r, nil
+}
+
+// lookup returns the cached result for token, introspecting it on a miss
+func (im *IntrospectionMiddleware) lookup(ctx context.Context, token string) (IntrospectionResult, error) {
+	digest := sha256.Sum256([]byte(token))
+	key := string(digest[:])
+	if result, ok := im.cache.Get(key); ok {
+		return result, nil
+	}
+
+	result, err := im.introspect(ctx, token)
+	if err != nil {
+		return IntrospectionResult{}, err
+	}
+
+	ttl := im.ttl
+	if !result.Expires.IsZero() {
+		if untilExp := result.Expires.Sub(im.now()); untilExp < ttl {
+			ttl = untilExp
+		}
+	}
+	if ttl > 0 {
+		im.cache.Set(key, result, ttl)
+	}
+	return result, nil
+}
+
+func (im *IntrospectionMiddleware) introspect(ctx context.Context, token string) (IntrospectionResult, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, im.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IntrospectionResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(im.clientID), url.QueryEscape(im.clientSecret))
+
+	resp, err := im.client.Do(req)
+	if err != nil {
+		return IntrospectionResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return IntrospectionResult{}, fmt.Errorf("introspection endpoint returned %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Active   bool    `json:"active"`
+		Exp      float64 `json:"exp"`
+		ClientID string  `json:"client_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return IntrospectionResult{}, fmt.Errorf("decoding introspection response: %w", err)
+	}
+
+	result := IntrospectionResult{Active: body.Active, ClientID: body.ClientID}
+	if body.Exp > 0 {
+		result.Expires = unixTime(body.Exp)
+	}
+	return result, nil
+}
+
+// maxIntrospectionEntries bounds the default cache; once full, expired
+// entries are dropped and new results go uncached until there is room
+const maxIntrospectionEntries = 10000
+
+type introspectionEntry struct {
+	result  IntrospectionResult
+	expires time.Time
+}
+
+type memoryIntrospectionCache struct {
+	entries map[string]introspectionEntry
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func newMemoryIntrospectionCache(now func() time.Time) *memoryIntrospectionCache {
+	return &memoryIntrospectionCache{
+		entries: make(map[string]introspectionEntry),
+		now:     now,
+	}
+}
+
+func (c *memoryIntrospectionCache) Get(key string) (IntrospectionResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return IntrospectionResult{}, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return IntrospectionResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *memoryIntrospectionCache) Set(key string, result IntrospectionResult, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxIntrospectionEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxIntrospectionEntries {
+			return
+		}
+	}
+	c.entries[key] = introspectionEntry{result: result, expires: now.Add(ttl)}
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newIntrospectionServer(t *testing.T, calls *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "proxy" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostFormValue("token") {
+		case "good":
+			w.Write([]byte(`{"active":true,"client_id":"client-1","exp":4102444800}`))
+		case "expiring":
+			w.Write([]byte(`{"active":true,"exp":1700000010}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"active":false}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIntrospectionMiddlewareActiveToken(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret", WithIntrospectionClient(server.Client()))
+
+	req, err := im.Handle(httptest.NewRecorder(), jwtRequest("good"))
+	if err != nil {
+		t.Fatalf("expected active token to pass, got %v", err)
+	}
+	if id := ClientID(req); id != "client-1" {
+		t.Errorf("expected client id from introspection, got %q", id)
+	}
+}
+
+func TestIntrospectionMiddlewareInactiveToken(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret")
+
+	w := httptest.NewRecorder()
+	if _, err := im.Handle(w, jwtRequest("revoked")); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden, got %v", err)
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+
+	if _, err := im.Handle(httptest.NewRecorder(), jwtRequest("")); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized without a token, got %v", err)
+	}
+}
+
+func TestIntrospectionMiddlewareCachesResults(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	clock := newFakeClock()
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret",
+		WithIntrospectionClock(clock.Now), WithIntrospectionTTL(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		im.Handle(httptest.NewRecorder(), jwtRequest("good"))
+		im.Handle(httptest.NewRecorder(), jwtRequest("revoked"))
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected one introspection call per token, got %d", n)
+	}
+
+	clock.Advance(time.Minute)
+	im.Handle(httptest.NewRecorder(), jwtRequest("good"))
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected the token to be introspected again after the TTL, got %d calls", n)
+	}
+}
+
+func TestIntrospectionMiddlewareHonorsExp(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	clock := newFakeClock()
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret",
+		WithIntrospectionClock(clock.Now), WithIntrospectionTTL(time.Minute))
+
+	if _, err := im.Handle(httptest.NewRecorder(), jwtRequest("expiring")); err != nil {
+		t.Fatalf("expected token to pass before exp, got %v", err)
+	}
+
+	clock.Advance(10 * time.Second)
+	if _, err := im.Handle(httptest.NewRecorder(), jwtRequest("expiring")); err != ErrForbidden {
+		t.Errorf("expected ErrForbidden once exp has passed, got %v", err)
+	}
+}
+
+func TestIntrospectionMiddlewareEndpointFailure(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret")
+
+	w := httptest.NewRecorder()
+	if _, err := im.Handle(w, jwtRequest("broken")); !errors.Is(err, ErrAuthUnavailable) {
+		t.Errorf("expected ErrAuthUnavailable, got %v", err)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Code)
+	}
+
+	NewIntrospectionMiddleware(server.URL, "proxy", "wrong").Handle(httptest.NewRecorder(), jwtRequest("good"))
+	im.Handle(httptest.NewRecorder(), jwtRequest("broken"))
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("expected failures not to be cached, got %d calls", n)
+	}
+}
+
+type mapIntrospectionCache map[string]IntrospectionResult
+
+func (c mapIntrospectionCache) Get(key string) (IntrospectionResult, bool) {
+	result, ok := c[key]
+	return result, ok
+}
+
+func (c mapIntrospectionCache) Set(key string, result IntrospectionResult, ttl time.Duration) {
+	c[key] = result
+}
+
+func TestIntrospectionMiddlewareCustomCache(t *testing.T) {
+	var calls int32
+	server := newIntrospectionServer(t, &calls)
+	cache := mapIntrospectionCache{}
+	im := NewIntrospectionMiddleware(server.URL, "proxy", "s3cret", WithIntrospectionCache(cache))
+
+	im.Handle(httptest.NewRecorder(), jwtRequest("good"))
+	if len(cache) != 1 {
+		t.Fatalf("expected result to be stored in the injected cache, got %d entries", len(cache))
+	}
+	for key := range cache {
+		if key == "good" {
+			t.Error("expected the raw token not to be used as the cache key")
+		}
+	}
+}
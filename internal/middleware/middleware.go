@@ -1,14 +1,32 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-type Handler func(http.ResponseWriter, *http.Request) error
+// Handler runs before the request is proxied. It returns the request to
+// pass on, which carries any context values it adds, or an error to stop
+// the request; the request it was given is never changed in place.
+type Handler func(http.ResponseWriter, *http.Request) (*http.Request, error)
+
+// AfterHook runs once the response has been written
+type AfterHook func(r *http.Request, resp ResponseInfo)
+
+// ResponseInfo describes a completed response
+type ResponseInfo struct {
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+}
 
 type Chain struct {
 	handlers []Handler
+	after    []AfterHook
 }
 
 func NewChain() *Chain {
@@ -22,13 +40,31 @@ func (c *Chain) Add(handler Handler) *Chain {
 	return c
 }
 
-func (c *Chain) Execute(w http.ResponseWriter, r *http.Request) error {
+// Execute runs the handlers in order, each getting the request returned by
+// the one before. It returns the latest request, also when a handler fails,
+// so what earlier ones added (e.g. the request ID) is still available.
+func (c *Chain) Execute(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
 	for _, handler := range c.handlers {
-		if err := handler(w, r); err != nil {
-			return err
+		next, err := handler(w, r)
+		if err != nil {
+			return r, err
 		}
+		r = next
+	}
+	return r, nil
+}
+
+// After adds a hook run after the response is written
+func (c *Chain) After(hook AfterHook) *Chain {
+	c.after = append(c.after, hook)
+	return c
+}
+
+// ExecuteAfter runs the after hooks in the order they were added
+func (c *Chain) ExecuteAfter(r *http.Request, resp ResponseInfo) {
+	for _, hook := range c.after {
+		hook(r, resp)
 	}
-	return nil
 }
 
 type LoggingMiddleware struct {
@@ -39,25 +75,38 @@ func NewLoggingMiddleware(logger func(string)) *LoggingMiddleware {
 	return &LoggingMiddleware{logger: logger}
 }
 
-func (lm *LoggingMiddleware) Handle(w http.ResponseWriter, r *http.Request) error {
-	start := time.Now()
-	lm.logger(r.Method + " " + r.URL.Path + " from " + r.RemoteAddr)
+func (lm *LoggingMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	lm.logger(r.Method + " " + r.URL.Path + " from " + r.RemoteAddr + requestIDSuffix(r))
+	return r, nil
+}
 
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		duration := time.Since(start)
-		lm.logger("Request completed in " + duration.String())
-	}()
+// After logs the outcome of the request; register it as an after hook so
+// the duration covers the whole upstream round trip
+func (lm *LoggingMiddleware) After(r *http.Request, resp ResponseInfo) {
+	lm.logger(r.Method + " " + r.URL.Path + " completed with " + strconv.Itoa(resp.Status) +
+		requestIDSuffix(r) + " in " + resp.Duration.String())
+}
 
-	return nil
+func requestIDSuffix(r *http.Request) string {
+	if id := RequestID(r); id != "" {
+		return " [" + id + "]"
+	}
+	return ""
 }
 
 type RateLimiter struct {
 	maxRequests int
 	window      time.Duration
 	buckets     map[string]*bucket
+	now         func() time.Time
+	stopCh      chan struct{}
+	mu          sync.Mutex
 }
 
+// bucketIdleWindows is how many windows a bucket may go untouched before the
+// sweeper evicts it; by then it would be full again anyway
+const bucketIdleWindows = 2
+
 type bucket struct {
 	tokens    float64
 	lastReset time.Time
@@ -68,19 +117,105 @@ func NewRateLimiter(maxRequests int, window time.Duration) *RateLimiter {
 		maxRequests: maxRequests,
 		window:      window,
 		buckets:     make(map[string]*bucket),
+		now:         time.Now,
+		stopCh:      make(chan struct{}),
 	}
 }
 
+// SetClock replaces the limiter's time source
+func (rl *RateLimiter) SetClock(now func() time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.now = now
+}
+
+// SetLimit changes the limit without resetting the buckets: each keeps the
+// requests it has used, so raising the limit by n grants n more and
+// lowering it may leave a bucket empty
+func (rl *RateLimiter) SetLimit(maxRequests int, window time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delta := float64(maxRequests - rl.maxRequests)
+	rl.maxRequests = maxRequests
+	rl.window = window
+	for _, b := range rl.buckets {
+		b.tokens = minFloat(float64(maxRequests), b.tokens+delta)
+		if b.tokens < 0 {
+			b.tokens = 0
+		}
+	}
+}
+
+// Start periodically evicts buckets that have been idle for a while
+func (rl *RateLimiter) Start(ctx context.Context) {
+	rl.mu.Lock()
+	window := rl.window
+	rl.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rl.stopCh:
+				return
+			case <-ticker.C:
+				rl.sweep()
+			}
+		}
+	}()
+}
+
+// Stop stops the bucket sweeper
+func (rl *RateLimiter) Stop() {
+	close(rl.stopCh)
+}
+
+func (rl *RateLimiter) sweep() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cutoff := rl.now().Add(-bucketIdleWindows * rl.window)
+	for identifier, b := range rl.buckets {
+		if b.lastReset.Before(cutoff) {
+			delete(rl.buckets, identifier)
+		}
+	}
+}
+
+// RateLimitResult describes a rate limit decision
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, if denied
+}
+
 func (rl *RateLimiter) Handle(identifier string) bool {
-	now := time.Now()
+	return rl.Check(identifier).Allowed
+}
+
+// Check consumes a token for identifier if one is available and reports
+// the state of its bucket
+func (rl *RateLimiter) Check(identifier string) RateLimitResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
 	b, exists := rl.buckets[identifier]
 
 	if !exists {
-		rl.buckets[identifier] = &bucket{
+		b = &bucket{
 			tokens:    float64(rl.maxRequests),
 			lastReset: now,
 		}
-		return true
+		rl.buckets[identifier] = b
 	}
 
 	elapsed := now.Sub(b.lastReset).Seconds()
@@ -88,12 +223,17 @@ func (rl *RateLimiter) Handle(identifier string) bool {
 	b.tokens = minFloat(float64(rl.maxRequests), b.tokens+refillRate*elapsed)
 	b.lastReset = now
 
+	result := RateLimitResult{Limit: rl.maxRequests}
 	if b.tokens >= 1 {
 		b.tokens--
-		return true
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / refillRate * float64(time.Second))
 	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(rl.maxRequests) - b.tokens) / refillRate * float64(time.Second))
 
-	return false
+	return result
 }
 
 func minFloat(a, b float64) float64 {
@@ -113,52 +253,181 @@ func NewAuthMiddleware(validator func(string) bool) *AuthMiddleware {
 	}
 }
 
-func (am *AuthMiddleware) Handle(w http.ResponseWriter, r *http.Request) error {
+func (am *AuthMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
 	token := r.Header.Get("Authorization")
 	if token == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return ErrUnauthorized
+		return nil, ErrUnauthorized
 	}
 
 	if !am.validator(token) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
-		return ErrForbidden
+		return nil, ErrForbidden
 	}
 
-	return nil
+	return r, nil
+}
+
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool // only for origins listed explicitly, not "*"
+	MaxAge           int  // seconds browsers may cache a preflight, 0 to omit
+	ReflectRequest   bool // allow the method and headers a preflight asks for
 }
 
 type CORSMiddleware struct {
-	allowedOrigins []string
+	allowedOrigins   []string
+	allowedMethods   string
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           int
+	reflectRequest   bool
 }
 
 func NewCORSMiddleware(allowedOrigins []string) *CORSMiddleware {
+	return NewCORSMiddlewareWithOptions(CORSOptions{AllowedOrigins: allowedOrigins})
+}
+
+// NewCORSMiddlewareWithOptions builds a CORS middleware; empty method and
+// header lists fall back to the defaults used by NewCORSMiddleware
+func NewCORSMiddlewareWithOptions(opts CORSOptions) *CORSMiddleware {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	headers := opts.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "Authorization"}
+	}
+
 	return &CORSMiddleware{
-		allowedOrigins: allowedOrigins,
+		allowedOrigins:   opts.AllowedOrigins,
+		allowedMethods:   strings.Join(methods, ", "),
+		allowedHeaders:   strings.Join(headers, ", "),
+		exposedHeaders:   strings.Join(opts.ExposedHeaders, ", "),
+		allowCredentials: opts.AllowCredentials,
+		maxAge:           opts.MaxAge,
+		reflectRequest:   opts.ReflectRequest,
 	}
 }
 
-func (cm *CORSMiddleware) Handle(w http.ResponseWriter, r *http.Request) error {
+func (cm *CORSMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
 	origin := r.Header.Get("Origin")
-	allowed := false
+	allowed, listed := false, false
 
 	for _, o := range cm.allowedOrigins {
-		if o == "*" || o == origin {
-			allowed = true
+		if o == origin {
+			allowed, listed = true, true
 			break
 		}
+		if o == "*" {
+			allowed = true
+		}
 	}
 
-	if allowed {
+	// The origin is always echoed rather than sent as "*", which browsers
+	// reject for credentialed requests
+	if allowed && origin != "" {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Methods", cm.allowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", cm.allowedHeaders)
+		if cm.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", cm.exposedHeaders)
+		}
+		// Credentials for any origin would let every site act as the user
+		if cm.allowCredentials && listed {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	// A preflight is answered here and goes no further
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		if allowed && origin != "" {
+			if cm.reflectRequest {
+				w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+			}
+			if cm.maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cm.maxAge))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil, ErrHandled
 	}
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
-		return nil
+		return r, nil
 	}
 
-	return nil
+	return r, nil
+}
+
+type BodyLimitMiddleware struct {
+	maxBytes int64
+}
+
+func NewBodyLimitMiddleware(maxBytes int64) *BodyLimitMiddleware {
+	return &BodyLimitMiddleware{maxBytes: maxBytes}
+}
+
+func (bm *BodyLimitMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if r.ContentLength > bm.maxBytes {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return nil, ErrBodyTooLarge
+	}
+
+	// Bodies without a declared length fail once they read past the limit
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, bm.maxBytes)
+	}
+	return r, nil
+}
+
+type SecurityHeadersOptions struct {
+	StrictTransportSecurity string
+	ContentTypeOptions      string
+	FrameOptions            string
+	ReferrerPolicy          string
+	ContentSecurityPolicy   string // omitted when empty
+}
+
+type SecurityHeadersMiddleware struct {
+	headers map[string]string
+}
+
+// NewSecurityHeadersMiddleware sets hardening headers on every response;
+// empty options other than ContentSecurityPolicy use sensible defaults
+func NewSecurityHeadersMiddleware(opts SecurityHeadersOptions) *SecurityHeadersMiddleware {
+	headers := map[string]string{
+		"Strict-Transport-Security": orDefault(opts.StrictTransportSecurity, "max-age=31536000; includeSubDomains"),
+		"X-Content-Type-Options":    orDefault(opts.ContentTypeOptions, "nosniff"),
+		"X-Frame-Options":           orDefault(opts.FrameOptions, "DENY"),
+		"Referrer-Policy":           orDefault(opts.ReferrerPolicy, "strict-origin-when-cross-origin"),
+	}
+	if opts.ContentSecurityPolicy != "" {
+		headers["Content-Security-Policy"] = opts.ContentSecurityPolicy
+	}
+	return &SecurityHeadersMiddleware{headers: headers}
+}
+
+func (sm *SecurityHeadersMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	for key, value := range sm.headers {
+		w.Header().Set(key, value)
+	}
+	return r, nil
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
 }
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter allows at most maxRequests per identifier within
+// any window-long span, keeping a log of recent request times so there is
+// no burst at window boundaries.
+type SlidingWindowLimiter struct {
+	maxRequests int
+	window      time.Duration
+	logs        map[string][]time.Time
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+func NewSlidingWindowLimiter(maxRequests int, window time.Duration) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		maxRequests: maxRequests,
+		window:      window,
+		logs:        make(map[string][]time.Time),
+		now:         time.Now,
+	}
+}
+
+// SetClock replaces the limiter's time source
+func (sl *SlidingWindowLimiter) SetClock(now func() time.Time) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.now = now
+}
+
+func (sl *SlidingWindowLimiter) Handle(identifier string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	now := sl.now()
+	log := sl.prune(identifier, now)
+	if len(log) >= sl.maxRequests {
+		return false
+	}
+
+	sl.logs[identifier] = append(log, now)
+	return true
+}
+
+// prune drops requests that have left the window and returns what remains
+func (sl *SlidingWindowLimiter) prune(identifier string, now time.Time) []time.Time {
+	log := sl.logs[identifier]
+	cutoff := now.Add(-sl.window)
+
+	i := 0
+	for i < len(log) && !log[i].After(cutoff) {
+		i++
+	}
+	if i == len(log) {
+		delete(sl.logs, identifier)
+		return nil
+	}
+
+	log = log[i:]
+	sl.logs[identifier] = log
+	return log
+}
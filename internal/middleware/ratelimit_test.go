@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func TestSlidingWindowLimiterEnforcesLimit(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewSlidingWindowLimiter(3, time.Second)
+	sl.SetClock(clock.Now)
+
+	for i := 0; i < 3; i++ {
+		if !sl.Handle("client1") {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+	if sl.Handle("client1") {
+		t.Fatal("expected fourth request to be denied")
+	}
+	if !sl.Handle("client2") {
+		t.Fatal("expected other client to be allowed")
+	}
+}
+
+func TestSlidingWindowLimiterWindowEdge(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewSlidingWindowLimiter(2, time.Second)
+	sl.SetClock(clock.Now)
+
+	// t=0: first request
+	if !sl.Handle("client") {
+		t.Fatal("expected request at t=0 to be allowed")
+	}
+
+	// t=600ms: second request fills the window
+	clock.Advance(600 * time.Millisecond)
+	if !sl.Handle("client") {
+		t.Fatal("expected request at t=600ms to be allowed")
+	}
+
+	// t=999ms: both requests are still within the last second
+	clock.Advance(399 * time.Millisecond)
+	if sl.Handle("client") {
+		t.Fatal("expected request at t=999ms to be denied")
+	}
+
+	// t=1000ms: the t=0 request has left the window
+	clock.Advance(time.Millisecond)
+	if !sl.Handle("client") {
+		t.Fatal("expected request at t=1000ms to be allowed")
+	}
+
+	// t=1500ms: t=600ms and t=1000ms are still in the window
+	clock.Advance(500 * time.Millisecond)
+	if sl.Handle("client") {
+		t.Fatal("expected request at t=1500ms to be denied")
+	}
+
+	// t=1600ms: the t=600ms request has left the window
+	clock.Advance(100 * time.Millisecond)
+	if !sl.Handle("client") {
+		t.Fatal("expected request at t=1600ms to be allowed")
+	}
+}
+
+func TestSlidingWindowLimiterNoBoundaryBurst(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewSlidingWindowLimiter(5, time.Second)
+	sl.SetClock(clock.Now)
+
+	// Use the whole quota at the end of one window...
+	clock.Advance(900 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		sl.Handle("client")
+	}
+
+	// ...and the start of the next fixed window must not reset it
+	clock.Advance(200 * time.Millisecond)
+	if sl.Handle("client") {
+		t.Fatal("expected no burst across the window boundary")
+	}
+}
+
+func TestSlidingWindowLimiterPrunesLog(t *testing.T) {
+	clock := newFakeClock()
+	sl := NewSlidingWindowLimiter(3, time.Second)
+	sl.SetClock(clock.Now)
+
+	for i := 0; i < 3; i++ {
+		sl.Handle("client")
+	}
+	clock.Advance(2 * time.Second)
+
+	if !sl.Handle("client") {
+		t.Fatal("expected client to be allowed after the window")
+	}
+	if n := len(sl.logs["client"]); n != 1 {
+		t.Errorf("expected expired entries to be pruned, got %d", n)
+	}
+}
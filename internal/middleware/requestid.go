@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds incoming ids so clients can't bloat logs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the id stored by the request ID middleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+type RequestIDMiddleware struct {
+	trustIncoming bool
+}
+
+type RequestIDOption func(*RequestIDMiddleware)
+
+// WithTrustIncomingRequestID controls whether a well-formed X-Request-ID
+// sent by the client is reused; it is by default
+func WithTrustIncomingRequestID(trust bool) RequestIDOption {
+	return func(rm *RequestIDMiddleware) {
+		rm.trustIncoming = trust
+	}
+}
+
+func NewRequestIDMiddleware(opts ...RequestIDOption) *RequestIDMiddleware {
+	rm := &RequestIDMiddleware{trustIncoming: true}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	return rm
+}
+
+func (rm *RequestIDMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	id := r.Header.Get(RequestIDHeader)
+	if !rm.trustIncoming || !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	w.Header().Set(RequestIDHeader, id)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+	return r, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short ids made of letters, digits, '-', '_' and '.'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
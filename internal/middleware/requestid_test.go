@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRequestIDMiddlewareGenerates(t *testing.T) {
+	rm := NewRequestIDMiddleware()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req, err := rm.Handle(w, req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	id := RequestID(req)
+	if len(id) != 32 {
+		t.Fatalf("expected a 32 character id, got %q", id)
+	}
+	if w.Header().Get(RequestIDHeader) != id {
+		t.Errorf("expected response header %q, got %q", id, w.Header().Get(RequestIDHeader))
+	}
+
+	other, _ := http.NewRequest("GET", "http://localhost/", nil)
+	other, _ = rm.Handle(httptest.NewRecorder(), other)
+	if RequestID(other) == id {
+		t.Error("expected a new id for each request")
+	}
+}
+
+func TestRequestIDMiddlewareInChain(t *testing.T) {
+	var seen string
+	chain := NewChain()
+	chain.Add(NewRequestIDMiddleware().Handle)
+	chain.Add(func(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+		seen = RequestID(r)
+		return r, nil
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	next, err := chain.Execute(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if seen == "" || RequestID(next) != seen {
+		t.Errorf("expected the id to be passed down the chain, got %q and %q", seen, RequestID(next))
+	}
+	if RequestID(req) != "" {
+		t.Error("expected the original request to be left unchanged")
+	}
+}
+
+func TestRequestIDMiddlewareReusesIncoming(t *testing.T) {
+	rm := NewRequestIDMiddleware()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req, _ = rm.Handle(w, req)
+
+	if id := RequestID(req); id != "abc-123" {
+		t.Errorf("expected incoming id to be reused, got %q", id)
+	}
+	if w.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("expected response header to echo the id, got %q", w.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestRequestIDMiddlewareRejectsUntrusted(t *testing.T) {
+	for _, incoming := range []string{"bad id\nx", strings.Repeat("a", 200)} {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		req, _ = NewRequestIDMiddleware().Handle(httptest.NewRecorder(), req)
+
+		if id := RequestID(req); id == incoming || id == "" {
+			t.Errorf("expected malformed id %q to be replaced, got %q", incoming, id)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req, _ = NewRequestIDMiddleware(WithTrustIncomingRequestID(false)).Handle(httptest.NewRecorder(), req)
+	if RequestID(req) == "abc-123" {
+		t.Error("expected incoming id to be replaced when not trusted")
+	}
+}
+
+func TestLoggingMiddlewareIncludesRequestID(t *testing.T) {
+	var mu sync.Mutex
+	var logged []string
+	lm := NewLoggingMiddleware(func(msg string) {
+		mu.Lock()
+		logged = append(logged, msg)
+		mu.Unlock()
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req, _ = NewRequestIDMiddleware().Handle(httptest.NewRecorder(), req)
+	lm.Handle(httptest.NewRecorder(), req)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) == 0 || !strings.Contains(logged[0], "abc-123") {
+		t.Errorf("expected log line to include the request id, got %v", logged)
+	}
+}
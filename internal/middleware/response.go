@@ -0,0 +1,69 @@
+package middleware
+
+import "net/http"
+
+// ResponseWriter records the status and size of a response as it is
+// written
+type ResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	onHeader    []func(http.Header)
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// OnWriteHeader registers fn to run on the response headers just before
+// they are sent, after the upstream's headers have been copied in
+func (rw *ResponseWriter) OnWriteHeader(fn func(http.Header)) {
+	rw.onHeader = append(rw.onHeader, fn)
+}
+
+func (rw *ResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		for _, fn := range rw.onHeader {
+			fn(rw.Header())
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the status written, or 200 if nothing was written
+func (rw *ResponseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// BytesWritten returns the number of body bytes written
+func (rw *ResponseWriter) BytesWritten() int64 {
+	return rw.bytes
+}
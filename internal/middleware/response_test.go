@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseWriterRecordsStatusAndBytes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	rw.WriteHeader(http.StatusCreated)
+	rw.WriteHeader(http.StatusInternalServerError)
+	rw.Write([]byte("hello"))
+	rw.Write([]byte(" world"))
+
+	if rw.Status() != http.StatusCreated {
+		t.Errorf("expected first status 201, got %d", rw.Status())
+	}
+	if rw.BytesWritten() != 11 {
+		t.Errorf("expected 11 bytes, got %d", rw.BytesWritten())
+	}
+	if rec.Body.String() != "hello world" {
+		t.Errorf("expected body to pass through, got %q", rec.Body.String())
+	}
+}
+
+func TestResponseWriterImplicitOK(t *testing.T) {
+	rw := NewResponseWriter(httptest.NewRecorder())
+	if rw.Status() != http.StatusOK {
+		t.Errorf("expected 200 before anything is written, got %d", rw.Status())
+	}
+
+	rw.Write([]byte("x"))
+	if rw.Status() != http.StatusOK {
+		t.Errorf("expected implicit 200, got %d", rw.Status())
+	}
+}
+
+func TestResponseWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	rw.Write([]byte("data"))
+	rw.Flush()
+
+	if !rec.Flushed {
+		t.Error("expected flush to reach the underlying writer")
+	}
+}
+
+func TestResponseWriterOnWriteHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	calls := 0
+	rw.OnWriteHeader(func(h http.Header) {
+		calls++
+		h.Set("X-Hooked", "yes")
+	})
+
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.Write([]byte("a"))
+	rw.Write([]byte("b"))
+
+	if calls != 1 {
+		t.Errorf("expected hook to run once, ran %d times", calls)
+	}
+	if rec.Header().Get("X-Hooked") != "yes" {
+		t.Error("expected hook changes to be sent")
+	}
+}
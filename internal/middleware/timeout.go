@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TimeoutMiddleware attaches a deadline to the request context. The proxy
+// honors it when forwarding and answers 504 once it passes; a route timeout
+// only shortens it.
+type TimeoutMiddleware struct {
+	timeout time.Duration
+}
+
+func NewTimeoutMiddleware(d time.Duration) *TimeoutMiddleware {
+	return &TimeoutMiddleware{timeout: d}
+}
+
+func (tm *TimeoutMiddleware) Handle(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if tm.timeout <= 0 {
+		return r, nil
+	}
+
+	parent := r.Context()
+	ctx, cancel := context.WithTimeout(parent, tm.timeout)
+	// Handlers can't run code once the request finishes, so release the
+	// timer when the server cancels the original context instead
+	context.AfterFunc(parent, cancel)
+	r = r.WithContext(ctx)
+	return r, nil
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddlewareSetsDeadline(t *testing.T) {
+	tm := NewTimeoutMiddleware(50 * time.Millisecond)
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	before := time.Now()
+	req, err := tm.Handle(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		t.Fatal("expected request context to have a deadline")
+	}
+	if deadline.Before(before) || deadline.After(before.Add(time.Second)) {
+		t.Errorf("unexpected deadline %v", deadline)
+	}
+
+	select {
+	case <-req.Context().Done():
+		if req.Context().Err() != context.DeadlineExceeded {
+			t.Errorf("expected DeadlineExceeded, got %v", req.Context().Err())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the deadline to trip")
+	}
+}
+
+func TestTimeoutMiddlewareReleasedWithParent(t *testing.T) {
+	tm := NewTimeoutMiddleware(time.Hour)
+
+	parent, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(parent, "GET", "http://localhost/", nil)
+	req, _ = tm.Handle(httptest.NewRecorder(), req)
+
+	cancel()
+	select {
+	case <-req.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the timeout context to end with its parent")
+	}
+}
+
+func TestTimeoutMiddlewareDisabled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req, _ = NewTimeoutMiddleware(0).Handle(httptest.NewRecorder(), req)
+
+	if _, ok := req.Context().Deadline(); ok {
+		t.Error("expected no deadline for a zero timeout")
+	}
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"time"
+)
+
+// AccessLogEntry describes one completed request
+type AccessLogEntry struct {
+	Time             time.Time // when the request was received
+	Method           string
+	Path             string
+	Route            string // matched route name, empty if none matched
+	Status           int
+	Bytes            int64  // response body bytes written
+	Upstream         string // backend server URL, empty if no backend was used
+	Cache            string // X-Cache status if answered from the cache
+	RequestID        string
+	Duration         time.Duration
+	UpstreamDuration time.Duration // part of Duration spent on the backend, including retries
+}
+
+// SetAccessLog registers fn to be called once for every completed request.
+// It runs on the request's goroutine after the response has been written.
+func (p *Proxy) SetAccessLog(fn func(AccessLogEntry)) {
+	p.accessLog = fn
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestProxyAccessLog(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer mockBackend.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	p := NewProxy()
+	var entries []AccessLogEntry
+	p.SetAccessLog(func(e AccessLogEntry) { entries = append(entries, e) })
+
+	okPool := backend.NewPool()
+	okPool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "ok", PathPrefix: "/ok", Backend: okPool})
+	deadPool := backend.NewPool()
+	deadPool.AddServer(deadURL, 1)
+	p.AddRoute(&router.Route{Name: "dead", PathPrefix: "/dead", Backend: deadPool})
+
+	for _, path := range []string{"/ok/item", "/dead/item"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected one entry per request, got %d", len(entries))
+	}
+
+	ok := entries[0]
+	if ok.Method != "GET" || ok.Path != "/ok/item" || ok.Route != "ok" {
+		t.Errorf("unexpected request fields %+v", ok)
+	}
+	if ok.Status != http.StatusOK || ok.Bytes != 5 || ok.Upstream != mockBackend.URL {
+		t.Errorf("expected 200, 5 bytes from %s, got %d, %d bytes from %q", mockBackend.URL, ok.Status, ok.Bytes, ok.Upstream)
+	}
+	if ok.Duration <= 0 || ok.Time.IsZero() {
+		t.Errorf("expected timing to be recorded, got %v at %v", ok.Duration, ok.Time)
+	}
+
+	failed := entries[1]
+	if failed.Status != http.StatusBadGateway || failed.Upstream != deadURL {
+		t.Errorf("expected 502 from %s, got %d from %q", deadURL, failed.Status, failed.Upstream)
+	}
+	if failed.Bytes == 0 {
+		t.Error("expected the error body to be counted")
+	}
+}
+
+func TestProxyAccessLogCacheHit(t *testing.T) {
+	p, _ := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	var last AccessLogEntry
+	p.SetAccessLog(func(e AccessLogEntry) { last = e })
+
+	doRequest(p, "GET", "/items", nil)
+	doRequest(p, "GET", "/items", nil)
+
+	if last.Cache != "HIT" || last.Upstream != "" || last.Status != http.StatusOK || last.Bytes != 5 {
+		t.Errorf("expected a 5 byte cache hit with no upstream, got %+v", last)
+	}
+}
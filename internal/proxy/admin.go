@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+)
+
+// RouteStats counts the requests served by one route
+type RouteStats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"` // responses with a 5xx status
+}
+
+type routeCounter struct {
+	requests int64
+	errors   int64
+}
+
+// routeCounters tracks per-route request counts by route name
+type routeCounters struct {
+	counters sync.Map // route name -> *routeCounter
+}
+
+func (rc *routeCounters) record(route string, status int) {
+	c, ok := rc.counters.Load(route)
+	if !ok {
+		c, _ = rc.counters.LoadOrStore(route, &routeCounter{})
+	}
+	counter := c.(*routeCounter)
+	atomic.AddInt64(&counter.requests, 1)
+	if status >= http.StatusInternalServerError {
+		atomic.AddInt64(&counter.errors, 1)
+	}
+}
+
+func (rc *routeCounters) snapshot() map[string]RouteStats {
+	stats := make(map[string]RouteStats)
+	rc.counters.Range(func(name, c any) bool {
+		counter := c.(*routeCounter)
+		stats[name.(string)] = RouteStats{
+			Requests: atomic.LoadInt64(&counter.requests),
+			Errors:   atomic.LoadInt64(&counter.errors),
+		}
+		return true
+	})
+	return stats
+}
+
+// BackendStats reports the health and traffic of one backend server
+type BackendStats struct {
+	URL           string  `json:"url"`
+	Healthy       bool    `json:"healthy"`
+	Requests      int64   `json:"requests"`
+	Successes     int64   `json:"successes"`
+	Failures      int64   `json:"failures"`
+	LastLatencyMs float64 `json:"last_latency_ms"`
+}
+
+// BackendStats returns the stats of every server behind a route, once per
+// server URL
+func (p *Proxy) BackendStats() []BackendStats {
+	var stats []BackendStats
+	seen := make(map[string]bool)
+	for _, route := range p.router.ListRoutes() {
+		for _, pool := range []*backend.Pool{route.Backend, route.CanaryBackend} {
+			if pool == nil {
+				continue
+			}
+			for _, s := range pool.ServerStats() {
+				if seen[s.URL] {
+					continue
+				}
+				seen[s.URL] = true
+				stats = append(stats, BackendStats{
+					URL:           s.URL,
+					Healthy:       s.Healthy,
+					Requests:      s.Requests,
+					Successes:     s.Successes,
+					Failures:      s.Failures,
+					LastLatencyMs: float64(s.LastLatency.Microseconds()) / 1000,
+				})
+			}
+		}
+	}
+	return stats
+}
+
+// statsResponse is the JSON document served at /stats
+type statsResponse struct {
+	Requests      int64                 `json:"requests"`
+	Errors        int64                 `json:"errors"`
+	CacheSize     int                   `json:"cache_size"`
+	DroppedEvents int64                 `json:"dropped_events"`
+	Routes        map[string]RouteStats `json:"routes"`
+	Backends      []BackendStats        `json:"backends"`
+}
+
+// AdminHandler returns a handler for the proxy's admin endpoints. It should
+// be served on a separate, private listener:
+//
+//	GET /stats                                 request, error and cache counts, per route and backend
+//
+// With an admin authorizer set, every endpoint requires authorization and
+// routes and backend servers can be managed at runtime:
+//
+//	GET    /admin/routes                       list routes
+//	POST   /admin/routes                       add a route
+//	GET    /admin/routes/{name}                get a route
+//	PUT    /admin/routes/{name}                replace a route
+//	DELETE /admin/routes/{name}                remove a route
+//	GET    /admin/backends/{id}                backend status
+//	POST   /admin/backends/{id}/servers        add a server
+//	DELETE /admin/backends/{id}/servers        remove the server ?url=
+//	POST   /admin/backends/{id}/servers/drain  stop sending new requests to ?url=
+//	POST   /admin/backends/{id}/servers/health mark ?url= up or down with ?healthy=
+func (p *Proxy) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", p.serveStats)
+	if p.adminAuth != nil {
+		p.handleRoutes(mux)
+		p.handleBackends(mux)
+	}
+	return p.requireAdmin(mux)
+}
+
+func (p *Proxy) serveStats(w http.ResponseWriter, r *http.Request) {
+	stats := p.GetStats()
+	backends := p.BackendStats()
+	if backends == nil {
+		backends = []BackendStats{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statsResponse{
+		Requests:      stats.RequestCount,
+		Errors:        stats.ErrorCount,
+		CacheSize:     stats.CacheSize,
+		DroppedEvents: stats.DroppedEvents,
+		Routes:        stats.Routes,
+		Backends:      backends,
+	})
+}
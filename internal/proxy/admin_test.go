@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestAdminHandlerStats(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	for _, path := range []string{"/api/a", "/api/b", "/api/fail", "/missing"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	p.AdminHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+
+	var stats struct {
+		Requests  int64 `json:"requests"`
+		Errors    int64 `json:"errors"`
+		CacheSize *int  `json:"cache_size"`
+		Routes    map[string]struct {
+			Requests int64 `json:"requests"`
+			Errors   int64 `json:"errors"`
+		} `json:"routes"`
+		Backends []struct {
+			URL      string `json:"url"`
+			Healthy  bool   `json:"healthy"`
+			Requests int64  `json:"requests"`
+			Failures int64  `json:"failures"`
+		} `json:"backends"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if stats.Requests != 4 || stats.Errors != 1 {
+		t.Errorf("expected 4 requests and 1 error (no route), got %d and %d", stats.Requests, stats.Errors)
+	}
+	if stats.CacheSize == nil {
+		t.Error("expected cache_size in the response")
+	}
+	if api := stats.Routes["api"]; api.Requests != 3 || api.Errors != 1 {
+		t.Errorf("expected api route to count 3 requests and 1 error, got %+v", api)
+	}
+	if len(stats.Routes) != 1 {
+		t.Errorf("expected only matched routes, got %v", stats.Routes)
+	}
+	if len(stats.Backends) != 1 {
+		t.Fatalf("expected 1 backend, got %d", len(stats.Backends))
+	}
+	if b := stats.Backends[0]; b.URL != mockBackend.URL || !b.Healthy || b.Requests != 3 || b.Failures != 1 {
+		t.Errorf("unexpected backend stats %+v", b)
+	}
+}
+
+func TestAdminHandlerRejectsOtherMethods(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/stats", nil)
+	NewProxy().AdminHandler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+)
+
+// poolStatusResponse is the JSON document describing a backend's servers
+type poolStatusResponse struct {
+	ID       string                 `json:"id"`
+	Total    int                    `json:"total"`
+	Healthy  int                    `json:"healthy"`
+	Draining int                    `json:"draining"`
+	Servers  []serverStatusResponse `json:"servers"`
+}
+
+type serverStatusResponse struct {
+	URL      string `json:"url"`
+	Healthy  bool   `json:"healthy"`
+	Draining bool   `json:"draining"`
+	InFlight int64  `json:"in_flight"`
+}
+
+// addServerRequest is the body of POST /admin/backends/{id}/servers
+type addServerRequest struct {
+	URL    string `json:"url"`
+	Weight int32  `json:"weight"` // defaults to 1
+}
+
+// handleBackends adds the backend server management endpoints to mux.
+// Servers are addressed by URL, in the url query parameter, and every
+// endpoint answers with the backend's status. Changes apply to the pools
+// built by Reload and last until a Reload rebuilds the backend; health set
+// by hand is overridden by the next health check, if the backend has them.
+func (p *Proxy) handleBackends(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/backends/{id}", p.serveBackendStatus)
+	mux.HandleFunc("POST /admin/backends/{id}/servers", p.serveAddServer)
+	mux.HandleFunc("DELETE /admin/backends/{id}/servers", p.serveRemoveServer)
+	mux.HandleFunc("POST /admin/backends/{id}/servers/drain", p.serveDrainServer)
+	mux.HandleFunc("POST /admin/backends/{id}/servers/health", p.serveSetServerHealth)
+}
+
+// adminPool returns the pool of the backend named in the request path,
+// answering 404 Not Found if there is none
+func (p *Proxy) adminPool(w http.ResponseWriter, r *http.Request) (*backend.Pool, bool) {
+	p.reloadMu.Lock()
+	b, ok := p.backends[r.PathValue("id")]
+	p.reloadMu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("backend %s not found", r.PathValue("id")), http.StatusNotFound)
+		return nil, false
+	}
+	return b.pool, true
+}
+
+// adminServer returns the server of the request's backend named by the url
+// query parameter, answering 404 Not Found if there is none
+func (p *Proxy) adminServer(w http.ResponseWriter, r *http.Request) (*backend.Pool, *backend.Server, bool) {
+	pool, ok := p.adminPool(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	serverURL := r.URL.Query().Get("url")
+	server := pool.ServerByURL(serverURL)
+	if server == nil {
+		http.Error(w, fmt.Sprintf("server %s not found", serverURL), http.StatusNotFound)
+		return nil, nil, false
+	}
+	return pool, server, true
+}
+
+func (p *Proxy) serveBackendStatus(w http.ResponseWriter, r *http.Request) {
+	if pool, ok := p.adminPool(w, r); ok {
+		writePoolStatus(w, r, http.StatusOK, pool)
+	}
+}
+
+func (p *Proxy) serveAddServer(w http.ResponseWriter, r *http.Request) {
+	pool, ok := p.adminPool(w, r)
+	if !ok {
+		return
+	}
+	var req addServerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid server: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, fmt.Sprintf("invalid server url %q", req.URL), http.StatusBadRequest)
+		return
+	}
+	if req.Weight == 0 {
+		req.Weight = 1
+	} else if req.Weight < 0 {
+		http.Error(w, fmt.Sprintf("invalid weight %d", req.Weight), http.StatusBadRequest)
+		return
+	}
+
+	// Serialize with other changes so a URL can't be added twice
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	if pool.ServerByURL(req.URL) != nil {
+		http.Error(w, fmt.Sprintf("server %s already exists", req.URL), http.StatusConflict)
+		return
+	}
+	if _, err := pool.AddServer(req.URL, req.Weight); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writePoolStatus(w, r, http.StatusCreated, pool)
+}
+
+func (p *Proxy) serveRemoveServer(w http.ResponseWriter, r *http.Request) {
+	pool, ok := p.adminPool(w, r)
+	if !ok {
+		return
+	}
+	serverURL := r.URL.Query().Get("url")
+	if !pool.RemoveServer(serverURL) {
+		http.Error(w, fmt.Sprintf("server %s not found", serverURL), http.StatusNotFound)
+		return
+	}
+	writePoolStatus(w, r, http.StatusOK, pool)
+}
+
+func (p *Proxy) serveDrainServer(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := p.adminServer(w, r)
+	if !ok {
+		return
+	}
+	pool.DrainServer(server)
+	writePoolStatus(w, r, http.StatusOK, pool)
+}
+
+func (p *Proxy) serveSetServerHealth(w http.ResponseWriter, r *http.Request) {
+	pool, server, ok := p.adminServer(w, r)
+	if !ok {
+		return
+	}
+	healthy, err := strconv.ParseBool(r.URL.Query().Get("healthy"))
+	if err != nil {
+		http.Error(w, "healthy must be true or false", http.StatusBadRequest)
+		return
+	}
+	if pool.GetServerHealth(server) != healthy {
+		pool.SetServerHealth(server, healthy)
+		p.NotifyBackendState(server, healthy)
+	}
+	writePoolStatus(w, r, http.StatusOK, pool)
+}
+
+func writePoolStatus(w http.ResponseWriter, r *http.Request, code int, pool *backend.Pool) {
+	status := pool.Status()
+	resp := poolStatusResponse{
+		ID:       r.PathValue("id"),
+		Total:    status.Total,
+		Healthy:  status.Healthy,
+		Draining: status.Draining,
+		Servers:  make([]serverStatusResponse, len(status.Servers)),
+	}
+	for i, s := range status.Servers {
+		resp.Servers[i] = serverStatusResponse{
+			URL:      s.URL,
+			Healthy:  s.Healthy,
+			Draining: s.Draining,
+			InFlight: s.InFlight,
+		}
+	}
+	writeJSON(w, code, resp)
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func decodePoolStatus(t *testing.T, body []byte) poolStatusResponse {
+	t.Helper()
+	var status poolStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return status
+}
+
+func TestAdminBackendServers(t *testing.T) {
+	p, h := newAdminProxy(t)
+	urlC := newNamedBackend(t, "c")
+	servers := "/admin/backends/a/servers"
+	query := "?url=" + url.QueryEscape(urlC)
+
+	w := adminRequest(h, "POST", servers, `{"url":"`+urlC+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	status := decodePoolStatus(t, w.Body.Bytes())
+	if status.ID != "a" || status.Total != 2 || status.Healthy != 2 || status.Servers[1].URL != urlC {
+		t.Fatalf("expected the added server in the backend's status, got %+v", status)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		_, body := serveBody(p, "/a")
+		seen[body] = true
+	}
+	if !seen["c"] {
+		t.Error("expected the added server to get requests")
+	}
+
+	w = adminRequest(h, "POST", servers+"/drain"+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := decodePoolStatus(t, w.Body.Bytes()); status.Draining != 1 || !status.Servers[1].Draining {
+		t.Errorf("expected the server to be draining, got %+v", status)
+	}
+	for i := 0; i < 10; i++ {
+		if _, body := serveBody(p, "/a"); body == "c" {
+			t.Fatal("expected a draining server to get no new requests")
+		}
+	}
+
+	w = adminRequest(h, "DELETE", servers+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := decodePoolStatus(t, w.Body.Bytes()); status.Total != 1 {
+		t.Errorf("expected the server to be removed, got %+v", status)
+	}
+	if w := adminRequest(h, "DELETE", servers+query, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 removing it again, got %d", w.Code)
+	}
+}
+
+func TestAdminBackendServerHealth(t *testing.T) {
+	p, h := newAdminProxy(t)
+	pool := p.backends["a"].pool
+	serverURL := pool.Servers[0].URL.String()
+
+	var changes []bool
+	p.OnSync("backend_state_changed", func(e Event) { changes = append(changes, e.Healthy) })
+
+	w := adminRequest(h, "POST", "/admin/backends/a/servers/health?healthy=false&url="+url.QueryEscape(serverURL), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := decodePoolStatus(t, w.Body.Bytes()); status.Healthy != 0 {
+		t.Errorf("expected the server to be marked down, got %+v", status)
+	}
+	if code, _ := serveBody(p, "/a"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected no server to be available, got %d", code)
+	}
+	if len(changes) != 1 || changes[0] {
+		t.Errorf("expected a backend_state_changed event, got %v", changes)
+	}
+}
+
+func TestAdminBackendValidation(t *testing.T) {
+	_, h := newAdminProxy(t)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/admin/backends/missing", "", http.StatusNotFound},
+		{"POST", "/admin/backends/missing/servers", `{"url":"http://localhost:1"}`, http.StatusNotFound},
+		{"POST", "/admin/backends/a/servers", `{"url":"localhost:1"}`, http.StatusBadRequest},
+		{"POST", "/admin/backends/a/servers", `{"url":"http://localhost:1","weight":-1}`, http.StatusBadRequest},
+		{"POST", "/admin/backends/a/servers", `not json`, http.StatusBadRequest},
+		{"POST", "/admin/backends/a/servers/drain?url=http://unknown", "", http.StatusNotFound},
+		{"POST", "/admin/backends/a/servers/health?url=http://unknown&healthy=true", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := adminRequest(h, tt.method, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.body, tt.want, w.Code)
+		}
+	}
+
+	w := adminRequest(h, "GET", "/admin/backends/a", "")
+	status := decodePoolStatus(t, w.Body.Bytes())
+	if w := adminRequest(h, "POST", "/admin/backends/a/servers", `{"url":"`+status.Servers[0].URL+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 adding a server twice, got %d", w.Code)
+	}
+	if w := adminRequest(h, "POST", "/admin/backends/a/servers/health?url="+url.QueryEscape(status.Servers[0].URL)+"&healthy=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid health value, got %d", w.Code)
+	}
+}
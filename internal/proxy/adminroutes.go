@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/config"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+// SetAdminAuthorizer protects the admin endpoints: requests that don't
+// pass authorize get 401 Unauthorized. It also enables the route and
+// backend management endpoints, which are never served without an
+// authorizer. It must be called before AdminHandler.
+func (p *Proxy) SetAdminAuthorizer(authorize func(*http.Request) bool) {
+	p.adminAuth = authorize
+}
+
+// requireAdmin wraps the admin handler with the admin authorizer, if set
+func (p *Proxy) requireAdmin(next http.Handler) http.Handler {
+	if p.adminAuth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.adminAuth(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRoutes adds the route management endpoints to mux. Routes are
+// read and written in the config file's JSON form and resolve their
+// backends by ID among those built by Reload. Changes last until the next
+// Reload, which replaces every route with the config's.
+func (p *Proxy) handleRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/routes", p.serveListRoutes)
+	mux.HandleFunc("POST /admin/routes", p.serveCreateRoute)
+	mux.HandleFunc("GET /admin/routes/{name}", p.serveGetRoute)
+	mux.HandleFunc("PUT /admin/routes/{name}", p.serveUpdateRoute)
+	mux.HandleFunc("DELETE /admin/routes/{name}", p.serveDeleteRoute)
+}
+
+func (p *Proxy) serveListRoutes(w http.ResponseWriter, r *http.Request) {
+	p.reloadMu.Lock()
+	routes := p.router.ListRoutes()
+	configs := make([]config.RouteConfig, len(routes))
+	for i, route := range routes {
+		configs[i] = p.routeConfig(route)
+	}
+	p.reloadMu.Unlock()
+
+	writeJSON(w, http.StatusOK, configs)
+}
+
+func (p *Proxy) serveGetRoute(w http.ResponseWriter, r *http.Request) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	route := p.router.GetRoute(r.PathValue("name"))
+	if route == nil {
+		http.Error(w, router.ErrRouteNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.routeConfig(route))
+}
+
+func (p *Proxy) serveCreateRoute(w http.ResponseWriter, r *http.Request) {
+	var cfg config.RouteConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "invalid route: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.Name == "" {
+		http.Error(w, "route name is required", http.StatusBadRequest)
+		return
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	if p.router.GetRoute(cfg.Name) != nil {
+		http.Error(w, fmt.Sprintf("route %s already exists", cfg.Name), http.StatusConflict)
+		return
+	}
+	route, err := newRoute(cfg, p.backends)
+	if err == nil {
+		err = p.router.AddRoute(route)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("route %s: %v", cfg.Name, err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p.routeConfig(route))
+}
+
+func (p *Proxy) serveUpdateRoute(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var cfg config.RouteConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "invalid route: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.Name == "" {
+		cfg.Name = name
+	} else if cfg.Name != name {
+		http.Error(w, "route name can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	route, err := newRoute(cfg, p.backends)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("route %s: %v", name, err), http.StatusBadRequest)
+		return
+	}
+	if err := p.router.UpdateRoute(route); errors.Is(err, router.ErrRouteNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("route %s: %v", name, err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, p.routeConfig(route))
+}
+
+func (p *Proxy) serveDeleteRoute(w http.ResponseWriter, r *http.Request) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	if !p.router.RemoveRoute(r.PathValue("name")) {
+		http.Error(w, router.ErrRouteNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeConfig is the inverse of newRoute. Must be called with reloadMu held.
+func (p *Proxy) routeConfig(route *router.Route) config.RouteConfig {
+	cfg := config.RouteConfig{
+		Name:            route.Name,
+		Pattern:         route.Pattern,
+		Glob:            route.Glob,
+		PathPrefix:      route.PathPrefix,
+		StripPrefix:     route.StripPrefix,
+		Host:            route.Host,
+		Subdomain:       route.Subdomain,
+		Headers:         route.Headers,
+		HeadersNot:      route.HeadersNot,
+		HeadersRegex:    route.HeadersRegex,
+		Query:           route.Query,
+		Methods:         route.Methods,
+		BackendID:       p.backendID(route.Backend),
+		CanaryBackendID: p.backendID(route.CanaryBackend),
+		CanaryPercent:   route.CanaryPercent,
+		Priority:        route.Priority,
+		CaseInsensitive: route.CaseInsensitive,
+		PreserveHost:    route.PreserveHost,
+		RequestHeaders:  route.RequestHeaders,
+	}
+	if route.Disabled {
+		enabled := false
+		cfg.Enabled = &enabled
+	}
+	if route.Timeout > 0 {
+		cfg.Timeout = route.Timeout.String()
+	}
+	if rw := route.Rewrite; rw != nil {
+		cfg.Rewrite = &config.RewriteConfig{
+			StripPrefix: rw.StripPrefix,
+			Pattern:     rw.Pattern,
+			Replacement: rw.Replacement,
+		}
+	}
+	if rd := route.Redirect; rd != nil {
+		cfg.Redirect = &config.RedirectConfig{Target: rd.Target, Status: rd.Status}
+	}
+	return cfg
+}
+
+// backendID returns the ID of the backend built by Reload for pool, or ""
+// if the pool wasn't built from config. Must be called with reloadMu held.
+func (p *Proxy) backendID(pool *backend.Pool) string {
+	if pool == nil {
+		return ""
+	}
+	for id, b := range p.backends {
+		if b.pool == pool {
+			return id
+		}
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
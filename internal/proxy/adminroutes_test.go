@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/config"
+)
+
+const adminToken = "secret"
+
+// newAdminProxy returns a proxy loaded with backends a and b, a route to a,
+// and its admin handler authorizing adminToken
+func newAdminProxy(t *testing.T) (*Proxy, http.Handler) {
+	t.Helper()
+	p := NewProxy()
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{
+			{ID: "a", Servers: []string{newNamedBackend(t, "a")}},
+			{ID: "b", Servers: []string{newNamedBackend(t, "b")}},
+		},
+		Routes: []config.RouteConfig{{Name: "a", PathPrefix: "/a", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	p.SetAdminAuthorizer(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer "+adminToken
+	})
+	return p, p.AdminHandler()
+}
+
+func adminRequest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func decodeRoutes(t *testing.T, body io.Reader) []config.RouteConfig {
+	t.Helper()
+	var routes []config.RouteConfig
+	if err := json.NewDecoder(body).Decode(&routes); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return routes
+}
+
+func TestAdminRoutesCRUD(t *testing.T) {
+	p, h := newAdminProxy(t)
+
+	w := adminRequest(h, "POST", "/admin/routes",
+		`{"name":"b","pattern":"^/b/[0-9]+$","methods":["GET"],"priority":5,"backend_id":"b"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if code, body := serveBody(p, "/b/42"); code != http.StatusOK || body != "b" {
+		t.Errorf("expected the created route to serve, got %d %q", code, body)
+	}
+
+	w = adminRequest(h, "GET", "/admin/routes", "")
+	routes := decodeRoutes(t, w.Body)
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+	created := routes[0]
+	if created.Name != "b" || created.Pattern != "^/b/[0-9]+$" || created.BackendID != "b" ||
+		created.Priority != 5 || len(created.Methods) != 1 || created.Methods[0] != "GET" {
+		t.Errorf("expected the created route first by priority with its config, got %+v", created)
+	}
+	if routes[1].Name != "a" || routes[1].BackendID != "a" {
+		t.Errorf("expected the configured route with its backend ID, got %+v", routes[1])
+	}
+
+	w = adminRequest(h, "PUT", "/admin/routes/b", `{"path_prefix":"/b","backend_id":"a"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if code, body := serveBody(p, "/b/anything"); code != http.StatusOK || body != "a" {
+		t.Errorf("expected the updated route to serve, got %d %q", code, body)
+	}
+	w = adminRequest(h, "GET", "/admin/routes/b", "")
+	var updated config.RouteConfig
+	json.NewDecoder(w.Body).Decode(&updated)
+	if updated.PathPrefix != "/b" || updated.Pattern != "" || updated.BackendID != "a" {
+		t.Errorf("expected the route to be replaced, got %+v", updated)
+	}
+
+	if w := adminRequest(h, "DELETE", "/admin/routes/b", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if code, _ := serveBody(p, "/b/anything"); code != http.StatusNotFound {
+		t.Errorf("expected the deleted route to stop serving, got %d", code)
+	}
+	if w := adminRequest(h, "GET", "/admin/routes/b", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted route, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutesValidation(t *testing.T) {
+	_, h := newAdminProxy(t)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/admin/routes", `{"name":"c","path_prefix":"/c","backend_id":"missing"}`, http.StatusBadRequest},
+		{"POST", "/admin/routes", `{"name":"c","pattern":"([","backend_id":"a"}`, http.StatusBadRequest},
+		{"POST", "/admin/routes", `{"path_prefix":"/c","backend_id":"a"}`, http.StatusBadRequest},
+		{"POST", "/admin/routes", `{"name":"a","path_prefix":"/c","backend_id":"a"}`, http.StatusConflict},
+		{"POST", "/admin/routes", `not json`, http.StatusBadRequest},
+		{"PUT", "/admin/routes/missing", `{"path_prefix":"/c","backend_id":"a"}`, http.StatusNotFound},
+		{"PUT", "/admin/routes/a", `{"name":"renamed","path_prefix":"/c","backend_id":"a"}`, http.StatusBadRequest},
+		{"PUT", "/admin/routes/a", `{"path_prefix":"/c","backend_id":"missing"}`, http.StatusBadRequest},
+		{"DELETE", "/admin/routes/missing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := adminRequest(h, tt.method, tt.path, tt.body); w.Code != tt.want {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.body, tt.want, w.Code)
+		}
+	}
+
+	w := adminRequest(h, "GET", "/admin/routes", "")
+	if routes := decodeRoutes(t, w.Body); len(routes) != 1 || routes[0].PathPrefix != "/a" {
+		t.Errorf("expected rejected changes to leave the routes alone, got %+v", routes)
+	}
+}
+
+func TestAdminRoutesRequireAuthorization(t *testing.T) {
+	_, h := newAdminProxy(t)
+
+	for _, path := range []string{"/admin/routes", "/stats"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401 for %s without a token, got %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	NewProxy().AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/admin/routes", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected route management to be off without an authorizer, got %d", w.Code)
+	}
+}
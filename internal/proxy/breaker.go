@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/advanced"
+	"github.com/surukanti/reverse-proxy/internal/backend"
+)
+
+// BreakerPolicy configures a circuit breaker for every backend server.
+// Upstream connection errors and 5xx responses count as failures. A server
+// whose breaker is open is skipped; if every server of the pool is, the
+// request gets 503 Service Unavailable, which can be customized with
+// SetErrorPage. Every transition emits a circuit_breaker_state_changed
+// event.
+type BreakerPolicy struct {
+	FailureThreshold int64         // consecutive failures that open a breaker; 0 disables breakers
+	SuccessThreshold int64         // successful trial requests that close it again
+	Timeout          time.Duration // how long a breaker stays open before trial requests
+	HalfOpenRequests int64         // trial requests let through at once; 0 = advanced.DefaultHalfOpenTrials
+}
+
+// breakerRegistry holds the circuit breaker of each server, by URL
+type breakerRegistry struct {
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	breakers map[string]*advanced.CircuitBreaker
+}
+
+// SetBreakerPolicy sets the per-server circuit breaker policy. Changing it
+// resets every breaker.
+func (p *Proxy) SetBreakerPolicy(policy BreakerPolicy) {
+	p.breakers.mu.Lock()
+	defer p.breakers.mu.Unlock()
+	if policy == p.breakers.policy {
+		return
+	}
+	p.breakers.policy = policy
+	p.breakers.breakers = nil
+}
+
+// breaker returns the circuit breaker for server, or nil if breakers are
+// disabled
+func (p *Proxy) breaker(server *backend.Server) *advanced.CircuitBreaker {
+	p.breakers.mu.Lock()
+	defer p.breakers.mu.Unlock()
+
+	policy := p.breakers.policy
+	if policy.FailureThreshold <= 0 {
+		return nil
+	}
+	key := server.URL.String()
+	cb, ok := p.breakers.breakers[key]
+	if !ok {
+		successes := policy.SuccessThreshold
+		if successes <= 0 {
+			successes = 1
+		}
+		cb = advanced.NewCircuitBreaker(policy.FailureThreshold, successes, policy.Timeout)
+		cb.SetHalfOpenTrials(policy.HalfOpenRequests)
+		cb.OnStateChange = func(from, to string) {
+			p.emitEvent(Event{
+				Type:          "circuit_breaker_state_changed",
+				Timestamp:     time.Now(),
+				Server:        server,
+				PreviousState: from,
+				State:         to,
+			})
+		}
+		if p.breakers.breakers == nil {
+			p.breakers.breakers = make(map[string]*advanced.CircuitBreaker)
+		}
+		p.breakers.breakers[key] = cb
+	}
+	return cb
+}
+
+// breakerOpen reports whether server's circuit breaker is rejecting
+// requests
+func (p *Proxy) breakerOpen(server *backend.Server) bool {
+	cb := p.breaker(server)
+	return cb != nil && cb.Open()
+}
+
+// acquireServer reserves a request slot on server, or if its breaker is
+// open or it is at its MaxConns on another available server of the pool
+// whose breaker isn't. It returns the server the slot is held on, which
+// must be released; nil if there is none.
+func (p *Proxy) acquireServer(pool *backend.Pool, server *backend.Server) *backend.Server {
+	if !p.breakerOpen(server) && server.TryAcquire() {
+		return server
+	}
+	for i := pool.Status().Healthy; i > 0; i-- {
+		candidate := pool.GetServer()
+		if candidate == nil {
+			return nil
+		}
+		if !p.breakerOpen(candidate) && candidate.TryAcquire() {
+			return candidate
+		}
+	}
+	return nil
+}
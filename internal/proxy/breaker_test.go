@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/config"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+// newCountingBackend starts a backend answering with status and counting
+// the requests it receives
+func newCountingBackend(t *testing.T, status int) (*httptest.Server, *int64) {
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestBreakerSkipsFailingServer(t *testing.T) {
+	failing, failingHits := newCountingBackend(t, http.StatusInternalServerError)
+	healthy, healthyHits := newCountingBackend(t, http.StatusOK)
+
+	p := NewProxy()
+	p.SetBreakerPolicy(BreakerPolicy{FailureThreshold: 3, Timeout: time.Minute})
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	pool.AddServer(healthy.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+
+	for i := 0; i < 20; i++ {
+		doRequest(p, "GET", "/", nil)
+	}
+
+	if hits := atomic.LoadInt64(failingHits); hits != 3 {
+		t.Errorf("expected the failing server to get 3 requests before its breaker opened, got %d", hits)
+	}
+	if hits := atomic.LoadInt64(healthyHits); hits != 17 {
+		t.Errorf("expected the healthy server to take the remaining 17 requests, got %d", hits)
+	}
+	if state := p.breaker(pool.Servers[0]).GetState(); state != "open" {
+		t.Errorf("expected the failing server's breaker to be open, got %s", state)
+	}
+	if state := p.breaker(pool.Servers[1]).GetState(); state != "closed" {
+		t.Errorf("expected the healthy server's breaker to stay closed, got %s", state)
+	}
+}
+
+func TestBreakerOpenReturnsServiceUnavailable(t *testing.T) {
+	failing, hits := newCountingBackend(t, http.StatusBadGateway)
+
+	p := NewProxy()
+	p.SetBreakerPolicy(BreakerPolicy{FailureThreshold: 2, Timeout: time.Minute})
+	p.SetErrorPage(http.StatusServiceUnavailable, []byte("down for repairs"), "text/plain")
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+
+	var circuitOpen int64
+	p.OnSync("circuit_open", func(Event) { atomic.AddInt64(&circuitOpen, 1) })
+
+	for i := 0; i < 2; i++ {
+		if w := doRequest(p, "GET", "/", nil); w.Code != http.StatusBadGateway {
+			t.Fatalf("expected the backend's 502 while the breaker is closed, got %d", w.Code)
+		}
+	}
+	w := doRequest(p, "GET", "/", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "down for repairs" {
+		t.Errorf("expected the 503 fallback page once the breaker opened, got %d %q", w.Code, w.Body.String())
+	}
+	if n := atomic.LoadInt64(hits); n != 2 {
+		t.Errorf("expected no requests to reach the server once its breaker opened, got %d", n)
+	}
+	if n := atomic.LoadInt64(&circuitOpen); n != 1 {
+		t.Errorf("expected a circuit_open event, got %d", n)
+	}
+}
+
+func TestBreakerClosesAfterTimeout(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	p := NewProxy()
+	p.SetBreakerPolicy(BreakerPolicy{FailureThreshold: 1, SuccessThreshold: 1, Timeout: 50 * time.Millisecond})
+	pool := backend.NewPool()
+	pool.AddServer(server.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+
+	doRequest(p, "GET", "/", nil)
+	if w := doRequest(p, "GET", "/", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected the breaker to be open, got %d", w.Code)
+	}
+
+	fail.Store(false)
+	time.Sleep(100 * time.Millisecond)
+	if w := doRequest(p, "GET", "/", nil); w.Code != http.StatusOK {
+		t.Errorf("expected a trial request after the timeout, got %d", w.Code)
+	}
+	if state := p.breaker(pool.Servers[0]).GetState(); state != "closed" {
+		t.Errorf("expected the breaker to close after a successful trial, got %s", state)
+	}
+}
+
+func TestBreakerStateChangeEvents(t *testing.T) {
+	failing, _ := newCountingBackend(t, http.StatusInternalServerError)
+
+	p := NewProxy()
+	p.SetBreakerPolicy(BreakerPolicy{FailureThreshold: 1, Timeout: time.Minute})
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+
+	var events []Event
+	p.OnSync("circuit_breaker_state_changed", func(e Event) { events = append(events, e) })
+
+	doRequest(p, "GET", "/", nil)
+	if len(events) != 1 {
+		t.Fatalf("expected one circuit_breaker_state_changed event, got %d", len(events))
+	}
+	e := events[0]
+	if e.PreviousState != "closed" || e.State != "open" || e.Server != pool.Servers[0] {
+		t.Errorf("expected closed -> open for %s, got %s -> %s for %v", failing.URL, e.PreviousState, e.State, e.Server)
+	}
+}
+
+func TestBreakerDisabledByDefault(t *testing.T) {
+	failing, hits := newCountingBackend(t, http.StatusInternalServerError)
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+
+	for i := 0; i < 10; i++ {
+		doRequest(p, "GET", "/", nil)
+	}
+	if n := atomic.LoadInt64(hits); n != 10 {
+		t.Errorf("expected every request to reach the server, got %d", n)
+	}
+}
+
+func TestReloadCircuitBreakerPolicy(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	cfg := &config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+		Policies: config.PoliciesConfig{
+			CircuitBreaker: config.CircuitBreakerPolicy{Enabled: true, FailureThreshold: 5, SuccessThreshold: 2, Timeout: "10s"},
+		},
+	}
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	want := BreakerPolicy{FailureThreshold: 5, SuccessThreshold: 2, Timeout: 10 * time.Second}
+	if p.breakers.policy != want {
+		t.Errorf("expected breaker policy %+v, got %+v", want, p.breakers.policy)
+	}
+
+	cfg.Policies.CircuitBreaker.Timeout = "soon"
+	if err := p.Reload(cfg); err == nil {
+		t.Error("expected error for an invalid timeout")
+	}
+}
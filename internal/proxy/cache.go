@@ -0,0 +1,575 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/middleware"
+	"github.com/surukanti/reverse-proxy/internal/router"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxCacheBodySize is the largest response body that will be cached;
+// bigger responses are streamed through without being stored
+const maxCacheBodySize = 1 << 20
+
+// defaultCacheMaxEntries bounds the cache when the policy sets no limit
+const defaultCacheMaxEntries = 10000
+
+// CachePolicy controls which upstream responses are cached. Once either
+// limit is reached the least recently used entries are evicted.
+type CachePolicy struct {
+	TTL        time.Duration // how long responses stay fresh; 0 disables caching
+	Methods    []string      // cacheable methods; defaults to GET and HEAD
+	MaxEntries int           // defaults to 10000
+	MaxBytes   int64         // total body size; 0 means no byte limit
+
+	// StaleWhileRevalidate is how long an expired response may still be
+	// served while it is refreshed in the background, unless the response's
+	// stale-while-revalidate directive says otherwise. Past that, a response
+	// with an ETag is revalidated before it is served.
+	StaleWhileRevalidate time.Duration
+}
+
+var defaultCacheMethods = []string{http.MethodGet, http.MethodHead}
+
+// cacheableStatuses are the statuses RFC 9110 allows caching by default
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// SetCachePolicy enables caching of upstream responses
+func (p *Proxy) SetCachePolicy(policy CachePolicy) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	p.cachePolicy = policy
+	p.evictCache()
+}
+
+// StartCacheReaper removes expired cache entries every interval until ctx
+// is done. Without it they are only dropped when looked up or evicted.
+func (p *Proxy) StartCacheReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.reapCache()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// PurgeCache removes cached responses whose request path and query match
+// pattern and returns how many were removed. A pattern containing *, ? or
+// [ is matched as a glob (see path.Match); anything else as a prefix.
+func (p *Proxy) PurgeCache(pattern string) int {
+	glob := strings.ContainsAny(pattern, "*?[")
+
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	purged := 0
+	for _, entry := range p.cache {
+		var match bool
+		if glob {
+			match, _ = path.Match(pattern, entry.uri)
+		} else {
+			match = strings.HasPrefix(entry.uri, pattern)
+		}
+		if match {
+			p.removeCacheEntry(entry)
+			purged++
+		}
+	}
+	return purged
+}
+
+// MethodPurge is the request method used to invalidate cached responses
+const MethodPurge = "PURGE"
+
+// SetPurgeAuthorizer enables PURGE requests: a PURGE whose request passes
+// authorize removes cached responses under its path, or matching it when the
+// path is a glob. Without an authorizer PURGE is proxied like any method.
+func (p *Proxy) SetPurgeAuthorizer(authorize func(*http.Request) bool) {
+	p.purgeAuth = authorize
+}
+
+func (p *Proxy) servePurge(w http.ResponseWriter, r *http.Request) {
+	if !p.purgeAuth(r) {
+		p.writeError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	purged := p.PurgeCache(r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{\"purged\":%d}\n", purged)
+}
+
+// reapCache removes every expired entry that can't be revalidated and
+// returns how many were removed
+func (p *Proxy) reapCache() int {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	now := p.now()
+	removed := 0
+	for _, entry := range p.cache {
+		if !entry.keptAt(now) {
+			p.removeCacheEntry(entry)
+			removed++
+		}
+	}
+	return removed
+}
+
+// cacheVary records the Vary request headers of the latest response cached
+// for a base key, and how many entries share that base key
+type cacheVary struct {
+	headers []string
+	entries int
+}
+
+// storeCacheEntry adds or replaces an entry as the most recently used and
+// evicts down to the policy limits; cacheMu must be held
+func (p *Proxy) storeCacheEntry(entry *CacheEntry) {
+	if old, ok := p.cache[entry.key]; ok {
+		p.removeCacheEntry(old)
+	}
+	entry.size = int64(len(entry.Body))
+	entry.elem = p.cacheOrder.PushFront(entry)
+	p.cache[entry.key] = entry
+	p.cacheBytes += entry.size
+
+	v, ok := p.cacheVary[entry.base]
+	if !ok {
+		v = &cacheVary{}
+		p.cacheVary[entry.base] = v
+	}
+	v.headers = entry.vary
+	v.entries++
+
+	p.evictCache()
+}
+
+// removeCacheEntry drops an entry; cacheMu must be held
+func (p *Proxy) removeCacheEntry(entry *CacheEntry) {
+	delete(p.cache, entry.key)
+	if entry.elem != nil {
+		p.cacheOrder.Remove(entry.elem)
+	}
+	p.cacheBytes -= entry.size
+
+	if v, ok := p.cacheVary[entry.base]; ok {
+		if v.entries--; v.entries <= 0 {
+			delete(p.cacheVary, entry.base)
+		}
+	}
+}
+
+// evictCache removes least recently used entries until the cache is within
+// the policy limits; cacheMu must be held
+func (p *Proxy) evictCache() {
+	maxEntries := p.cachePolicy.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	maxBytes := p.cachePolicy.MaxBytes
+
+	for p.cacheOrder.Len() > maxEntries || (maxBytes > 0 && p.cacheBytes > maxBytes) {
+		p.removeCacheEntry(p.cacheOrder.Back().Value.(*CacheEntry))
+	}
+}
+
+// cacheStaleWindow returns the policy's default stale-while-revalidate
+func (p *Proxy) cacheStaleWindow() time.Duration {
+	p.cacheMu.RLock()
+	defer p.cacheMu.RUnlock()
+	return p.cachePolicy.StaleWhileRevalidate
+}
+
+// cacheTTL returns how long a response to r may be cached, or 0 if the
+// request isn't cacheable. Authorized requests are never cached since the
+// cache key doesn't include the credentials.
+func (p *Proxy) cacheTTL(r *http.Request) time.Duration {
+	p.cacheMu.RLock()
+	policy := p.cachePolicy
+	p.cacheMu.RUnlock()
+
+	if policy.TTL <= 0 || r.Header.Get("Authorization") != "" {
+		return 0
+	}
+	if _, ok := parseCacheControl(r.Header)["no-store"]; ok {
+		return 0
+	}
+	methods := policy.Methods
+	if len(methods) == 0 {
+		methods = defaultCacheMethods
+	}
+	for _, m := range methods {
+		if m == r.Method {
+			return policy.TTL
+		}
+	}
+	return 0
+}
+
+// cachedEntry returns the entry for key if it is fresh, may be served
+// stale or can be revalidated by its ETag, see CacheEntry.Fresh. A client
+// sending Cache-Control: no-cache always goes to the backend.
+func (p *Proxy) cachedEntry(r *http.Request, key string) (*CacheEntry, bool) {
+	if requestNoCache(r) {
+		return nil, false
+	}
+
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	entry, ok := p.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !entry.keptAt(p.now()) {
+		p.removeCacheEntry(entry)
+		return nil, false
+	}
+	p.cacheOrder.MoveToFront(entry.elem)
+	return entry, true
+}
+
+// Fresh reports whether the entry is within its TTL; past it, it is only
+// served while being revalidated
+func (e *CacheEntry) Fresh(now time.Time) bool {
+	return e.Expires.After(now)
+}
+
+func (e *CacheEntry) usableAt(now time.Time) bool {
+	return e.Expires.Add(e.stale).After(now)
+}
+
+// keptAt reports whether the entry is worth keeping: it is usable, or has
+// an ETag the backend can confirm it by instead of sending it again
+func (e *CacheEntry) keptAt(now time.Time) bool {
+	return e.usableAt(now) || e.Headers.Get("ETag") != ""
+}
+
+// claimRevalidation reports whether the caller should refresh a stale
+// entry, making sure only one refresh runs at a time
+func (p *Proxy) claimRevalidation(entry *CacheEntry) bool {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if entry.revalidating {
+		return false
+	}
+	entry.revalidating = true
+	return true
+}
+
+// revalidate refreshes a stale entry in the background, see refresh
+func (p *Proxy) revalidate(r *http.Request, route *router.Route, pool *backend.Pool, entry *CacheEntry) {
+	defer func() {
+		p.cacheMu.Lock()
+		entry.revalidating = false
+		p.cacheMu.Unlock()
+	}()
+	p.refresh(r, route, pool, entry)
+}
+
+// refresh replays r to the backend to refresh entry. A cacheable response
+// replaces the entry; if the entry has an ETag the backend may instead
+// answer 304 to extend its freshness.
+func (p *Proxy) refresh(r *http.Request, route *router.Route, pool *backend.Pool, entry *CacheEntry) {
+	server := pool.GetServer()
+	if server == nil {
+		return
+	}
+	if server = p.acquireServer(pool, server); server == nil {
+		return // no server has a slot left; a later request retries
+	}
+
+	req := refreshRequest(r, entry)
+	resp := &discardResponseWriter{header: http.Header{}}
+	p.forwardRequest(resp, req, route, pool, server, retryNone)
+	server.Release()
+	if resp.status == http.StatusNotModified {
+		p.refreshCacheEntry(entry, resp.header)
+	}
+
+	p.emitEvent(Event{
+		Type:      "cache_revalidated",
+		Timestamp: time.Now(),
+		Request:   req,
+		Server:    server,
+	})
+}
+
+// refreshRequest builds the request revalidating entry from r. It gets a
+// context of its own carrying only r's trace span: it must outlive r, and
+// without the server's context values a backend failing mid-body is an
+// error instead of an http.ErrAbortHandler panic, which nothing recovers
+// outside a server handler.
+func refreshRequest(r *http.Request, entry *CacheEntry) *http.Request {
+	ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(r.Context()))
+	req := r.Clone(ctx)
+	req.Body = http.NoBody
+	if id := middleware.RequestID(r); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := entry.Headers.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	return req
+}
+
+// notModifiedHeaders are updated on a cached entry from a 304 response
+var notModifiedHeaders = []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"}
+
+// refreshCacheEntry renews entry after the backend confirmed it is still
+// current with a 304, keeping its body. The entry is replaced rather than
+// changed since it may be being served.
+func (p *Proxy) refreshCacheEntry(entry *CacheEntry, header http.Header) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+
+	if p.cache[entry.key] != entry {
+		return
+	}
+	ttl, ok := responseTTL(header, p.cachePolicy.TTL)
+	if !ok || ttl <= 0 {
+		return
+	}
+
+	headers := entry.Headers.Clone()
+	for _, key := range notModifiedHeaders {
+		if values := header.Values(key); len(values) > 0 {
+			headers[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	p.storeCacheEntry(&CacheEntry{
+		Status:  entry.Status,
+		Headers: headers,
+		Body:    entry.Body,
+		Expires: p.now().Add(ttl),
+		key:     entry.key,
+		base:    entry.base,
+		vary:    entry.vary,
+		uri:     entry.uri,
+		stale:   responseStale(header, p.cachePolicy.StaleWhileRevalidate),
+	})
+}
+
+// discardResponseWriter keeps the status and headers of a response
+// nobody is waiting for and drops its body
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (d *discardResponseWriter) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+}
+
+// cacheRecorder copies an upstream response body as the proxy streams it
+// to the client, so the complete response can be cached afterwards
+type cacheRecorder struct {
+	io.ReadCloser
+	status   int
+	ttl      time.Duration
+	stale    time.Duration
+	header   http.Header
+	body     bytes.Buffer
+	complete bool
+	overflow bool
+}
+
+func (cr *cacheRecorder) Read(b []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(b)
+	if n > 0 && !cr.overflow {
+		if cr.body.Len()+n > maxCacheBodySize {
+			cr.overflow = true
+			cr.body = bytes.Buffer{}
+		} else {
+			cr.body.Write(b[:n])
+		}
+	}
+	if err == io.EOF {
+		cr.complete = true
+	}
+	return n, err
+}
+
+// recordForCache starts recording resp if it can be cached, for ttl and
+// then stale unless the response's Cache-Control says otherwise
+func recordForCache(resp *http.Response, ttl, stale time.Duration) *cacheRecorder {
+	if !cacheableStatuses[resp.StatusCode] || !sharedCacheable(resp.Header) {
+		return nil
+	}
+	ttl, ok := responseTTL(resp.Header, ttl)
+	if !ok {
+		return nil
+	}
+	cr := &cacheRecorder{
+		ReadCloser: resp.Body,
+		status:     resp.StatusCode,
+		ttl:        ttl,
+		stale:      responseStale(resp.Header, stale),
+		header:     resp.Header.Clone(),
+	}
+	resp.Body = cr
+	return cr
+}
+
+// store caches the recorded response once it has been fully relayed. A
+// body whose read failed, even before its first byte, is truncated.
+func (cr *cacheRecorder) store(p *Proxy, r *http.Request, route *router.Route, pool *backend.Pool) {
+	if cr == nil || cr.overflow {
+		return
+	}
+	if !cr.complete && !bodiless(r.Method, cr.status) {
+		return
+	}
+	p.cacheResponse(r, route, pool, cr.status, cr.header, cr.body.Bytes(), cr.ttl, cr.stale)
+}
+
+// bodiless reports whether a response has no body to read to EOF: the
+// proxy never reads one for HEAD or 204 and 304 responses
+func bodiless(method string, status int) bool {
+	return method == http.MethodHead || status == http.StatusNoContent || status == http.StatusNotModified
+}
+
+// sharedCacheable reports whether a response may be served to other
+// clients: one setting a cookie is meant for its client alone, and one that
+// varies on everything can never be reused
+func sharedCacheable(header http.Header) bool {
+	if len(header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// varyHeaders returns the request headers named by the response's Vary
+// header, canonicalized and sorted
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// responseTTL applies the response's Cache-Control to the default ttl.
+// s-maxage, meant for shared caches like this one, wins over max-age. It
+// reports false if the response must not be cached.
+func responseTTL(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	directives := parseCacheControl(header)
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[d]; ok {
+			return 0, false
+		}
+	}
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[d]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return ttl, true
+}
+
+// responseStale returns how long the response may be served stale while it
+// is revalidated, preferring its stale-while-revalidate directive
+func responseStale(header http.Header, stale time.Duration) time.Duration {
+	value, ok := parseCacheControl(header)["stale-while-revalidate"]
+	if !ok {
+		return stale
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// requestNoCache reports whether the client asked to bypass the cache
+func requestNoCache(r *http.Request) bool {
+	if _, ok := parseCacheControl(r.Header)["no-cache"]; ok {
+		return true
+	}
+	return r.Header.Get("Pragma") == "no-cache"
+}
+
+// etagMatches reports whether an If-None-Match value matches etag, using
+// the weak comparison RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl returns the Cache-Control directives, lowercased, with
+// their values unquoted
+func parseCacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
@@ -0,0 +1,731 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func newCachingProxy(t *testing.T, handler http.HandlerFunc) (*Proxy, *int32) {
+	var calls int32
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(mockBackend.Close)
+
+	p := NewProxy()
+	p.SetCachePolicy(CachePolicy{TTL: time.Minute})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+	return p, &calls
+}
+
+func doRequest(p *Proxy, method, path string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "http://localhost"+path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	p.ServeHTTP(w, req)
+	return w
+}
+
+func TestProxyCachesUpstreamResponse(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	})
+
+	first := doRequest(p, "GET", "/items", nil)
+	if first.Header().Get("X-Cache") == "HIT" {
+		t.Error("expected first request to miss the cache")
+	}
+
+	second := doRequest(p, "GET", "/items", nil)
+	if second.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected second request to be served from cache")
+	}
+	if second.Body.String() != "hello" || second.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("expected cached body and headers, got %q %q", second.Body.String(), second.Header().Get("Content-Type"))
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("expected backend to be called once, got %d", n)
+	}
+
+	doRequest(p, "GET", "/items?page=2", nil)
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected a different query to miss the cache, got %d calls", n)
+	}
+}
+
+func TestProxyCacheSkipsUncacheable(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	requests := []struct {
+		method string
+		path   string
+		header http.Header
+	}{
+		{"POST", "/items", nil},
+		{"GET", "/error", nil},
+		{"GET", "/private", http.Header{"Authorization": {"Bearer token"}}},
+	}
+	for _, req := range requests {
+		doRequest(p, req.method, req.path, req.header)
+		if w := doRequest(p, req.method, req.path, req.header); w.Header().Get("X-Cache") == "HIT" {
+			t.Errorf("%s %s: expected response not to be cached", req.method, req.path)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 6 {
+		t.Errorf("expected every request to reach the backend, got %d", n)
+	}
+}
+
+func TestProxyCacheSkipsLargeBodies(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", maxCacheBodySize+1)))
+	})
+
+	doRequest(p, "GET", "/large", nil)
+	w := doRequest(p, "GET", "/large", nil)
+	if w.Body.Len() != maxCacheBodySize+1 {
+		t.Errorf("expected full body to be relayed, got %d bytes", w.Body.Len())
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected large response not to be cached, got %d calls", n)
+	}
+}
+
+func TestProxyCacheSkipsBodyFailingBeforeFirstByte(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		// Promise a body, then drop the connection before sending any
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+
+	doRequest(p, "GET", "/items", nil)
+	w := doRequest(p, "GET", "/items", nil)
+	if w.Header().Get("X-Cache") == "HIT" {
+		t.Error("expected a failed body not to be cached as an empty response")
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestProxyCachesBodilessResponses(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	for _, req := range []struct{ method, path string }{{"HEAD", "/items"}, {"GET", "/empty"}} {
+		doRequest(p, req.method, req.path, nil)
+		if w := doRequest(p, req.method, req.path, nil); w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("expected %s %s to be cached", req.method, req.path)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestProxyCacheSharedAcrossServers(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte("hello"))
+	})
+	pool := backend.NewPool()
+	for i := 0; i < 2; i++ {
+		mockBackend := httptest.NewServer(handler)
+		defer mockBackend.Close()
+		pool.AddServer(mockBackend.URL, 1)
+	}
+	p := NewProxy()
+	p.SetCachePolicy(CachePolicy{TTL: time.Minute})
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+
+	doRequest(p, "GET", "/items", nil)
+	for i := 0; i < 3; i++ {
+		if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("request %d: expected a hit whichever server is next", i+2)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected 1 backend call, got %d", n)
+	}
+}
+
+func TestProxyCacheDisabledByDefault(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	p.SetCachePolicy(CachePolicy{})
+
+	doRequest(p, "GET", "/items", nil)
+	doRequest(p, "GET", "/items", nil)
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected no caching without a TTL, got %d calls", n)
+	}
+}
+
+func TestProxyCacheHonorsNoStore(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Write([]byte("ok"))
+	})
+
+	for _, cc := range []string{"no-store", "private,%20max-age=60", "max-age=0"} {
+		atomic.StoreInt32(calls, 0)
+		doRequest(p, "GET", "/items?cc="+cc, nil)
+		doRequest(p, "GET", "/items?cc="+cc, nil)
+		if n := atomic.LoadInt32(calls); n != 2 {
+			t.Errorf("Cache-Control %q: expected response never to be cached, got %d calls", cc, n)
+		}
+	}
+}
+
+func TestProxyCacheSkipsPerUserResponses(t *testing.T) {
+	headers := map[string]http.Header{
+		"/cookie":  {"Set-Cookie": {"session=abc123"}},
+		"/vary":    {"Vary": {"Accept-Encoding, *"}},
+		"/private": {"Cache-Control": {"private"}},
+	}
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range headers[r.URL.Path] {
+			w.Header()[key] = values
+		}
+		w.Write([]byte("ok"))
+	})
+
+	for path := range headers {
+		atomic.StoreInt32(calls, 0)
+		doRequest(p, "GET", path, nil)
+		if w := doRequest(p, "GET", path, nil); w.Header().Get("X-Cache") == "HIT" {
+			t.Errorf("%s: expected response not to be served from cache", path)
+		}
+		if n := atomic.LoadInt32(calls); n != 2 {
+			t.Errorf("%s: expected response never to be cached, got %d calls", path, n)
+		}
+	}
+}
+
+func TestProxyCacheKeysOnVary(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte("encoding=" + r.Header.Get("Accept-Encoding")))
+	})
+
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	identity := http.Header{"Accept-Encoding": {"identity"}}
+	if w := doRequest(p, "GET", "/items", gzip); w.Body.String() != "encoding=gzip" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+	w := doRequest(p, "GET", "/items", identity)
+	if w.Header().Get("X-Cache") == "HIT" || w.Body.String() != "encoding=identity" {
+		t.Errorf("expected a different Accept-Encoding to miss the cache, got %q", w.Body.String())
+	}
+	for _, header := range []http.Header{gzip, identity} {
+		w := doRequest(p, "GET", "/items", header)
+		if w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("Accept-Encoding %s: expected a cache hit", header.Get("Accept-Encoding"))
+		}
+		if want := "encoding=" + header.Get("Accept-Encoding"); w.Body.String() != want {
+			t.Errorf("Accept-Encoding %s: expected %q, got %q", header.Get("Accept-Encoding"), want, w.Body.String())
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected one backend call per Accept-Encoding, got %d", n)
+	}
+}
+
+func TestProxyCacheMaxAge(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("ok"))
+	})
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+
+	doRequest(p, "GET", "/items", nil)
+
+	now = now.Add(59 * time.Second)
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected response to be fresh before max-age, overriding the 1m default")
+	}
+
+	now = now.Add(time.Second)
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") == "HIT" {
+		t.Error("expected response to expire at max-age")
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestResponseTTLSMaxAgeWins(t *testing.T) {
+	ttl, ok := responseTTL(http.Header{"Cache-Control": {`max-age=10, s-maxage="300"`}}, time.Minute)
+	if !ok || ttl != 300*time.Second {
+		t.Errorf("expected s-maxage to set a 300s ttl, got %v %v", ttl, ok)
+	}
+
+	ttl, ok = responseTTL(http.Header{}, time.Minute)
+	if !ok || ttl != time.Minute {
+		t.Errorf("expected the default ttl without directives, got %v %v", ttl, ok)
+	}
+}
+
+func TestProxyCacheClientNoCache(t *testing.T) {
+	var version int32
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v" + strconv.Itoa(int(atomic.AddInt32(&version, 1)))))
+	})
+
+	doRequest(p, "GET", "/items", nil)
+
+	w := doRequest(p, "GET", "/items", http.Header{"Cache-Control": {"no-cache"}})
+	if w.Header().Get("X-Cache") == "HIT" || w.Body.String() != "v2" {
+		t.Errorf("expected no-cache to go to the backend, got %q", w.Body.String())
+	}
+
+	w = doRequest(p, "GET", "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "v2" {
+		t.Errorf("expected the revalidated response to be cached, got %q", w.Body.String())
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func cacheEntries(p *Proxy, route *router.Route, paths ...string) {
+	for _, path := range paths {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		p.CacheResponse(req, route, http.StatusOK, http.Header{}, []byte("0123456789"), time.Minute)
+	}
+}
+
+func isCached(p *Proxy, route *router.Route, path string) bool {
+	req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+	_, ok := p.cachedEntry(req, p.getCacheKey(req, route, route.Backend))
+	return ok
+}
+
+func TestProxyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	p := NewProxy()
+	p.SetCachePolicy(CachePolicy{TTL: time.Minute, MaxEntries: 3})
+	route := &router.Route{Name: "all", Backend: backend.NewPool()}
+
+	cacheEntries(p, route, "/a", "/b", "/c")
+	// Touch /a so /b becomes the least recently used
+	isCached(p, route, "/a")
+	cacheEntries(p, route, "/d")
+
+	if stats := p.GetStats(); stats.CacheSize != 3 {
+		t.Errorf("expected cache to stay at 3 entries, got %d", stats.CacheSize)
+	}
+	if isCached(p, route, "/b") {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	for _, path := range []string{"/a", "/c", "/d"} {
+		if !isCached(p, route, path) {
+			t.Errorf("expected %s to still be cached", path)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		cacheEntries(p, route, "/more/"+strconv.Itoa(i))
+		if size := p.GetStats().CacheSize; size > 3 {
+			t.Fatalf("cache grew past its cap to %d entries", size)
+		}
+	}
+}
+
+func TestProxyCacheByteLimit(t *testing.T) {
+	p := NewProxy()
+	p.SetCachePolicy(CachePolicy{TTL: time.Minute, MaxBytes: 25})
+	route := &router.Route{Name: "all", Backend: backend.NewPool()}
+
+	// Each body is 10 bytes, so only two fit
+	cacheEntries(p, route, "/a", "/b", "/c")
+
+	if isCached(p, route, "/a") {
+		t.Error("expected oldest entry to be evicted to stay under the byte limit")
+	}
+	if !isCached(p, route, "/b") || !isCached(p, route, "/c") {
+		t.Error("expected newest entries to be kept")
+	}
+
+	cacheEntries(p, route, "/c")
+	if p.cacheBytes != 20 {
+		t.Errorf("expected replacing an entry not to double count it, got %d bytes", p.cacheBytes)
+	}
+}
+
+func TestProxyCacheReaper(t *testing.T) {
+	p := NewProxy()
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+	route := &router.Route{Name: "all", Backend: backend.NewPool()}
+
+	cacheEntries(p, route, "/a", "/b")
+	req, _ := http.NewRequest("GET", "http://localhost/c", nil)
+	p.CacheResponse(req, route, http.StatusOK, http.Header{}, nil, time.Hour)
+
+	now = now.Add(2 * time.Minute)
+	if removed := p.reapCache(); removed != 2 {
+		t.Errorf("expected 2 expired entries to be reaped, got %d", removed)
+	}
+	if size := p.GetStats().CacheSize; size != 1 {
+		t.Errorf("expected 1 entry to remain, got %d", size)
+	}
+}
+
+func TestProxyPurgeCacheByPrefix(t *testing.T) {
+	p := NewProxy()
+	route := &router.Route{Name: "all", Backend: backend.NewPool()}
+	cacheEntries(p, route, "/api/users", "/api/users/1", "/api/users/2?full=1", "/api/orders", "/static/app.js")
+
+	if purged := p.PurgeCache("/api/users"); purged != 3 {
+		t.Errorf("expected 3 entries purged, got %d", purged)
+	}
+	for _, path := range []string{"/api/orders", "/static/app.js"} {
+		if !isCached(p, route, path) {
+			t.Errorf("expected %s to survive the purge", path)
+		}
+	}
+	if size := p.GetStats().CacheSize; size != 2 {
+		t.Errorf("expected 2 entries left, got %d", size)
+	}
+}
+
+func TestProxyPurgeCacheByGlob(t *testing.T) {
+	p := NewProxy()
+	route := &router.Route{Name: "all", Backend: backend.NewPool()}
+	cacheEntries(p, route, "/api/users/1", "/api/users/2", "/api/users/1/posts", "/api/orders/1")
+
+	if purged := p.PurgeCache("/api/users/*"); purged != 2 {
+		t.Errorf("expected 2 entries purged, got %d", purged)
+	}
+	if !isCached(p, route, "/api/users/1/posts") || !isCached(p, route, "/api/orders/1") {
+		t.Error("expected entries outside the glob to survive")
+	}
+}
+
+func TestProxyPurgeMethod(t *testing.T) {
+	p, _ := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	p.SetPurgeAuthorizer(func(r *http.Request) bool {
+		return r.Header.Get("X-Purge-Token") == "secret"
+	})
+
+	doRequest(p, "GET", "/api/users", nil)
+	doRequest(p, "GET", "/api/orders", nil)
+
+	if w := doRequest(p, MethodPurge, "/api/users", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected unauthorized purge to be rejected, got %d", w.Code)
+	}
+
+	w := doRequest(p, MethodPurge, "/api/users", http.Header{"X-Purge-Token": {"secret"}})
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"purged":1}` {
+		t.Errorf("expected one entry purged, got %d %q", w.Code, w.Body.String())
+	}
+	if w := doRequest(p, "GET", "/api/orders", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected other entries to stay cached")
+	}
+}
+
+func TestProxyCacheStaleWhileRevalidate(t *testing.T) {
+	var version int32
+	release := make(chan struct{})
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		v := atomic.AddInt32(&version, 1)
+		if v > 1 {
+			<-release
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Write([]byte("v" + strconv.Itoa(int(v))))
+	})
+	var clock atomic.Int64
+	clock.Store(time.Unix(1700000000, 0).UnixNano())
+	p.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	revalidated := make(chan struct{}, 10)
+	p.OnSync("cache_revalidated", func(Event) { revalidated <- struct{}{} })
+
+	doRequest(p, "GET", "/items", nil)
+	clock.Add(int64(20 * time.Second))
+
+	// Stale hits are answered from the cache while the single refresh
+	// is still blocked on the backend
+	for i := 0; i < 5; i++ {
+		w := doRequest(p, "GET", "/items", nil)
+		if w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "v1" {
+			t.Fatalf("expected stale v1, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if w.Header().Get("Warning") == "" {
+			t.Error("expected a Warning header on a stale response")
+		}
+	}
+	close(release)
+
+	select {
+	case <-revalidated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for revalidation")
+	}
+	w := doRequest(p, "GET", "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "v2" {
+		t.Errorf("expected revalidated v2 to be fresh, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected exactly one revalidation, got %d backend calls", n)
+	}
+	if len(revalidated) != 0 {
+		t.Errorf("expected revalidation to run once, ran %d more times", len(revalidated))
+	}
+}
+
+func TestProxyCacheRevalidationSurvivesTruncatedBody(t *testing.T) {
+	var version int32
+	p, _ := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		if atomic.AddInt32(&version, 1) == 1 {
+			w.Write([]byte("v1"))
+			return
+		}
+		// Promise more than is sent, then drop the connection
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	var clock atomic.Int64
+	clock.Store(time.Unix(1700000000, 0).UnixNano())
+	p.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	revalidated := make(chan struct{}, 1)
+	p.OnSync("cache_revalidated", func(Event) { revalidated <- struct{}{} })
+
+	// Served by a real server, whose request context makes ReverseProxy
+	// abort the handler on a failed body copy
+	front := httptest.NewServer(p)
+	defer front.Close()
+	get := func() (string, string) {
+		resp, err := http.Get(front.URL + "/items")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(body)
+	}
+
+	get()
+	clock.Add(int64(20 * time.Second))
+	if status, body := get(); status != "STALE" || body != "v1" {
+		t.Fatalf("expected stale v1, got %q %q", status, body)
+	}
+	select {
+	case <-revalidated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for revalidation")
+	}
+	if status, body := get(); status != "STALE" || body != "v1" {
+		t.Errorf("expected the failed refresh to keep the stale entry, got %q %q", status, body)
+	}
+}
+
+func TestProxyCacheStaleWindowExpires(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	p.SetCachePolicy(CachePolicy{TTL: 10 * time.Second, StaleWhileRevalidate: 30 * time.Second})
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+
+	doRequest(p, "GET", "/items", nil)
+	now = now.Add(41 * time.Second)
+
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "" {
+		t.Errorf("expected an entry past its stale window to be fetched again, got %q", w.Header().Get("X-Cache"))
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestResponseStale(t *testing.T) {
+	if stale := responseStale(http.Header{"Cache-Control": {"max-age=5, stale-while-revalidate=30"}}, time.Minute); stale != 30*time.Second {
+		t.Errorf("expected the directive to win, got %v", stale)
+	}
+	if stale := responseStale(http.Header{}, time.Minute); stale != time.Minute {
+		t.Errorf("expected the policy default, got %v", stale)
+	}
+}
+
+func TestProxyCacheIfNoneMatch(t *testing.T) {
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello"))
+	})
+
+	doRequest(p, "GET", "/items", nil)
+
+	w := doRequest(p, "GET", "/items", http.Header{"If-None-Match": {`"v0", W/"v1"`}})
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no body on 304, got %q", w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"v1"` || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the cached ETag on a cache hit, got %q %q", w.Header().Get("ETag"), w.Header().Get("X-Cache"))
+	}
+
+	w = doRequest(p, "GET", "/items", http.Header{"If-None-Match": {`"v0"`}})
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("expected the full response for a stale ETag, got %d %q", w.Code, w.Body.String())
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("expected conditional requests to be answered from cache, got %d backend calls", n)
+	}
+}
+
+func TestProxyCacheRevalidatesWithETag(t *testing.T) {
+	var conditional int32
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+	var clock atomic.Int64
+	clock.Store(time.Unix(1700000000, 0).UnixNano())
+	p.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	revalidated := make(chan struct{}, 1)
+	p.OnSync("cache_revalidated", func(Event) { revalidated <- struct{}{} })
+
+	doRequest(p, "GET", "/items", nil)
+	clock.Add(int64(20 * time.Second))
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "STALE" {
+		t.Fatalf("expected a stale hit, got %q", w.Header().Get("X-Cache"))
+	}
+	select {
+	case <-revalidated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for revalidation")
+	}
+
+	clock.Add(int64(5 * time.Second))
+	w := doRequest(p, "GET", "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "hello" {
+		t.Errorf("expected the 304 to renew the cached body, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if n := atomic.LoadInt32(&conditional); n != 1 {
+		t.Errorf("expected one conditional revalidation, got %d", n)
+	}
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("expected 2 backend calls, got %d", n)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch, etag string
+		want              bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{``, `"a"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestProxyCacheRevalidatesExpiredWithETag(t *testing.T) {
+	var conditional int32
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+	p, calls := newCachingProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		current := etag.Load().(string)
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("ETag", current)
+		if r.Header.Get("If-None-Match") == current {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body " + current))
+	})
+	now := time.Unix(1700000000, 0)
+	p.now = func() time.Time { return now }
+
+	doRequest(p, "GET", "/items", nil)
+	now = now.Add(20 * time.Second)
+	if n := p.reapCache(); n != 0 {
+		t.Fatalf("expected an entry with an ETag to survive the reaper, %d removed", n)
+	}
+
+	w := doRequest(p, "GET", "/items", nil)
+	if w.Header().Get("X-Cache") != "REVALIDATED" || w.Code != http.StatusOK || w.Body.String() != `body "v1"` {
+		t.Fatalf("expected the 304 to renew the cached body, got %q %d %q", w.Header().Get("X-Cache"), w.Code, w.Body.String())
+	}
+	if n := atomic.LoadInt32(&conditional); n != 1 {
+		t.Errorf("expected one conditional request, got %d", n)
+	}
+	now = now.Add(5 * time.Second)
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected the renewed entry to be fresh, got %q", w.Header().Get("X-Cache"))
+	}
+
+	// A changed resource is fetched in full and replaces the entry
+	etag.Store(`"v2"`)
+	now = now.Add(20 * time.Second)
+	if w := doRequest(p, "GET", "/items", nil); w.Body.String() != `body "v2"` {
+		t.Errorf("expected the changed body, got %q", w.Body.String())
+	}
+	if w := doRequest(p, "GET", "/items", nil); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `body "v2"` {
+		t.Errorf("expected the changed body to be cached, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if n := atomic.LoadInt32(calls); n != 3 {
+		t.Errorf("expected 3 backend calls, got %d", n)
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+type errorPage struct {
+	body        []byte
+	contentType string
+}
+
+// SetErrorPage replaces the plain text body the proxy sends with its own
+// error responses of the given status, such as 404 for an unmatched route
+// or 502 for a failed upstream. Responses from backends are not affected.
+// If contentType is empty it is detected from body.
+func (p *Proxy) SetErrorPage(status int, body []byte, contentType string) {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.errorPages == nil {
+		p.errorPages = make(map[int]errorPage)
+	}
+	p.errorPages[status] = errorPage{body: body, contentType: contentType}
+}
+
+// writeError replies with the error page registered for status, or with
+// message as plain text like http.Error
+func (p *Proxy) writeError(w http.ResponseWriter, message string, status int) {
+	p.mu.RLock()
+	page, ok := p.errorPages[status]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, message, status)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", page.contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(page.body)
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestProxyCustomErrorPage(t *testing.T) {
+	p := NewProxy()
+	page := []byte("<html><body>Nothing here</body></html>")
+	p.SetErrorPage(http.StatusNotFound, page, "text/html; charset=utf-8")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/missing", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if w.Body.String() != string(page) {
+		t.Errorf("expected the custom page, got %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("expected the page's content type, got %q", ct)
+	}
+}
+
+func TestProxyErrorPageFallsBackToPlainText(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	p := NewProxy()
+	p.SetErrorPage(http.StatusNotFound, []byte("<h1>404</h1>"), "")
+	pool := backend.NewPool()
+	pool.AddServer(deadURL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/item", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Body.String(), "Bad Gateway") {
+		t.Errorf("expected the plain text error without a 502 page, got %q", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected plain text, got %q", ct)
+	}
+}
+
+func TestProxyErrorPageForUpstreamFailure(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	p := NewProxy()
+	p.SetErrorPage(http.StatusBadGateway, []byte(`{"error":"upstream unavailable"}`), "application/json")
+	pool := backend.NewPool()
+	pool.AddServer(deadURL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/item", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway || w.Body.String() != `{"error":"upstream unavailable"}` {
+		t.Errorf("expected the custom 502 page, got %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+}
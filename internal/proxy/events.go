@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"sync/atomic"
+)
+
+const (
+	defaultEventWorkers   = 8
+	defaultEventQueueSize = 1024
+)
+
+// eventHandler is a registered handler and whether it runs inline
+type eventHandler struct {
+	fn   func(Event)
+	sync bool
+}
+
+type queuedEvent struct {
+	handler func(Event)
+	event   Event
+}
+
+// eventDispatcher runs asynchronous event handlers on a bounded set of
+// workers fed by a buffered queue. Workers are started on demand and exit
+// once the queue is drained, so an idle proxy holds no goroutines. When the
+// queue is full events are dropped rather than blocking the request.
+type eventDispatcher struct {
+	queue      chan queuedEvent
+	maxWorkers int32
+	workers    int32
+	dropped    int64
+}
+
+func newEventDispatcher(workers, queueSize int) *eventDispatcher {
+	return &eventDispatcher{
+		queue:      make(chan queuedEvent, queueSize),
+		maxWorkers: int32(workers),
+	}
+}
+
+// dispatch queues the event for handler, reporting false if it was dropped
+func (d *eventDispatcher) dispatch(handler func(Event), event Event) bool {
+	select {
+	case d.queue <- queuedEvent{handler: handler, event: event}:
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+		return false
+	}
+	if d.acquireWorker() {
+		go d.work()
+	}
+	return true
+}
+
+func (d *eventDispatcher) acquireWorker() bool {
+	for {
+		n := atomic.LoadInt32(&d.workers)
+		if n >= d.maxWorkers {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&d.workers, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (d *eventDispatcher) work() {
+	for {
+		select {
+		case item := <-d.queue:
+			item.handler(item.event)
+			continue
+		default:
+		}
+
+		atomic.AddInt32(&d.workers, -1)
+		// An event queued while this worker was giving up may have seen
+		// every worker busy, so take another look before exiting.
+		if len(d.queue) == 0 || !d.acquireWorker() {
+			return
+		}
+	}
+}
+
+// Dropped returns how many events were discarded because the queue was full
+func (d *eventDispatcher) Dropped() int64 {
+	return atomic.LoadInt64(&d.dropped)
+}
+
+// OnSync registers an event handler that runs on the goroutine emitting
+// the event, so it sees events in order. It delays the request it was
+// emitted from and must return quickly.
+func (p *Proxy) OnSync(eventType string, handler func(Event)) {
+	p.addEventHandler(eventType, eventHandler{fn: handler, sync: true})
+}
+
+// SetEventWorkers sets how many goroutines run asynchronous event handlers
+// and how many events may wait for them before new ones are dropped. It
+// should be called before the proxy starts serving.
+func (p *Proxy) SetEventWorkers(workers, queueSize int) {
+	if workers <= 0 {
+		workers = defaultEventWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultEventQueueSize
+	}
+	p.events = newEventDispatcher(workers, queueSize)
+}
+
+func (p *Proxy) addEventHandler(eventType string, handler eventHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.eventHandlers[eventType] = append(p.eventHandlers[eventType], handler)
+}
+
+// emitEvent runs synchronous handlers inline and queues the rest
+func (p *Proxy) emitEvent(event Event) {
+	p.mu.RLock()
+	handlers := p.eventHandlers[event.Type]
+	p.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if handler.sync {
+			handler.fn(event)
+		} else {
+			p.events.dispatch(handler.fn, event)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEmitEventRunsHandlers(t *testing.T) {
+	p := NewProxy()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var calls int32
+	handler := func(e Event) {
+		if e.Type == "ping" {
+			atomic.AddInt32(&calls, 1)
+		}
+		wg.Done()
+	}
+	p.On("ping", handler)
+	p.On("ping", handler)
+	p.On("other", func(Event) { t.Error("handler for another event type ran") })
+
+	p.emitEvent(Event{Type: "ping"})
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for handlers")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected both handlers to run, got %d", n)
+	}
+}
+
+func TestOnSyncPreservesOrder(t *testing.T) {
+	p := NewProxy()
+
+	var seen []int
+	p.OnSync("tick", func(e Event) {
+		seen = append(seen, e.Status)
+	})
+
+	for i := 0; i < 100; i++ {
+		p.emitEvent(Event{Type: "tick", Status: i})
+	}
+
+	if len(seen) != 100 {
+		t.Fatalf("expected 100 events handled before emit returned, got %d", len(seen))
+	}
+	for i, status := range seen {
+		if status != i {
+			t.Fatalf("expected events in order, got %d at position %d", status, i)
+		}
+	}
+}
+
+func TestEmitEventBoundsGoroutines(t *testing.T) {
+	p := NewProxy()
+	p.SetEventWorkers(4, 16)
+
+	release := make(chan struct{})
+	var handled int32
+	p.On("slow", func(Event) {
+		<-release
+		atomic.AddInt32(&handled, 1)
+	})
+
+	before := runtime.NumGoroutine()
+	start := time.Now()
+	for i := 0; i < 10000; i++ {
+		p.emitEvent(Event{Type: "slow"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected a slow handler not to block emitting, took %v", elapsed)
+	}
+	if n := runtime.NumGoroutine() - before; n > 4 {
+		t.Errorf("expected at most 4 extra goroutines, got %d", n)
+	}
+
+	close(release)
+	want := int32(10000 - p.GetStats().DroppedEvents)
+	if want < 16 || want > 4+16 {
+		t.Errorf("expected the queue and workers to accept 16 to 20 events, got %d", want)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&handled) < want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&handled); n != want {
+		t.Errorf("expected %d queued events to be handled, got %d", want, n)
+	}
+}
+
+func TestEventWorkersExitWhenIdle(t *testing.T) {
+	p := NewProxy()
+	var handled int32
+	p.On("ping", func(Event) { atomic.AddInt32(&handled, 1) })
+
+	for i := 0; i < 100; i++ {
+		p.emitEvent(Event{Type: "ping"})
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for (atomic.LoadInt32(&handled) < 100 || atomic.LoadInt32(&p.events.workers) != 0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&handled); n != 100 {
+		t.Errorf("expected 100 events handled, got %d", n)
+	}
+	if n := atomic.LoadInt32(&p.events.workers); n != 0 {
+		t.Errorf("expected workers to exit once idle, %d still running", n)
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// LivenessHandler answers 200 OK for as long as the process is serving
+func (p *Proxy) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+}
+
+// ReadinessHandler answers 200 OK once a config has been loaded with Reload
+// and every backend it configures has a server taking new requests, one
+// that is healthy and neither draining nor ejected, and 503 Service
+// Unavailable otherwise or once BeginShutdown has been called
+func (p *Proxy) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := p.ready(); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+}
+
+// ProbesHandler serves the liveness and readiness handlers on their paths
+// and passes every other request to next
+func (p *Proxy) ProbesHandler(next http.Handler, livenessPath, readinessPath string) http.Handler {
+	live, ready := p.LivenessHandler(), p.ReadinessHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case livenessPath:
+			live.ServeHTTP(w, r)
+		case readinessPath:
+			ready.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// BeginShutdown marks the proxy as not ready, so load balancers stop
+// sending it traffic before its listeners are shut down
+func (p *Proxy) BeginShutdown() {
+	p.shuttingDown.Store(true)
+}
+
+// ready reports why the proxy shouldn't receive traffic, if it shouldn't
+func (p *Proxy) ready() error {
+	if p.shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	if p.backends == nil {
+		return errors.New("no config loaded")
+	}
+	var unavailable []string
+	for id, b := range p.backends {
+		if b.pool.Status().Available == 0 {
+			unavailable = append(unavailable, id)
+		}
+	}
+	if len(unavailable) > 0 {
+		slices.Sort(unavailable)
+		return fmt.Errorf("no available servers in backends %s", strings.Join(unavailable, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/config"
+)
+
+func probe(h http.Handler, path string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code
+}
+
+func TestReadinessFollowsBackendHealth(t *testing.T) {
+	p := NewProxy()
+	h := p.ProbesHandler(p, "/livez", "/readyz")
+	if code := probe(h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 before a config is loaded, got %d", code)
+	}
+
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{
+			{ID: "a", Servers: []string{newNamedBackend(t, "a1"), newNamedBackend(t, "a2")}},
+			{ID: "b", Servers: []string{newNamedBackend(t, "b")}},
+		},
+		Routes: []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if code := probe(h, "/readyz"); code != http.StatusOK {
+		t.Fatalf("expected 200 with healthy backends, got %d", code)
+	}
+
+	poolA := p.backends["a"].pool
+	for _, server := range poolA.Servers {
+		poolA.SetServerHealth(server, false)
+	}
+	if code := probe(h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with every server of a backend unhealthy, got %d", code)
+	}
+	if code := probe(h, "/livez"); code != http.StatusOK {
+		t.Errorf("expected liveness to be unaffected by backend health, got %d", code)
+	}
+
+	poolA.SetServerHealth(poolA.Servers[1], true)
+	if code := probe(h, "/readyz"); code != http.StatusOK {
+		t.Errorf("expected 200 once a server recovers, got %d", code)
+	}
+}
+
+func TestReadinessIgnoresDrainingServers(t *testing.T) {
+	p := NewProxy()
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{newNamedBackend(t, "a1"), newNamedBackend(t, "a2")}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	h := p.ReadinessHandler()
+
+	pool := p.backends["a"].pool
+	pool.DrainServer(pool.Servers[0])
+	if code := probe(h, "/readyz"); code != http.StatusOK {
+		t.Errorf("expected 200 with a server left, got %d", code)
+	}
+	pool.DrainServer(pool.Servers[1])
+	if code := probe(h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 with every server draining, got %d", code)
+	}
+}
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	p := NewProxy()
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{newNamedBackend(t, "a")}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	h := p.ProbesHandler(p, "/livez", "/readyz")
+
+	p.BeginShutdown()
+	if code := probe(h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while shutting down, got %d", code)
+	}
+	if code := probe(h, "/livez"); code != http.StatusOK {
+		t.Errorf("expected liveness while shutting down, got %d", code)
+	}
+	if code, body := serveBody(p, "/"); code != http.StatusOK || body != "a" {
+		t.Errorf("expected requests to be served until the listeners stop, got %d %q", code, body)
+	}
+}
+
+func TestProbesHandlerPassesOtherRequests(t *testing.T) {
+	p := NewProxy()
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{newNamedBackend(t, "a")}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	h := p.ProbesHandler(p, "/health/live", "/health/ready")
+
+	for _, path := range []string{"/health/live", "/health/ready"} {
+		if code := probe(h, path); code != http.StatusOK {
+			t.Errorf("expected %s to be served by the proxy itself, got %d", path, code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/livez", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Errorf("expected other paths to be proxied, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -1,17 +1,26 @@
 package proxy
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/surukanti/reverse-proxy/internal/advanced"
 	"github.com/surukanti/reverse-proxy/internal/backend"
 	"github.com/surukanti/reverse-proxy/internal/middleware"
 	"github.com/surukanti/reverse-proxy/internal/router"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Proxy represents the reverse proxy
@@ -19,13 +28,38 @@ type Proxy struct {
 	router        *router.Router
 	middlewares   *middleware.Chain
 	rateLimiter   *middleware.RateLimiter
+	rateLimitKey  func(*http.Request) string
+	tenantLimit   *advanced.TenantRateLimiter
+	tenantID      func(*http.Request) string
+	retry         RetryPolicy
+	responseRules ResponseRules
+	flushInterval time.Duration
+	tracer        trace.Tracer
+	propagator    propagation.TextMapPropagator
 	transport     *http.Transport
 	mu            sync.RWMutex
 	requestCount  int64
 	errorCount    int64
+	routeStats    routeCounters
 	cache         map[string]*CacheEntry
+	cacheOrder    *list.List // most recently used first
+	cacheBytes    int64
+	cacheVary     map[string]*cacheVary // by base key, see getCacheKey
+	cachePolicy   CachePolicy
+	purgeAuth     func(*http.Request) bool
+	adminAuth     func(*http.Request) bool
+	accessLog     func(AccessLogEntry)
+	errorPages    map[int]errorPage
+	trustedNets   []netip.Prefix
+	preserveHost  bool
+	now           func() time.Time
 	cacheMu       sync.RWMutex
-	eventHandlers map[string][]func(Event)
+	eventHandlers map[string][]eventHandler
+	events        *eventDispatcher
+	breakers      breakerRegistry
+	reloadMu      sync.Mutex
+	backends      map[string]*managedBackend // backends built by Reload, by ID
+	shuttingDown  atomic.Bool
 }
 
 // CacheEntry represents a cached response
@@ -34,6 +68,16 @@ type CacheEntry struct {
 	Headers http.Header
 	Body    []byte
 	Expires time.Time
+
+	key  string
+	base string   // key without the Vary request headers
+	vary []string // request headers the response varies on
+	uri  string   // request path and query, matched by PurgeCache
+	size int64
+	elem *list.Element // position in the LRU order
+
+	stale        time.Duration // how long past Expires it may be served while revalidating
+	revalidating bool
 }
 
 // Event represents a proxy event
@@ -43,17 +87,47 @@ type Event struct {
 	Request   *http.Request
 	Response  *http.Response
 	Error     error
+	Server    *backend.Server
+	Healthy   bool
+	Route     string        // matched route name, set on request_completed
+	Status    int           // response status, set on request_completed
+	Duration  time.Duration // time spent serving the request, set on request_completed
+	Tenant    string        // tenant over its quota, set on rate_limit_exceeded
+
+	// UpstreamDuration is the time spent forwarding the request and relaying
+	// the response, including retries; set on request_completed along with
+	// Server when a backend was used
+	UpstreamDuration time.Duration
+
+	// PreviousState and State are the circuit breaker states before and
+	// after a transition; set on circuit_breaker_state_changed along with
+	// Server
+	PreviousState string
+	State         string
 }
 
+// The rate limit applied when none is configured
+const (
+	defaultRateLimit       = 1000
+	defaultRateLimitWindow = time.Minute
+)
+
 // NewProxy creates a new reverse proxy
 func NewProxy() *Proxy {
 	return &Proxy{
 		router:        router.NewRouter(),
 		middlewares:   middleware.NewChain(),
-		rateLimiter:   middleware.NewRateLimiter(1000, time.Minute),
+		rateLimiter:   middleware.NewRateLimiter(defaultRateLimit, defaultRateLimitWindow),
 		transport:     &http.Transport{},
 		cache:         make(map[string]*CacheEntry),
-		eventHandlers: make(map[string][]func(Event)),
+		cacheOrder:    list.New(),
+		cacheVary:     make(map[string]*cacheVary),
+		eventHandlers: make(map[string][]eventHandler),
+		events:        newEventDispatcher(defaultEventWorkers, defaultEventQueueSize),
+		now:           time.Now,
+		flushInterval: defaultFlushInterval,
+		tracer:        noopTracer,
+		propagator:    propagation.TraceContext{},
 	}
 }
 
@@ -62,6 +136,11 @@ func (p *Proxy) Router() *router.Router {
 	return p.router
 }
 
+// RateLimiter returns the rate limiter
+func (p *Proxy) RateLimiter() *middleware.RateLimiter {
+	return p.rateLimiter
+}
+
 // AddRoute adds a new route
 func (p *Proxy) AddRoute(route *router.Route) error {
 	return p.router.AddRoute(route)
@@ -73,89 +152,296 @@ func (p *Proxy) AddMiddleware(handler middleware.Handler) *Proxy {
 	return p
 }
 
+// AddAfterHook adds a hook run after each response with its status, size
+// and duration
+func (p *Proxy) AddAfterHook(hook middleware.AfterHook) *Proxy {
+	p.middlewares.After(hook)
+	return p
+}
+
 // SetRateLimit sets the rate limit
 func (p *Proxy) SetRateLimit(maxRequests int, window time.Duration) {
 	p.rateLimiter = middleware.NewRateLimiter(maxRequests, window)
 }
 
+// SetPreserveHost controls whether upstream requests carry the client's
+// Host header rather than the backend's, for every route. Routes can also
+// opt in individually with Route.PreserveHost.
+func (p *Proxy) SetPreserveHost(preserve bool) {
+	p.preserveHost = preserve
+}
+
+// SetRateLimitKeyFunc sets how requests are grouped for rate limiting, for
+// example by a header or API key. It runs before middleware, so it sees the
+// request as received. By default requests are keyed on the client IP.
+func (p *Proxy) SetRateLimitKeyFunc(key func(*http.Request) string) {
+	p.rateLimitKey = key
+}
+
 // ServeHTTP implements http.Handler
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&p.requestCount, 1)
+	start := time.Now()
+	rw := middleware.NewResponseWriter(w)
+	w = rw
+	r, span := p.startSpan(r)
+	var routeName, upstream, cacheStatus string
+	var upstreamServer *backend.Server
+	var upstreamDuration time.Duration
+	defer func() {
+		endSpan(span, routeName, rw.Status())
+		if routeName != "" {
+			p.routeStats.record(routeName, rw.Status())
+		}
+		duration := time.Since(start)
+		p.middlewares.ExecuteAfter(r, middleware.ResponseInfo{
+			Status:   rw.Status(),
+			Bytes:    rw.BytesWritten(),
+			Duration: duration,
+		})
+		p.emitEvent(Event{
+			Type:             "request_completed",
+			Timestamp:        time.Now(),
+			Request:          r,
+			Server:           upstreamServer,
+			Route:            routeName,
+			Status:           rw.Status(),
+			Duration:         duration,
+			UpstreamDuration: upstreamDuration,
+		})
+		if p.accessLog != nil {
+			p.accessLog(AccessLogEntry{
+				Time:             start,
+				Method:           r.Method,
+				Path:             r.URL.Path,
+				Route:            routeName,
+				Status:           rw.Status(),
+				Bytes:            rw.BytesWritten(),
+				Upstream:         upstream,
+				Cache:            cacheStatus,
+				RequestID:        middleware.RequestID(r),
+				Duration:         duration,
+				UpstreamDuration: upstreamDuration,
+			})
+		}
+	}()
+
 	// Check rate limit
 	clientIP := p.getClientIP(r)
-	if !p.rateLimiter.Handle(clientIP) {
+	limitKey := clientIP
+	if p.rateLimitKey != nil {
+		limitKey = p.rateLimitKey(r)
+	}
+	limit := p.rateLimiter.Check(limitKey)
+	setRateLimitHeaders(w, limit)
+	if !limit.Allowed {
+		p.countError()
 		p.emitEvent(Event{
 			Type:      "rate_limit_exceeded",
 			Timestamp: time.Now(),
 			Request:   r,
 		})
-		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		p.writeError(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if r.Method == MethodPurge && p.purgeAuth != nil {
+		p.servePurge(w, r)
 		return
 	}
 
 	// Execute middleware chain
-	if err := p.middlewares.Execute(w, r); err != nil {
-		p.emitEvent(Event{
-			Type:      "middleware_error",
-			Timestamp: time.Now(),
-			Request:   r,
-			Error:     err,
-		})
-		http.Error(w, err.Error(), http.StatusForbidden)
+	r, err := p.middlewares.Execute(w, r)
+	if err != nil {
+		p.middlewareError(w, r, err)
+		return
+	}
+
+	if !p.allowTenant(w, r) {
 		return
 	}
 
 	// Find matching route
-	route := p.router.Match(r)
+	route, params := p.router.MatchParams(r)
+	if params != nil {
+		r = router.WithParams(r, params)
+	}
 	if route == nil {
+		p.countError()
 		p.emitEvent(Event{
 			Type:      "no_route_found",
 			Timestamp: time.Now(),
 			Request:   r,
 		})
-		http.Error(w, "Not Found", http.StatusNotFound)
+		p.writeError(w, "Not Found", http.StatusNotFound)
 		return
 	}
+	routeName = route.Name
+
+	// Execute route middleware
+	for _, handler := range route.Middlewares {
+		next, err := handler(w, r)
+		if err != nil {
+			p.middlewareError(w, r, err)
+			return
+		}
+		r = next
+	}
+
+	// Redirect routes answer without proxying
+	if route.Redirect != nil {
+		http.Redirect(w, r, route.RedirectURL(r), route.RedirectStatus())
+		return
+	}
+
+	// Check cache, before choosing a server: any of the route's servers
+	// may have produced a cached response
+	pool := route.SelectBackend(clientIP)
+	cacheKey := p.getCacheKey(r, route, pool)
+	if cached, ok := p.cachedEntry(r, cacheKey); ok {
+		now := p.now()
+		switch {
+		case cached.Fresh(now):
+			cacheStatus = "HIT"
+		case cached.usableAt(now):
+			cacheStatus = "STALE"
+			if p.claimRevalidation(cached) {
+				go p.revalidate(r, route, pool, cached)
+			}
+		default:
+			// Past its stale window, the entry's ETag still lets the
+			// backend confirm it with a 304 instead of sending it again
+			p.refresh(r, route, pool, cached)
+			cached, ok = p.cachedEntry(r, cacheKey)
+			if ok = ok && cached.Fresh(p.now()); ok {
+				cacheStatus = "REVALIDATED"
+			}
+		}
+		if ok {
+			p.serveCached(w, r, cached, cacheStatus)
+			p.emitEvent(Event{
+				Type:      "cache_hit",
+				Timestamp: time.Now(),
+				Request:   r,
+			})
+			return
+		}
+	}
+	p.emitEvent(Event{
+		Type:      "cache_miss",
+		Timestamp: time.Now(),
+		Request:   r,
+	})
 
 	// Get backend server
-	server := route.Backend.GetServer()
+	server := p.selectServer(w, r, pool)
 	if server == nil {
+		p.countError()
 		p.emitEvent(Event{
 			Type:      "no_backend_available",
 			Timestamp: time.Now(),
 			Request:   r,
 		})
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		p.writeError(w, "Service Unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	// Check cache
-	cacheKey := p.getCacheKey(r, server)
-	if cached, ok := p.cache[cacheKey]; ok && cached.Expires.After(time.Now()) {
-		p.serveCached(w, cached)
+	// Forward request, avoiding servers whose circuit breaker is open or
+	// that have no request slot left
+	reserved := p.acquireServer(pool, server)
+	if reserved == nil {
+		eventType := "no_backend_available"
+		if p.breakerOpen(server) {
+			eventType = "circuit_open"
+		}
+		p.countError()
 		p.emitEvent(Event{
-			Type:      "cache_hit",
+			Type:      eventType,
 			Timestamp: time.Now(),
 			Request:   r,
 		})
+		p.writeError(w, "Service Unavailable", http.StatusServiceUnavailable)
 		return
 	}
+	upstreamStart := time.Now()
+	upstreamServer = p.forwardWithRetry(w, r, route, pool, reserved)
+	upstreamDuration = time.Since(upstreamStart)
+	if upstreamServer != nil {
+		upstream = upstreamServer.URL.String()
+	}
+}
+
+// setRateLimitHeaders reports the client's quota, with Retry-After once it
+// is exhausted. Durations are rounded up to whole seconds.
+func setRateLimitHeaders(w http.ResponseWriter, limit middleware.RateLimitResult) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(limit.Reset)))
+	if !limit.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(limit.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// middlewareError reports a middleware rejection and ends the request. A
+// middleware that returns ErrHandled has already written its response.
+func (p *Proxy) middlewareError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, middleware.ErrHandled) {
+		return
+	}
+
+	p.emitEvent(Event{
+		Type:      "middleware_error",
+		Timestamp: time.Now(),
+		Request:   r,
+		Error:     err,
+	})
+	p.writeError(w, err.Error(), http.StatusForbidden)
+}
+
+// selectServer picks a backend server from the pool. A valid sticky session
+// cookie wins; otherwise consistent hashing keys on the request path and IP
+// hashing on the client IP, and newly chosen servers are pinned by cookie.
+func (p *Proxy) selectServer(w http.ResponseWriter, r *http.Request, pool *backend.Pool) *backend.Server {
+	if server := pool.StickyServer(r); server != nil {
+		return server
+	}
+
+	var server *backend.Server
+	switch pool.Strategy() {
+	case backend.StrategyConsistentHash:
+		server = pool.GetServerForKey(r.URL.Path)
+	case backend.StrategyIPHash:
+		server = pool.GetServerForKey(p.getClientIP(r))
+	default:
+		server = pool.GetServer()
+	}
 
-	// Forward request
-	p.forwardRequest(w, r, server)
+	if server != nil {
+		pool.SetStickyCookie(w, server)
+	}
+	return server
 }
 
-// forwardRequest forwards the request to the backend server
-func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, server *backend.Server) {
+// forwardRequest makes one attempt at proxying the request to server. A
+// failure that mode allows retrying is not written to the client; retry is
+// returned true instead so the caller can try again. upstreamErr is set
+// when the server failed, by a connection error or a 5xx response.
+func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, route *router.Route, pool *backend.Pool, server *backend.Server, mode retryMode) (retry bool, upstreamErr error) {
 	// Validate server URL
 	if server == nil || server.URL == nil {
+		p.countError()
 		p.emitEvent(Event{
 			Type:      "proxy_error",
 			Timestamp: time.Now(),
 			Request:   r,
 			Error:     fmt.Errorf("invalid server or server URL is nil"),
 		})
-		http.Error(w, "Bad Gateway: invalid server URL", http.StatusBadGateway)
-		return
+		p.writeError(w, "Bad Gateway: invalid server URL", http.StatusBadGateway)
+		return false, nil
 	}
 
 	// Create reverse proxy
@@ -163,21 +449,96 @@ func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, server *b
 
 	// Set custom transport
 	proxy.Transport = p.transport
+	if transport := pool.Transport(); transport != nil {
+		proxy.Transport = transport
+	}
+	proxy.FlushInterval = p.flushInterval
+
+	// Record upstream results for passive health checking and latency
+	// balancing. Latency covers relaying the whole body, except for event
+	// streams which stay open indefinitely.
+	start := time.Now()
+	cacheTTL := p.cacheTTL(r)
+	var recorder *cacheRecorder
+	var responded, streamed bool
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		responded = true
+		if isEventStream(resp) {
+			streamed = true
+			pool.RecordLatency(server, time.Since(start))
+		}
+		pool.RecordResult(server, resp.StatusCode < http.StatusInternalServerError)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			upstreamErr = fmt.Errorf("upstream returned %d", resp.StatusCode)
+			if mode == retryAll {
+				return errRetryStatus
+			}
+		}
+		p.modifyResponse(resp, r, server)
+		if cacheTTL > 0 && !isEventStream(resp) {
+			recorder = recordForCache(resp, cacheTTL, p.cacheStaleWindow())
+		}
+		return nil
+	}
+
+	// Apply the route's upstream deadline
+	if route.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 
 	// Custom error handler
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			p.writeError(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, errRetryStatus) {
+			retry = true
+			return
+		}
+
+		// A client going away says nothing about the server
+		if !errors.Is(err, context.Canceled) {
+			upstreamErr = err
+			pool.RecordResult(server, false)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			p.countError()
+			p.emitEvent(Event{
+				Type:      "proxy_timeout",
+				Timestamp: time.Now(),
+				Request:   r,
+				Error:     err,
+				Server:    server,
+			})
+			p.writeError(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			return
+		}
 		p.emitEvent(Event{
 			Type:      "proxy_error",
 			Timestamp: time.Now(),
 			Request:   r,
 			Error:     err,
+			Server:    server,
 		})
-		http.Error(w, fmt.Sprintf("Bad Gateway: %v", err), http.StatusBadGateway)
+		if (mode == retryAll || (mode == retryConnect && isConnectError(err))) && r.Context().Err() == nil {
+			retry = true
+			return
+		}
+		p.countError()
+		p.writeError(w, fmt.Sprintf("Bad Gateway: %v", err), http.StatusBadGateway)
 	}
 
 	// Modify request - use the default Director from NewSingleHostReverseProxy and add our headers
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
+		if path := route.RewritePath(req.URL.Path); path != req.URL.Path {
+			req.URL.Path = path
+			req.URL.RawPath = ""
+		}
 		originalDirector(req)
 		req.Header.Set("X-Forwarded-For", p.getClientIP(r))
 		req.Header.Set("X-Forwarded-Proto", r.Header.Get("X-Forwarded-Proto"))
@@ -185,6 +546,17 @@ func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, server *b
 			req.Header.Set("X-Forwarded-Proto", "http")
 		}
 		req.Header.Set("X-Real-IP", r.RemoteAddr)
+		req.Header.Set("X-Forwarded-Host", r.Host)
+		if !p.preserveHost && !route.PreserveHost {
+			req.Host = server.URL.Host
+		}
+		if id := middleware.RequestID(r); id != "" {
+			req.Header.Set(middleware.RequestIDHeader, id)
+		}
+		for key, value := range route.RequestHeaders {
+			req.Header.Set(key, value)
+		}
+		p.traceUpstream(r, req, server.URL.String())
 	}
 
 	p.emitEvent(Event{
@@ -194,37 +566,95 @@ func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, server *b
 	})
 
 	proxy.ServeHTTP(w, r)
+	if responded && !streamed {
+		pool.RecordLatency(server, time.Since(start))
+	}
+	if !retry {
+		recorder.store(p, r, route, pool)
+	}
+	return retry, upstreamErr
 }
 
-// serveCached serves a cached response
-func (p *Proxy) serveCached(w http.ResponseWriter, entry *CacheEntry) {
+// serveCached serves a cached response, marked with its cache status. A
+// client already holding the entry's ETag gets 304 Not Modified.
+func (p *Proxy) serveCached(w http.ResponseWriter, r *http.Request, entry *CacheEntry, status string) {
 	for key, values := range entry.Headers {
+		// Headers set by middleware for this request take precedence
+		if _, ok := w.Header()[key]; ok {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
-	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("X-Cache", status)
+	if status == "STALE" {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	if etag := entry.Headers.Get("ETag"); etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.WriteHeader(entry.Status)
 	w.Write(entry.Body)
 }
 
-// getCacheKey generates a cache key
-func (p *Proxy) getCacheKey(r *http.Request, server *backend.Server) string {
-	return r.Method + ":" + r.URL.Path + ":" + server.URL.String()
+// getCacheKey generates a cache key. Responses for the same request can
+// vary on the request headers their Vary header names, so those of the
+// latest response cached for it are part of the key.
+func (p *Proxy) getCacheKey(r *http.Request, route *router.Route, pool *backend.Pool) string {
+	base := cacheBaseKey(r, route, pool)
+	p.cacheMu.RLock()
+	var vary []string
+	if v, ok := p.cacheVary[base]; ok {
+		vary = v.headers
+	}
+	p.cacheMu.RUnlock()
+	return varyCacheKey(base, r, vary)
+}
+
+// cacheBaseKey keys a request on its route, shared by the servers of the
+// pool serving it. A canary release is kept apart from the stable one.
+func cacheBaseKey(r *http.Request, route *router.Route, pool *backend.Pool) string {
+	key := r.Method + ":" + r.URL.RequestURI() + ":" + route.Name
+	if pool != nil && pool == route.CanaryBackend {
+		key += ":canary"
+	}
+	return key
+}
+
+// varyCacheKey adds the values of the request headers named by vary to base
+func varyCacheKey(base string, r *http.Request, vary []string) string {
+	key := base
+	for _, name := range vary {
+		key += "\x00" + name + "=" + strings.Join(r.Header.Values(name), ",")
+	}
+	return key
 }
 
-// CacheResponse caches a response
-func (p *Proxy) CacheResponse(r *http.Request, server *backend.Server, status int, headers http.Header, body []byte, ttl time.Duration) {
-	key := p.getCacheKey(r, server)
+// CacheResponse caches a response from route's primary backend
+func (p *Proxy) CacheResponse(r *http.Request, route *router.Route, status int, headers http.Header, body []byte, ttl time.Duration) {
+	p.cacheResponse(r, route, route.Backend, status, headers, body, ttl, 0)
+}
+
+func (p *Proxy) cacheResponse(r *http.Request, route *router.Route, pool *backend.Pool, status int, headers http.Header, body []byte, ttl, stale time.Duration) {
+	base := cacheBaseKey(r, route, pool)
+	vary := varyHeaders(headers)
 	p.cacheMu.Lock()
 	defer p.cacheMu.Unlock()
 
-	p.cache[key] = &CacheEntry{
+	p.storeCacheEntry(&CacheEntry{
 		Status:  status,
 		Headers: headers,
 		Body:    body,
-		Expires: time.Now().Add(ttl),
-	}
+		Expires: p.now().Add(ttl),
+		key:     varyCacheKey(base, r, vary),
+		base:    base,
+		vary:    vary,
+		uri:     r.URL.RequestURI(),
+		stale:   stale,
+	})
 }
 
 // ClearCache clears the cache
@@ -232,57 +662,125 @@ func (p *Proxy) ClearCache() {
 	p.cacheMu.Lock()
 	defer p.cacheMu.Unlock()
 	p.cache = make(map[string]*CacheEntry)
+	p.cacheVary = make(map[string]*cacheVary)
+	p.cacheOrder.Init()
+	p.cacheBytes = 0
 }
 
-// On registers an event handler
+// On registers an event handler. Handlers run asynchronously on a bounded
+// pool of workers, so they may see events out of order; use OnSync when
+// ordering matters.
 func (p *Proxy) On(eventType string, handler func(Event)) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	if _, ok := p.eventHandlers[eventType]; !ok {
-		p.eventHandlers[eventType] = make([]func(Event), 0)
-	}
+	p.addEventHandler(eventType, eventHandler{fn: handler})
+}
 
-	p.eventHandlers[eventType] = append(p.eventHandlers[eventType], handler)
+// NotifyBackendState emits a backend_state_changed event. It matches the
+// signature expected by backend.WithStateChangeHandler.
+func (p *Proxy) NotifyBackendState(server *backend.Server, healthy bool) {
+	p.emitEvent(Event{
+		Type:      "backend_state_changed",
+		Timestamp: time.Now(),
+		Server:    server,
+		Healthy:   healthy,
+	})
 }
 
-// emitEvent emits an event
-func (p *Proxy) emitEvent(event Event) {
-	p.mu.RLock()
-	handlers := p.eventHandlers[event.Type]
-	p.mu.RUnlock()
+// getClientIP returns the address of the client. X-Forwarded-For and
+// X-Real-IP are only believed when the peer is a trusted proxy; the
+// X-Forwarded-For chain is then walked from the right, skipping trusted
+// hops, so a client can't spoof its address by sending the header itself.
+func (p *Proxy) getClientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !p.isTrustedProxy(peer) {
+		return peer
+	}
 
-	for _, handler := range handlers {
-		go handler(event)
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 {
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			client = hop
+			if !p.isTrustedProxy(hop) {
+				break
+			}
+		}
+		return client
 	}
-}
 
-// getClientIP extracts the client IP from the request
-func (p *Proxy) getClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		if _, err := netip.ParseAddr(xri); err == nil {
+			return xri
 		}
 	}
+	return peer
+}
 
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// SetTrustedProxies sets the addresses, as CIDRs or single IPs, of proxies
+// whose forwarding headers are believed. By default none are trusted.
+func (p *Proxy) SetTrustedProxies(proxies []string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		var prefix netip.Prefix
+		var err error
+		if strings.Contains(proxy, "/") {
+			prefix, err = netip.ParsePrefix(proxy)
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(proxy)
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
 
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trustedNets = prefixes
+	return nil
+}
+
+func (p *Proxy) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
 	if err != nil {
-		return r.RemoteAddr
+		return false
 	}
+	addr = addr.Unmap()
 
-	return host
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, prefix := range p.trustedNets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 // Stats represents proxy statistics
 type Stats struct {
-	RequestCount int64
-	ErrorCount   int64
-	CacheSize    int
+	RequestCount  int64
+	ErrorCount    int64
+	CacheSize     int
+	DroppedEvents int64
+	Routes        map[string]RouteStats
+}
+
+// countError records a request that failed with a proxy error
+func (p *Proxy) countError() {
+	atomic.AddInt64(&p.errorCount, 1)
 }
 
 // GetStats returns proxy statistics
@@ -292,8 +790,10 @@ func (p *Proxy) GetStats() Stats {
 	p.cacheMu.RUnlock()
 
 	return Stats{
-		RequestCount: p.requestCount,
-		ErrorCount:   p.errorCount,
-		CacheSize:    cacheSize,
+		RequestCount:  atomic.LoadInt64(&p.requestCount),
+		ErrorCount:    atomic.LoadInt64(&p.errorCount),
+		CacheSize:     cacheSize,
+		DroppedEvents: p.events.Dropped(),
+		Routes:        p.routeStats.snapshot(),
 	}
 }
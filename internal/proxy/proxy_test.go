@@ -1,11 +1,18 @@
 package proxy
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/middleware"
 	"github.com/surukanti/reverse-proxy/internal/router"
 )
 
@@ -30,21 +37,125 @@ func TestProxyClientIP(t *testing.T) {
 
 func TestProxyClientIPFromHeader(t *testing.T) {
 	p := NewProxy()
+	if err := p.SetTrustedProxies([]string{"192.168.0.0/16"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
 	req, _ := http.NewRequest("GET", "http://localhost/api/test", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
 	req.Header.Set("X-Forwarded-For", "10.0.0.1")
 
 	ip := p.getClientIP(req)
 	if ip != "10.0.0.1" {
 		t.Errorf("expected IP 10.0.0.1, got %s", ip)
 	}
+
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Real-IP", "10.0.0.2")
+	if ip := p.getClientIP(req); ip != "10.0.0.2" {
+		t.Errorf("expected X-Real-IP from a trusted proxy, got %s", ip)
+	}
+}
+
+func TestProxyClientIPIgnoresSpoofedHeaders(t *testing.T) {
+	p := NewProxy()
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/test", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+
+	if ip := p.getClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected headers to be ignored by default, got %s", ip)
+	}
+
+	p.SetTrustedProxies([]string{"192.168.0.0/16"})
+	if ip := p.getClientIP(req); ip != "203.0.113.7" {
+		t.Errorf("expected headers from an untrusted peer to be ignored, got %s", ip)
+	}
+}
+
+func TestProxyClientIPWalksChain(t *testing.T) {
+	p := NewProxy()
+	p.SetTrustedProxies([]string{"192.168.0.0/16", "172.16.0.5"})
+
+	tests := []struct {
+		xff  []string
+		want string
+	}{
+		// The client prepended a fake hop; the first untrusted hop from the
+		// right is what the trusted proxies saw
+		{[]string{"1.1.1.1, 203.0.113.7, 172.16.0.5"}, "203.0.113.7"},
+		{[]string{"1.1.1.1, 203.0.113.7", "172.16.0.5"}, "203.0.113.7"},
+		{[]string{"192.168.5.5, 172.16.0.5"}, "192.168.5.5"},
+		{[]string{"garbage, 172.16.0.5"}, "172.16.0.5"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.RemoteAddr = "192.168.1.1:12345"
+		req.Header["X-Forwarded-For"] = tt.xff
+		if ip := p.getClientIP(req); ip != tt.want {
+			t.Errorf("X-Forwarded-For %v: expected %s, got %s", tt.xff, tt.want, ip)
+		}
+	}
+}
+
+func TestProxySetTrustedProxiesInvalid(t *testing.T) {
+	if err := NewProxy().SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
 }
 
 func TestProxyGetStats(t *testing.T) {
 	p := NewProxy()
 	stats := p.GetStats()
-	if stats.RequestCount < 0 {
-		t.Error("expected valid stats")
+	if stats.RequestCount != 0 || stats.ErrorCount != 0 {
+		t.Errorf("expected zero stats for a new proxy, got %+v", stats)
+	}
+}
+
+func TestProxyGetStatsCountsRequests(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRateLimit(6, time.Minute)
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "ok", PathPrefix: "/ok", Backend: pool})
+
+	downPool := backend.NewPool()
+	downPool.AddServer("http://127.0.0.1:1", 1)
+	p.AddRoute(&router.Route{Name: "down", PathPrefix: "/down", Backend: downPool})
+
+	emptyPool := backend.NewPool()
+	p.AddRoute(&router.Route{Name: "empty", PathPrefix: "/empty", Backend: emptyPool})
+
+	// 3 successes, then no route, upstream error and no backend
+	paths := []string{"/ok", "/ok", "/ok", "/missing", "/down", "/empty"}
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+			p.ServeHTTP(httptest.NewRecorder(), req)
+		}(path)
+	}
+	wg.Wait()
+
+	// The quota is used up, so this one is rate limited
+	req, _ := http.NewRequest("GET", "http://localhost/ok", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	stats := p.GetStats()
+	if stats.RequestCount != int64(len(paths)+1) {
+		t.Errorf("expected %d requests, got %d", len(paths)+1, stats.RequestCount)
+	}
+	if stats.ErrorCount != 4 {
+		t.Errorf("expected 4 errors, got %d", stats.ErrorCount)
 	}
 }
 
@@ -85,3 +196,881 @@ func TestProxyServeHTTPWithRoute(t *testing.T) {
 		t.Error("expected non-zero status code")
 	}
 }
+
+func TestProxyPassiveHealthEjectsFailingServer(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	server, _ := pool.AddServer(mockBackend.URL, 1)
+	pool.SetPassiveHealthCheck(backend.PassiveHealthConfig{MaxFails: 2, RecoveryDelay: time.Hour})
+
+	p.AddRoute(&router.Route{Name: "test", PathPrefix: "/api", Backend: pool})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/test", nil)
+		p.ServeHTTP(w, req)
+	}
+
+	if pool.GetServerHealth(server) {
+		t.Fatal("expected server to be marked unhealthy after upstream 5xx responses")
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/test", nil)
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 once the only server is ejected, got %d", w.Code)
+	}
+}
+
+func TestProxyClientCancelIsNotAServerFailure(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	server, _ := pool.AddServer(mockBackend.URL, 1)
+	pool.SetPassiveHealthCheck(backend.PassiveHealthConfig{MaxFails: 1, RecoveryDelay: time.Hour})
+	p.AddRoute(&router.Route{Name: "test", PathPrefix: "/api", Backend: pool})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://localhost/api/test", nil)
+		p.ServeHTTP(w, req)
+	}
+
+	if !pool.GetServerHealth(server) {
+		t.Fatal("expected canceled client requests not to eject the server")
+	}
+	if failures := pool.ServerStats()[0].Failures; failures != 0 {
+		t.Errorf("expected no failures to be recorded, got %d", failures)
+	}
+}
+
+func TestProxyServerStats(t *testing.T) {
+	fail := false
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "test", PathPrefix: "/api", Backend: pool})
+
+	for i := 0; i < 4; i++ {
+		fail = i == 3
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/test", nil)
+		p.ServeHTTP(w, req)
+	}
+
+	stats := pool.ServerStats()
+	if len(stats) != 1 {
+		t.Fatalf("expected 1 stat, got %d", len(stats))
+	}
+	if stats[0].Requests != 4 || stats[0].Successes != 3 || stats[0].Failures != 1 {
+		t.Errorf("unexpected counters: %+v", stats[0])
+	}
+	if stats[0].LastLatency <= 0 {
+		t.Error("expected last latency to be recorded")
+	}
+}
+
+func TestProxyStickySessions(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	backend1 := newBackend("one")
+	defer backend1.Close()
+	backend2 := newBackend("two")
+	defer backend2.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(backend1.URL, 1)
+	pool.AddServer(backend2.URL, 1)
+	pool.EnableStickySessions("")
+	p.AddRoute(&router.Route{Name: "test", PathPrefix: "/", Backend: pool})
+
+	// First request is assigned a server and pinned by cookie
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(w, req)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != backend.DefaultStickyCookie {
+		t.Fatalf("expected sticky cookie to be set, got %v", cookies)
+	}
+	first := w.Body.String()
+
+	// Follow-up requests stick to the same server
+	for i := 0; i < 5; i++ {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "http://localhost/", nil)
+		req.AddCookie(cookies[0])
+		p.ServeHTTP(w, req)
+		if w.Body.String() != first {
+			t.Fatalf("expected sticky response %q, got %q", first, w.Body.String())
+		}
+	}
+
+	// Pinned server goes down: fall back and re-pin
+	for _, server := range pool.Servers {
+		if server.ID == cookies[0].Value {
+			pool.SetServerHealth(server, false)
+		}
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	req.AddCookie(cookies[0])
+	p.ServeHTTP(w, req)
+
+	if w.Body.String() == first {
+		t.Fatal("expected fallback to the other server")
+	}
+	repinned := w.Result().Cookies()
+	if len(repinned) != 1 || repinned[0].Value == cookies[0].Value {
+		t.Fatalf("expected cookie to be re-pinned, got %v", repinned)
+	}
+}
+
+func TestProxyNotifyBackendState(t *testing.T) {
+	p := NewProxy()
+	pool := backend.NewPool()
+	server, _ := pool.AddServer("http://server1:3000", 1)
+
+	events := make(chan Event, 1)
+	p.On("backend_state_changed", func(event Event) {
+		events <- event
+	})
+
+	p.NotifyBackendState(server, false)
+
+	select {
+	case event := <-events:
+		if event.Server != server || event.Healthy {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected backend_state_changed event")
+	}
+}
+
+func TestProxyRewritesPath(t *testing.T) {
+	var gotPath, gotQuery string
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{
+		Name:       "api",
+		PathPrefix: "/api",
+		Rewrite:    &router.PathRewrite{StripPrefix: "/api"},
+		Backend:    pool,
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/users?page=2", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if gotPath != "/users" {
+		t.Errorf("expected backend path /users, got %s", gotPath)
+	}
+	if gotQuery != "page=2" {
+		t.Errorf("expected query page=2, got %s", gotQuery)
+	}
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+	var gotURI string
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", StripPrefix: true, Backend: pool})
+
+	tests := map[string]string{
+		"/api/users?page=2": "/users?page=2",
+		"/api":              "/",
+	}
+	for path, want := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		p.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+		if gotURI != want {
+			t.Errorf("%s: expected backend URI %s, got %s", path, want, gotURI)
+		}
+	}
+}
+
+func TestProxyCanaryRouting(t *testing.T) {
+	primaryBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primaryBackend.Close()
+	canaryBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("canary"))
+	}))
+	defer canaryBackend.Close()
+
+	p := NewProxy()
+	primary := backend.NewPool()
+	primary.AddServer(primaryBackend.URL, 1)
+	canary := backend.NewPool()
+	canary.AddServer(canaryBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: primary, CanaryBackend: canary, CanaryPercent: 100})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	p.ServeHTTP(w, req)
+
+	if w.Body.String() != "canary" {
+		t.Errorf("expected canary response, got %q", w.Body.String())
+	}
+}
+
+func TestProxyRouteTimeout(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "slow", PathPrefix: "/", Timeout: 50 * time.Millisecond, Backend: pool})
+
+	timeouts := make(chan Event, 1)
+	p.On("proxy_timeout", func(e Event) {
+		timeouts <- e
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	start := time.Now()
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected request to be cut off by the timeout, took %v", elapsed)
+	}
+	select {
+	case <-timeouts:
+	case <-time.After(time.Second):
+		t.Error("expected proxy_timeout event")
+	}
+}
+
+func TestProxyTimeoutMiddleware(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.AddMiddleware(middleware.NewTimeoutMiddleware(50 * time.Millisecond).Handle)
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "slow", PathPrefix: "/", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	start := time.Now()
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected request to be cut off by the timeout, took %v", elapsed)
+	}
+}
+
+func TestProxyRouteMiddlewares(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+
+	auth := middleware.NewAuthMiddleware(func(token string) bool {
+		return token == "secret"
+	})
+	p.AddRoute(&router.Route{Name: "admin", PathPrefix: "/admin", Priority: 10, Backend: pool, Middlewares: []middleware.Handler{auth.Handle}})
+	p.AddRoute(&router.Route{Name: "public", PathPrefix: "/", Backend: pool})
+
+	tests := []struct {
+		path  string
+		token string
+		want  int
+	}{
+		{"/admin", "", http.StatusUnauthorized},
+		{"/admin", "wrong", http.StatusForbidden},
+		{"/admin", "secret", http.StatusOK},
+		{"/public", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost"+tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", tt.token)
+		}
+		p.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s with token %q: expected %d, got %d", tt.path, tt.token, tt.want, w.Code)
+		}
+	}
+}
+
+func TestProxyRedirectRoute(t *testing.T) {
+	p := NewProxy()
+	p.AddRoute(&router.Route{
+		Name:        "old",
+		PathPrefix:  "/old",
+		StripPrefix: true,
+		Redirect:    &router.Redirect{Target: "/new{path}", Status: http.StatusMovedPermanently},
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://example.com/old/page?id=7", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("expected 301, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "http://example.com/new/page?id=7" {
+		t.Errorf("unexpected Location %s", location)
+	}
+}
+
+func TestProxyRateLimitKeyFunc(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRateLimit(2, time.Hour)
+	p.SetRateLimitKeyFunc(func(r *http.Request) string {
+		return r.Header.Get("X-API-Key")
+	})
+
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+
+	send := func(key string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", key)
+		p.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	throttled := false
+	for i := 0; i < 10 && !throttled; i++ {
+		throttled = send("alpha") == http.StatusTooManyRequests
+	}
+	if !throttled {
+		t.Fatal("expected alpha to be throttled")
+	}
+	if code := send("beta"); code != http.StatusOK {
+		t.Errorf("expected beta to have its own bucket, got %d", code)
+	}
+}
+
+func TestProxyRateLimitHeaders(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRateLimit(3, time.Hour)
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		p.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if limit := w.Header().Get("X-RateLimit-Limit"); limit != "3" {
+		t.Errorf("expected limit 3, got %q", limit)
+	}
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "2" {
+		t.Errorf("expected 2 remaining, got %q", remaining)
+	}
+	if reset, _ := strconv.Atoi(w.Header().Get("X-RateLimit-Reset")); reset <= 0 || reset > 3600 {
+		t.Errorf("expected reset within the window, got %d", reset)
+	}
+	if w.Header().Get("Retry-After") != "" {
+		t.Error("expected no Retry-After on allowed responses")
+	}
+
+	send()
+	send()
+	w = send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("expected 0 remaining, got %q", remaining)
+	}
+	if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry <= 0 || retry > 1200 {
+		t.Errorf("expected Retry-After of about 20 minutes, got %d", retry)
+	}
+}
+
+func TestProxyCORSPreflightShortCircuits(t *testing.T) {
+	forwarded := false
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+	cors := middleware.NewCORSMiddlewareWithOptions(middleware.CORSOptions{AllowedOrigins: []string{"*"}, MaxAge: 60})
+	p.AddMiddleware(cors.Handle)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("OPTIONS", "http://localhost/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body, got %q", w.Body.String())
+	}
+	if forwarded {
+		t.Error("expected preflight not to be forwarded")
+	}
+}
+
+func TestProxyBodyLimit(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+	p.AddMiddleware(middleware.NewBodyLimitMiddleware(16).Handle)
+
+	tests := []struct {
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{"small", 5, http.StatusOK},
+		{strings.Repeat("x", 64), 64, http.StatusRequestEntityTooLarge},
+		{strings.Repeat("x", 64), -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://localhost/", io.NopCloser(strings.NewReader(tt.body)))
+		req.ContentLength = tt.contentLength
+		p.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("body of %d bytes (length %d): expected %d, got %d", len(tt.body), tt.contentLength, tt.want, w.Code)
+		}
+	}
+}
+
+func TestProxyForwardsRequestID(t *testing.T) {
+	var upstreamID string
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get(middleware.RequestIDHeader)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+	p.AddMiddleware(middleware.NewRequestIDMiddleware().Handle)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(w, req)
+
+	id := w.Header().Get(middleware.RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a request id on the response")
+	}
+	if upstreamID != id {
+		t.Errorf("expected upstream to receive %q, got %q", id, upstreamID)
+	}
+}
+
+func TestProxySecurityHeadersOnCachedResponse(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected cached response, not a forwarded request")
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	route := &router.Route{Name: "all", PathPrefix: "/", Backend: pool}
+	p.AddRoute(route)
+	p.AddMiddleware(middleware.NewSecurityHeadersMiddleware(middleware.SecurityHeadersOptions{}).Handle)
+
+	req, _ := http.NewRequest("GET", "http://localhost/page", nil)
+	p.CacheResponse(req, route, http.StatusOK, http.Header{"X-Frame-Options": {"ALLOWALL"}}, []byte("cached"), time.Minute)
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("expected a cache hit")
+	}
+	if values := w.Header().Values("X-Frame-Options"); len(values) != 1 || values[0] != "DENY" {
+		t.Errorf("expected X-Frame-Options DENY, got %v", values)
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected nosniff on cached response")
+	}
+}
+
+func TestProxyAfterHook(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("backend response"))
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/api", Backend: pool})
+
+	var infos []middleware.ResponseInfo
+	p.AddAfterHook(func(r *http.Request, resp middleware.ResponseInfo) {
+		infos = append(infos, resp)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("GET", "http://localhost/missing", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(infos) != 2 {
+		t.Fatalf("expected hook to run for each request, got %d", len(infos))
+	}
+	if infos[0].Status != http.StatusOK || infos[0].Bytes != int64(len("backend response")) {
+		t.Errorf("expected 200 with backend body size, got %+v", infos[0])
+	}
+	if infos[0].Duration <= 0 {
+		t.Error("expected a positive duration")
+	}
+	if infos[1].Status != http.StatusNotFound {
+		t.Errorf("expected 404 for unmatched request, got %d", infos[1].Status)
+	}
+}
+
+func TestProxyLoggingDuration(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+
+	var logged []string
+	lm := middleware.NewLoggingMiddleware(func(msg string) {
+		logged = append(logged, msg)
+	})
+	p.AddMiddleware(lm.Handle)
+	p.AddAfterHook(lm.After)
+
+	duration := func(path string) time.Duration {
+		logged = nil
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		if len(logged) != 2 {
+			t.Fatalf("expected request and completion lines, got %v", logged)
+		}
+		d, err := time.ParseDuration(logged[1][strings.LastIndex(logged[1], " ")+1:])
+		if err != nil {
+			t.Fatalf("expected completion line to end with a duration, got %q", logged[1])
+		}
+		return d
+	}
+
+	fast := duration("/fast")
+	slow := duration("/slow")
+	if fast >= 50*time.Millisecond {
+		t.Errorf("expected a small duration for the fast request, got %v", fast)
+	}
+	if slow < 100*time.Millisecond {
+		t.Errorf("expected the slow request to log at least 100ms, got %v", slow)
+	}
+}
+
+func TestProxyHeaderRewrite(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Seen-Env", r.Header.Get("X-Env"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.AddMiddleware(middleware.NewHeaderRewriteMiddleware(middleware.HeaderRewriteOptions{
+		Request:  middleware.HeaderRules{Add: map[string]string{"X-Env": "prod"}},
+		Response: middleware.HeaderRules{Remove: []string{"Server"}},
+	}).Handle)
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "all", PathPrefix: "/", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(w, req)
+
+	if got := w.Header().Get("X-Seen-Env"); got != "prod" {
+		t.Errorf("expected upstream to receive X-Env, got %q", got)
+	}
+	if got := w.Header().Get("Server"); got != "" {
+		t.Errorf("expected upstream Server header to be removed, got %q", got)
+	}
+}
+
+func TestProxyHostHeader(t *testing.T) {
+	hosts := make(chan [2]string, 1)
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- [2]string{r.Host, r.Header.Get("X-Forwarded-Host")}
+	}))
+	defer mockBackend.Close()
+	backendHost := strings.TrimPrefix(mockBackend.URL, "http://")
+
+	newProxy := func(route *router.Route) *Proxy {
+		p := NewProxy()
+		pool := backend.NewPool()
+		pool.AddServer(mockBackend.URL, 1)
+		route.Name, route.PathPrefix, route.Backend = "all", "/", pool
+		p.AddRoute(route)
+		return p
+	}
+	serve := func(p *Proxy) [2]string {
+		req, _ := http.NewRequest("GET", "http://app.example.com/", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		return <-hosts
+	}
+
+	if got := serve(newProxy(&router.Route{})); got != [2]string{backendHost, "app.example.com"} {
+		t.Errorf("expected backend host by default, got %v", got)
+	}
+	if got := serve(newProxy(&router.Route{PreserveHost: true})); got != [2]string{"app.example.com", "app.example.com"} {
+		t.Errorf("expected original host with route PreserveHost, got %v", got)
+	}
+
+	p := newProxy(&router.Route{})
+	p.SetPreserveHost(true)
+	if got := serve(p); got[0] != "app.example.com" {
+		t.Errorf("expected original host with global PreserveHost, got %v", got)
+	}
+}
+
+func TestProxyRouteRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{
+		Name:       "internal",
+		PathPrefix: "/internal",
+		Backend:    pool,
+		RequestHeaders: map[string]string{
+			"X-Internal-Token": "secret",
+			"X-Tenant":         "acme",
+		},
+	})
+	p.AddRoute(&router.Route{Name: "public", PathPrefix: "/public", Backend: pool})
+
+	req, _ := http.NewRequest("GET", "http://localhost/internal/item", nil)
+	req.Header.Set("X-Tenant", "spoofed")
+	req.RemoteAddr = "10.0.0.1:1234"
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	got := <-headers
+
+	if got.Get("X-Internal-Token") != "secret" || got.Get("X-Tenant") != "acme" {
+		t.Errorf("expected the route's headers, got token %q tenant %q", got.Get("X-Internal-Token"), got.Get("X-Tenant"))
+	}
+	if !strings.HasPrefix(got.Get("X-Forwarded-For"), "10.0.0.1") {
+		t.Errorf("expected X-Forwarded-For to be kept, got %q", got.Get("X-Forwarded-For"))
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/public/item", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if got := <-headers; got.Get("X-Internal-Token") != "" {
+		t.Errorf("expected other routes not to get the headers, got %q", got.Get("X-Internal-Token"))
+	}
+}
+
+func TestProxyRouteRequestHeadersOverrideForwarded(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{
+		Name:           "all",
+		PathPrefix:     "/",
+		Backend:        pool,
+		RequestHeaders: map[string]string{"X-Forwarded-Proto": "https"},
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if got := <-headers; got.Get("X-Forwarded-Proto") != "https" {
+		t.Errorf("expected an explicitly configured X-Forwarded-Proto to win, got %q", got.Get("X-Forwarded-Proto"))
+	}
+}
+
+func TestProxyBackendTransport(t *testing.T) {
+	mockBackend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "tls", PathPrefix: "/", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("expected untrusted backend certificate to fail, got %d", w.Code)
+	}
+
+	pool.SetTransport(mockBackend.Client().Transport)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "http://localhost/", nil)
+	p.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "secure" {
+		t.Errorf("expected the pool's transport to be used, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyRecordsUpstreamDuration(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// The body is still being written, which the timing must include
+		time.Sleep(delay)
+		w.Write([]byte("done"))
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+	var completed Event
+	p.OnSync("request_completed", func(e Event) { completed = e })
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/slow", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if completed.Status != http.StatusOK || completed.Server == nil {
+		t.Fatalf("expected a completed event with status and server, got %+v", completed)
+	}
+	if completed.UpstreamDuration < delay {
+		t.Errorf("expected upstream duration of at least %v, got %v", delay, completed.UpstreamDuration)
+	}
+	if completed.Duration < completed.UpstreamDuration {
+		t.Errorf("expected total duration %v to include upstream %v", completed.Duration, completed.UpstreamDuration)
+	}
+	if latency := pool.ServerStats()[0].LastLatency; latency < delay {
+		t.Errorf("expected recorded latency of at least %v, got %v", delay, latency)
+	}
+}
@@ -0,0 +1,361 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/config"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+// managedBackend is a pool built from a backend config, along with the
+// health checker probing it
+type managedBackend struct {
+	cfg    config.BackendConfig
+	pool   *backend.Pool
+	health *backend.HealthChecker
+}
+
+func (b *managedBackend) stop() {
+	if b.health != nil {
+		b.health.Stop()
+	}
+}
+
+// Reload applies the backends, routes and proxy policies (retry, cache,
+// rate limit, response rules and circuit breakers) of cfg. Everything is validated
+// before anything changes, so an invalid config leaves the proxy as it was.
+// Backends whose config is unchanged keep their pool, health state and
+// statistics; changed ones are rebuilt and their health checks restarted.
+// Requests already in flight finish against the routes and pools they
+// started with.
+//
+// Middleware policies and listener settings are not reloaded.
+func (p *Proxy) Reload(cfg *config.Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	backends := make(map[string]*managedBackend, len(cfg.Backends))
+	var created []*managedBackend
+	for _, backendCfg := range cfg.Backends {
+		if _, ok := backends[backendCfg.ID]; ok {
+			return fmt.Errorf("duplicate backend %s", backendCfg.ID)
+		}
+		if old, ok := p.backends[backendCfg.ID]; ok && reflect.DeepEqual(old.cfg, backendCfg) {
+			backends[backendCfg.ID] = old
+			continue
+		}
+		b, err := p.newBackend(backendCfg)
+		if err != nil {
+			return fmt.Errorf("backend %s: %w", backendCfg.ID, err)
+		}
+		backends[backendCfg.ID] = b
+		created = append(created, b)
+	}
+
+	routes := make([]*router.Route, 0, len(cfg.Routes))
+	for _, routeCfg := range cfg.Routes {
+		route, err := newRoute(routeCfg, backends)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", routeCfg.Name, err)
+		}
+		routes = append(routes, route)
+	}
+
+	policies, err := newPolicies(cfg.Policies)
+	if err != nil {
+		return err
+	}
+
+	if err := p.router.SetRoutes(routes); err != nil {
+		return err
+	}
+	p.SetRetryPolicy(policies.retry)
+	p.SetCachePolicy(policies.cache)
+	p.SetResponseRules(policies.response)
+	p.SetBreakerPolicy(policies.breaker)
+	// Clients keep the requests they have used under the new limit
+	p.rateLimiter.SetLimit(policies.rateLimit, policies.rateLimitWindow)
+
+	for id, old := range p.backends {
+		if backends[id] != old {
+			old.stop()
+		}
+	}
+	for _, b := range created {
+		if b.health != nil {
+			b.health.Start(context.Background())
+		}
+	}
+	p.backends = backends
+	return nil
+}
+
+// newBackend builds a pool and its health checker from config; the health
+// checker is not started
+func (p *Proxy) newBackend(cfg config.BackendConfig) (*managedBackend, error) {
+	pool := backend.NewPool()
+	for serverURL, weight := range cfg.Weights {
+		if !slices.Contains(cfg.Servers, serverURL) {
+			return nil, fmt.Errorf("weight for unknown server %s", serverURL)
+		}
+		if weight < 1 {
+			return nil, fmt.Errorf("invalid weight %d for server %s", weight, serverURL)
+		}
+	}
+	for _, serverURL := range cfg.Servers {
+		weight := 1
+		if w, ok := cfg.Weights[serverURL]; ok {
+			weight = w
+		}
+		server, err := pool.AddServer(serverURL, int32(weight))
+		if err != nil {
+			return nil, err
+		}
+		if maxConns, ok := cfg.MaxConns[serverURL]; ok {
+			server.MaxConns = int32(maxConns)
+		}
+	}
+
+	strategy, err := backend.ParseStrategy(cfg.LoadBalancing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid load_balancing: %w", err)
+	}
+	pool.SetStrategy(strategy)
+
+	if cfg.StickyCookie != "" {
+		pool.EnableStickySessions(cfg.StickyCookie)
+	}
+
+	// Must be set before the health checker is created so it uses it too
+	if tlsCfg := cfg.TLS; tlsCfg != nil {
+		transport, err := backend.NewTransport(backend.TLSConfig{
+			CAFile:             tlsCfg.CAFile,
+			CertFile:           tlsCfg.CertFile,
+			KeyFile:            tlsCfg.KeyFile,
+			ServerName:         tlsCfg.ServerName,
+			InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls: %w", err)
+		}
+		pool.SetTransport(transport)
+	}
+
+	if cfg.RetryAttempts > 0 {
+		pool.SetRetryAttempts(cfg.RetryAttempts)
+	}
+
+	if cfg.SlowStart != "" {
+		slowStart, err := time.ParseDuration(cfg.SlowStart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slow_start: %w", err)
+		}
+		pool.SetSlowStart(slowStart)
+	}
+
+	b := &managedBackend{cfg: cfg, pool: pool}
+	if hcCfg := cfg.HealthCheck; hcCfg.Enabled {
+		interval := 30 * time.Second
+		timeout := 5 * time.Second
+		var err error
+		if hcCfg.Interval != "" {
+			if interval, err = time.ParseDuration(hcCfg.Interval); err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid health check interval '%s'", hcCfg.Interval)
+			}
+		}
+		if hcCfg.Timeout != "" {
+			if timeout, err = time.ParseDuration(hcCfg.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid health check timeout '%s'", hcCfg.Timeout)
+			}
+		}
+		opts := []backend.HealthCheckOption{backend.WithStateChangeHandler(p.NotifyBackendState)}
+		switch checkType := backend.HealthCheckType(hcCfg.Type); checkType {
+		case "":
+		case backend.HealthCheckHTTP, backend.HealthCheckTCP:
+			opts = append(opts, backend.WithCheckType(checkType))
+		default:
+			return nil, fmt.Errorf("invalid health check type '%s'", checkType)
+		}
+		if hcCfg.Method != "" {
+			opts = append(opts, backend.WithMethod(hcCfg.Method))
+		}
+		if len(hcCfg.Headers) > 0 {
+			opts = append(opts, backend.WithHeaders(hcCfg.Headers))
+		}
+		if hcCfg.Jitter > 0 {
+			opts = append(opts, backend.WithJitter(hcCfg.Jitter, nil))
+		}
+		if hcCfg.ExpectedBody != "" {
+			opts = append(opts, backend.WithExpectedBody(hcCfg.ExpectedBody))
+		}
+		if hcCfg.ExpectedBodyRegex != "" {
+			re, err := regexp.Compile(hcCfg.ExpectedBodyRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expected_body_regex: %w", err)
+			}
+			opts = append(opts, backend.WithExpectedBodyRegex(re))
+		}
+		b.health = backend.NewHealthChecker(pool, interval, timeout, hcCfg.Path, opts...)
+	}
+
+	if passiveCfg := cfg.HealthCheck.Passive; passiveCfg.MaxFails > 0 {
+		passive := backend.PassiveHealthConfig{MaxFails: passiveCfg.MaxFails}
+		var err error
+		if passiveCfg.FailWindow != "" {
+			if passive.FailWindow, err = time.ParseDuration(passiveCfg.FailWindow); err != nil {
+				return nil, fmt.Errorf("invalid fail_window: %w", err)
+			}
+		}
+		if passiveCfg.RecoveryDelay != "" {
+			if passive.RecoveryDelay, err = time.ParseDuration(passiveCfg.RecoveryDelay); err != nil {
+				return nil, fmt.Errorf("invalid recovery_delay: %w", err)
+			}
+		}
+		if b.health != nil {
+			passive.Probe = b.health.Probe
+		}
+		pool.SetPassiveHealthCheck(passive)
+	}
+
+	return b, nil
+}
+
+// newRoute builds a route from config, resolving its backends by ID
+func newRoute(cfg config.RouteConfig, backends map[string]*managedBackend) (*router.Route, error) {
+	route := &router.Route{
+		Name:            cfg.Name,
+		Pattern:         cfg.Pattern,
+		Glob:            cfg.Glob,
+		PathPrefix:      cfg.PathPrefix,
+		StripPrefix:     cfg.StripPrefix,
+		Host:            cfg.Host,
+		Subdomain:       cfg.Subdomain,
+		Headers:         cfg.Headers,
+		HeadersNot:      cfg.HeadersNot,
+		HeadersRegex:    cfg.HeadersRegex,
+		Query:           cfg.Query,
+		Methods:         cfg.Methods,
+		Priority:        cfg.Priority,
+		CaseInsensitive: cfg.CaseInsensitive,
+		PreserveHost:    cfg.PreserveHost,
+		RequestHeaders:  cfg.RequestHeaders,
+		Disabled:        cfg.Enabled != nil && !*cfg.Enabled,
+	}
+
+	if b, ok := backends[cfg.BackendID]; ok {
+		route.Backend = b.pool
+	} else if cfg.Redirect == nil {
+		return nil, fmt.Errorf("backend %s not found", cfg.BackendID)
+	}
+	if cfg.CanaryBackendID != "" {
+		canary, ok := backends[cfg.CanaryBackendID]
+		if !ok {
+			return nil, fmt.Errorf("canary backend %s not found", cfg.CanaryBackendID)
+		}
+		route.CanaryBackend = canary.pool
+		route.CanaryPercent = cfg.CanaryPercent
+	}
+
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		route.Timeout = timeout
+	}
+	if rw := cfg.Rewrite; rw != nil {
+		route.Rewrite = &router.PathRewrite{
+			StripPrefix: rw.StripPrefix,
+			Pattern:     rw.Pattern,
+			Replacement: rw.Replacement,
+		}
+	}
+	if rd := cfg.Redirect; rd != nil {
+		route.Redirect = &router.Redirect{Target: rd.Target, Status: rd.Status}
+	}
+	return route, nil
+}
+
+// reloadablePolicies are the proxy policies Reload applies
+type reloadablePolicies struct {
+	retry           RetryPolicy
+	cache           CachePolicy
+	response        ResponseRules
+	breaker         BreakerPolicy
+	rateLimit       int
+	rateLimitWindow time.Duration
+}
+
+func newPolicies(cfg config.PoliciesConfig) (reloadablePolicies, error) {
+	var policies reloadablePolicies
+	var err error
+
+	if cache := cfg.Cache; cache.Enabled {
+		ttl, err := time.ParseDuration(cache.TTL)
+		if err != nil || ttl <= 0 {
+			return policies, fmt.Errorf("invalid cache ttl '%s'", cache.TTL)
+		}
+		policies.cache = CachePolicy{
+			TTL:        ttl,
+			Methods:    cache.Methods,
+			MaxEntries: cache.MaxEntries,
+			MaxBytes:   cache.MaxBytes,
+		}
+		if cache.StaleWhileRevalidate != "" {
+			if policies.cache.StaleWhileRevalidate, err = time.ParseDuration(cache.StaleWhileRevalidate); err != nil {
+				return policies, fmt.Errorf("invalid cache stale_while_revalidate '%s': %w", cache.StaleWhileRevalidate, err)
+			}
+		}
+	}
+
+	policies.rateLimit, policies.rateLimitWindow = defaultRateLimit, defaultRateLimitWindow
+	if limit := cfg.RateLimit; limit.Enabled {
+		window, err := time.ParseDuration(limit.Window)
+		if err != nil || window <= 0 {
+			return policies, fmt.Errorf("invalid rate_limit window '%s'", limit.Window)
+		}
+		if limit.MaxRequests <= 0 {
+			return policies, fmt.Errorf("invalid rate_limit max_requests %d", limit.MaxRequests)
+		}
+		policies.rateLimit, policies.rateLimitWindow = limit.MaxRequests, window
+	}
+
+	if retry := cfg.Retry; retry.Enabled {
+		policies.retry = RetryPolicy{Attempts: retry.Attempts, Methods: retry.Methods}
+		if retry.Backoff != "" {
+			if policies.retry.Backoff, err = time.ParseDuration(retry.Backoff); err != nil {
+				return policies, fmt.Errorf("invalid retry backoff '%s': %w", retry.Backoff, err)
+			}
+		}
+	}
+
+	if cb := cfg.CircuitBreaker; cb.Enabled {
+		if cb.FailureThreshold <= 0 {
+			return policies, fmt.Errorf("invalid circuit_breaker failure_threshold %d", cb.FailureThreshold)
+		}
+		policies.breaker = BreakerPolicy{
+			FailureThreshold: cb.FailureThreshold,
+			SuccessThreshold: cb.SuccessThreshold,
+			HalfOpenRequests: cb.HalfOpenRequests,
+			Timeout:          30 * time.Second,
+		}
+		if cb.Timeout != "" {
+			if policies.breaker.Timeout, err = time.ParseDuration(cb.Timeout); err != nil {
+				return policies, fmt.Errorf("invalid circuit_breaker timeout '%s': %w", cb.Timeout, err)
+			}
+		}
+	}
+
+	policies.response = ResponseRules{
+		Remove:          cfg.Response.Remove,
+		Set:             cfg.Response.Set,
+		RewriteLocation: cfg.Response.RewriteLocation,
+	}
+	return policies, nil
+}
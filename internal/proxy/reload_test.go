@@ -0,0 +1,366 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/config"
+)
+
+func newNamedBackend(t *testing.T, name string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func serveBody(p *Proxy, path string) (int, string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+	p.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestProxyReload(t *testing.T) {
+	urlA, urlB := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	cfg := &config.Config{
+		Backends: []config.BackendConfig{
+			{ID: "a", Servers: []string{urlA}},
+			{ID: "b", Servers: []string{urlB}},
+		},
+		Routes: []config.RouteConfig{
+			{Name: "a", PathPrefix: "/a", BackendID: "a"},
+			{Name: "b", PathPrefix: "/b", BackendID: "b"},
+		},
+	}
+
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("initial load failed: %v", err)
+	}
+	if code, body := serveBody(p, "/b/x"); code != http.StatusOK || body != "b" {
+		t.Fatalf("expected /b to reach backend b, got %d %q", code, body)
+	}
+	poolA := p.Router().GetRoute("a").Backend
+
+	cfg = &config.Config{
+		Backends: []config.BackendConfig{
+			{ID: "a", Servers: []string{urlA}},
+			{ID: "b", Servers: []string{urlB}},
+		},
+		Routes: []config.RouteConfig{
+			{Name: "a", PathPrefix: "/a", BackendID: "a"},
+			{Name: "c", PathPrefix: "/c", BackendID: "b"},
+		},
+	}
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	if code, _ := serveBody(p, "/b/x"); code != http.StatusNotFound {
+		t.Errorf("expected removed route to stop matching, got %d", code)
+	}
+	if code, body := serveBody(p, "/c/x"); code != http.StatusOK || body != "b" {
+		t.Errorf("expected new route to reach backend b, got %d %q", code, body)
+	}
+	if p.Router().GetRoute("a").Backend != poolA {
+		t.Error("expected an unchanged backend to keep its pool")
+	}
+}
+
+func TestProxyReloadChangedBackend(t *testing.T) {
+	urlA, urlB := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	p := NewProxy()
+	load := func(serverURL string) {
+		t.Helper()
+		err := p.Reload(&config.Config{
+			Backends: []config.BackendConfig{{ID: "api", Servers: []string{serverURL}}},
+			Routes:   []config.RouteConfig{{Name: "api", PathPrefix: "/", BackendID: "api"}},
+		})
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+	}
+
+	load(urlA)
+	if _, body := serveBody(p, "/"); body != "a" {
+		t.Fatalf("expected backend a, got %q", body)
+	}
+	load(urlB)
+	if _, body := serveBody(p, "/"); body != "b" {
+		t.Errorf("expected the changed backend's server, got %q", body)
+	}
+}
+
+func TestProxyReloadInvalidKeepsRunningConfig(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	p := NewProxy()
+	err := p.Reload(&config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/a", BackendID: "a"}},
+	})
+	if err != nil {
+		t.Fatalf("initial load failed: %v", err)
+	}
+
+	invalid := []*config.Config{
+		{Routes: []config.RouteConfig{{Name: "x", PathPrefix: "/x", BackendID: "missing"}}},
+		{
+			Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}, SlowStart: "soon"}},
+			Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/a", BackendID: "a"}},
+		},
+		{
+			Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+			Routes:   []config.RouteConfig{{Name: "a", Pattern: "([", BackendID: "a"}},
+		},
+		{
+			Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+			Policies: config.PoliciesConfig{Cache: config.CachePolicy{Enabled: true, TTL: "forever"}},
+		},
+	}
+	for i, cfg := range invalid {
+		if err := p.Reload(cfg); err == nil {
+			t.Errorf("config %d: expected an error", i)
+		}
+		if code, body := serveBody(p, "/a/x"); code != http.StatusOK || body != "a" {
+			t.Errorf("config %d: expected the running config to keep serving, got %d %q", i, code, body)
+		}
+	}
+}
+
+func TestProxyReloadHealthCheckTiming(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := fmt.Sprintf(`
+backends:
+  - id: a
+    servers: [%s]
+    health_check:
+      enabled: true
+      interval: 10s
+      timeout: 2s
+      path: /
+`, urlA)
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadFromYAML(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	t.Cleanup(func() { p.Reload(&config.Config{}) })
+	health := p.backends["a"].health
+	if health.Interval() != 10*time.Second || health.Timeout() != 2*time.Second {
+		t.Errorf("expected a 10s interval and 2s timeout, got %v and %v", health.Interval(), health.Timeout())
+	}
+
+	for _, hc := range []config.HealthConfig{
+		{Enabled: true, Interval: "often"},
+		{Enabled: true, Interval: "-1s"},
+		{Enabled: true, Timeout: "quick"},
+	} {
+		cfg.Backends[0].HealthCheck = hc
+		if err := p.Reload(cfg); err == nil {
+			t.Errorf("expected an error for interval %q and timeout %q", hc.Interval, hc.Timeout)
+		}
+	}
+}
+
+func TestProxyReloadPolicies(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	cfg := &config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+		Policies: config.PoliciesConfig{
+			Cache: config.CachePolicy{Enabled: true, TTL: "1m"},
+			Retry: config.RetryPolicy{Enabled: true, Attempts: 3},
+		},
+	}
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	serveBody(p, "/x")
+	if w := doRequest(p, "GET", "/x", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Error("expected the cache policy to be applied")
+	}
+	if p.retry.Attempts != 3 {
+		t.Errorf("expected the retry policy to be applied, got %+v", p.retry)
+	}
+
+	cfg.Policies = config.PoliciesConfig{}
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if w := doRequest(p, "GET", "/y", nil); w.Header().Get("X-Cache") != "" {
+		t.Error("expected caching to be disabled after reload")
+	}
+}
+
+func TestProxyReloadRateLimit(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	cfg := &config.Config{
+		Backends: []config.BackendConfig{{ID: "a", Servers: []string{urlA}}},
+		Routes:   []config.RouteConfig{{Name: "a", PathPrefix: "/", BackendID: "a"}},
+		Policies: config.PoliciesConfig{
+			RateLimit: config.RateLimitPolicy{Enabled: true, MaxRequests: 2, Window: "1m"},
+		},
+	}
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		serveBody(p, "/")
+	}
+	if code, _ := serveBody(p, "/"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the rate limit to be applied, got %d", code)
+	}
+
+	cfg.Policies.RateLimit.MaxRequests = 5
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if code, _ := serveBody(p, "/"); code != http.StatusOK {
+		t.Errorf("expected a raised limit to allow more requests, got %d", code)
+	}
+
+	cfg.Policies.RateLimit.Window = "soon"
+	if err := p.Reload(cfg); err == nil {
+		t.Error("expected an invalid rate limit window to fail to reload")
+	}
+}
+
+func TestProxyReloadUnderLoad(t *testing.T) {
+	urlA, urlB := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	configs := []*config.Config{
+		{
+			Backends: []config.BackendConfig{{ID: "api", Servers: []string{urlA}}},
+			Routes:   []config.RouteConfig{{Name: "api", PathPrefix: "/", BackendID: "api"}},
+		},
+		{
+			Backends: []config.BackendConfig{{ID: "api", Servers: []string{urlB}}},
+			Routes:   []config.RouteConfig{{Name: "api", PathPrefix: "/", BackendID: "api"}},
+		},
+	}
+	p := NewProxy()
+	if err := p.Reload(configs[0]); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if code, body := serveBody(p, "/"); code != http.StatusOK || (body != "a" && body != "b") {
+					t.Errorf("expected every request to be served during reloads, got %d %q", code, body)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := p.Reload(configs[i%2]); err != nil {
+			t.Errorf("reload failed: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestProxyReloadLoadBalancing(t *testing.T) {
+	yaml := `
+backends:
+  - id: api
+    servers:
+      - http://localhost:3000
+      - http://localhost:3001
+    load_balancing: least_connections
+routes:
+  - name: api
+    path_prefix: /
+    backend_id: api
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromYAML(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if strategy := p.Router().GetRoute("api").Backend.Strategy(); strategy != backend.StrategyLeastConnections {
+		t.Errorf("expected least connections, got %v", strategy)
+	}
+
+	cfg.Backends[0].LoadBalancing = "fastest"
+	if err := p.Reload(cfg); err == nil {
+		t.Error("expected error for unknown load_balancing")
+	}
+}
+
+func TestProxyReloadWeights(t *testing.T) {
+	yaml := `
+backends:
+  - id: api
+    servers:
+      - http://localhost:3000
+      - http://localhost:3001
+    weights:
+      http://localhost:3000: 5
+routes:
+  - name: api
+    path_prefix: /
+    backend_id: api
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromYAML(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	p := NewProxy()
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	servers := p.Router().GetRoute("api").Backend.Servers
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].Weight != 5 || servers[1].Weight != 1 {
+		t.Errorf("expected weights 5 and 1, got %d and %d", servers[0].Weight, servers[1].Weight)
+	}
+
+	for _, weights := range []map[string]int{
+		{"http://localhost:3000": 0},
+		{"http://localhost:4000": 2},
+	} {
+		cfg.Backends[0].Weights = weights
+		if err := p.Reload(cfg); err == nil {
+			t.Errorf("expected error for weights %v", weights)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/advanced"
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+// errRetryStatus marks a 5xx response that will be retried rather than
+// returned to the client
+var errRetryStatus = errors.New("retryable upstream status")
+
+// RetryPolicy controls retrying failed upstream requests, preferring a
+// server that has not been tried yet. Requests whose method may be retried
+// are retried on a connection error or 5xx response; any request is retried
+// when the backend couldn't be reached at all, since it never saw it.
+type RetryPolicy struct {
+	Attempts int           // total attempts including the first; 0 or 1 disables retries
+	Backoff  time.Duration // wait before a retry, multiplied by the retry number
+	Methods  []string      // methods that may be retried; defaults to idempotent ones
+}
+
+// retryMode says which failures of an attempt may be retried
+type retryMode int
+
+const (
+	retryNone    retryMode = iota
+	retryConnect           // only failures to connect, before the request was sent
+	retryAll               // any connection error or 5xx response
+)
+
+var defaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions}
+
+// SetRetryPolicy sets the retry policy for upstream requests
+func (p *Proxy) SetRetryPolicy(policy RetryPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retry = policy
+}
+
+func (rp RetryPolicy) allows(method string) bool {
+	methods := rp.Methods
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardWithRetry forwards the request, retrying it as the retry policy
+// allows. The body is buffered so it can be replayed. server must hold a
+// request slot reserved by acquireServer, which is released. It returns the
+// server that was last tried, or nil if none was.
+func (p *Proxy) forwardWithRetry(w http.ResponseWriter, r *http.Request, route *router.Route, pool *backend.Pool, server *backend.Server) *backend.Server {
+	p.mu.RLock()
+	policy := p.retry
+	p.mu.RUnlock()
+
+	attempts := policy.Attempts
+	if n := pool.RetryAttempts(); n > 0 {
+		attempts = n
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	mode := retryConnect
+	if policy.allows(r.Method) {
+		mode = retryAll
+	}
+
+	var body []byte
+	if attempts > 1 && r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			server.Release()
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				p.writeError(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			} else {
+				p.writeError(w, "Bad Request", http.StatusBadRequest)
+			}
+			return nil
+		}
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	tried := make([]*backend.Server, 0, attempts)
+	for attempt := 1; ; attempt++ {
+		if body != nil {
+			r.Body, _ = r.GetBody()
+		}
+
+		attemptMode := mode
+		if attempt >= attempts {
+			attemptMode = retryNone
+		}
+		retry := p.forwardThroughBreaker(w, r, route, pool, server, attemptMode)
+		server.Release()
+		if !retry {
+			return server
+		}
+
+		tried = append(tried, server)
+		next := retryServer(pool, tried, p.breakerOpen)
+		if next == nil {
+			p.countError()
+			p.emitEvent(Event{
+				Type:      "no_backend_available",
+				Timestamp: time.Now(),
+				Request:   r,
+			})
+			p.writeError(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return server
+		}
+		server = next
+
+		p.emitEvent(Event{
+			Type:      "request_retried",
+			Timestamp: time.Now(),
+			Request:   r,
+			Server:    server,
+		})
+		if policy.Backoff > 0 {
+			time.Sleep(time.Duration(attempt) * policy.Backoff)
+		}
+	}
+}
+
+// forwardThroughBreaker makes one attempt through server's circuit breaker,
+// if breakers are enabled. An attempt rejected by an open breaker never
+// reached the server, so it is always retried.
+func (p *Proxy) forwardThroughBreaker(w http.ResponseWriter, r *http.Request, route *router.Route, pool *backend.Pool, server *backend.Server, mode retryMode) (retry bool) {
+	cb := p.breaker(server)
+	if cb == nil {
+		retry, _ = p.forwardRequest(w, r, route, pool, server, mode)
+		return retry
+	}
+	err := cb.Call(func() error {
+		var err error
+		retry, err = p.forwardRequest(w, r, route, pool, server, mode)
+		return err
+	})
+	return retry || errors.Is(err, advanced.ErrCircuitOpen)
+}
+
+// retryServer picks a server for the next attempt and reserves a request
+// slot on it, preferring one that has not been tried; if every available
+// server has been tried, any available one is used. Servers for which skip
+// returns true are never picked.
+func retryServer(pool *backend.Pool, tried []*backend.Server, skip func(*backend.Server) bool) *backend.Server {
+	var fallback *backend.Server
+	for i := pool.Status().Healthy; i > 0; i-- {
+		server := pool.GetServer()
+		if server == nil {
+			break
+		}
+		if skip(server) || (fallback != nil && containsServer(tried, server)) {
+			continue
+		}
+		if !server.TryAcquire() {
+			continue
+		}
+		if !containsServer(tried, server) {
+			if fallback != nil {
+				fallback.Release()
+			}
+			return server
+		}
+		fallback = server
+	}
+	return fallback
+}
+
+// isConnectError reports whether err means the backend was never reached
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func containsServer(servers []*backend.Server, server *backend.Server) bool {
+	for _, s := range servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestProxyRetriesFlakyBackend(t *testing.T) {
+	var calls int32
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "http://localhost/api/item", strings.NewReader("payload"))
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected retry to succeed with 200, got %d", w.Code)
+	}
+	if w.Body.String() != "payload" {
+		t.Errorf("expected body to be replayed, got %q", w.Body.String())
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 upstream attempts, got %d", n)
+	}
+}
+
+func TestProxyDoesNotRetryPost(t *testing.T) {
+	var calls int32
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRetryPolicy(RetryPolicy{Attempts: 3})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://localhost/api/item", strings.NewReader("payload"))
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected upstream 500 to be returned, got %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected POST not to be retried, got %d attempts", n)
+	}
+}
+
+func TestProxyRetryExhausted(t *testing.T) {
+	var calls int32
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetRetryPolicy(RetryPolicy{Attempts: 2})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/item", nil)
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected last upstream status, got %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestProxyFailsOverToHealthyServer(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "healthy")
+	}))
+	defer healthy.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	pool.AddServer(healthy.URL, 1)
+	pool.SetRetryAttempts(2)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost/api/item", nil)
+		p.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != "healthy" {
+			t.Fatalf("request %d: expected 200 from the healthy server, got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestProxyFailsOverPostOnConnectError(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer healthy.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(deadURL, 1)
+	pool.AddServer(healthy.URL, 1)
+	pool.SetRetryAttempts(2)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://localhost/api/item", strings.NewReader("payload"))
+		p.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != "payload" {
+			t.Fatalf("request %d: expected POST to fail over with its body, got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestProxyDoesNotFailOverPost5xx(t *testing.T) {
+	var calls int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(failing.URL, 1)
+	pool.AddServer(failing.URL, 1)
+	pool.SetRetryAttempts(2)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://localhost/api/item", strings.NewReader("payload"))
+	p.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected upstream 500 to be returned, got %d", w.Code)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected a POST that reached the backend not to be retried, got %d attempts", n)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+)
+
+// ResponseRules edit backend responses before they are cached or sent to
+// the client
+type ResponseRules struct {
+	Remove          []string          // header names to drop; a trailing * matches any suffix
+	Set             map[string]string // headers to replace
+	RewriteLocation bool              // point redirects at the backend back to the proxy
+}
+
+func (rr ResponseRules) empty() bool {
+	return len(rr.Remove) == 0 && len(rr.Set) == 0 && !rr.RewriteLocation
+}
+
+// SetResponseRules sets the rules applied to every backend response
+func (p *Proxy) SetResponseRules(rules ResponseRules) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responseRules = rules
+}
+
+// modifyResponse applies the response rules to a backend response for r
+func (p *Proxy) modifyResponse(resp *http.Response, r *http.Request, server *backend.Server) {
+	p.mu.RLock()
+	rules := p.responseRules
+	p.mu.RUnlock()
+	if rules.empty() {
+		return
+	}
+
+	for _, name := range rules.Remove {
+		prefix, ok := strings.CutSuffix(name, "*")
+		if !ok {
+			resp.Header.Del(name)
+			continue
+		}
+		prefix = http.CanonicalHeaderKey(prefix)
+		for key := range resp.Header {
+			if strings.HasPrefix(key, prefix) {
+				delete(resp.Header, key)
+			}
+		}
+	}
+	for key, value := range rules.Set {
+		resp.Header.Set(key, value)
+	}
+	if rules.RewriteLocation {
+		if location := rewriteLocation(resp.Header.Get("Location"), r, server.URL); location != "" {
+			resp.Header.Set("Location", location)
+		}
+	}
+}
+
+// rewriteLocation maps an absolute redirect to the backend onto the host
+// and scheme the client used. It returns "" if location needs no change.
+func rewriteLocation(location string, r *http.Request, backendURL *url.URL) string {
+	if location == "" {
+		return ""
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, backendURL.Host) {
+		return ""
+	}
+
+	u.Scheme = "http"
+	if r.TLS != nil {
+		u.Scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	u.Host = r.Host
+	return u.String()
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestProxyRewritesLocation(t *testing.T) {
+	var backendURL string
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/old":
+			http.Redirect(w, r, backendURL+"/api/new?x=1", http.StatusFound)
+		case "/api/elsewhere":
+			http.Redirect(w, r, "https://example.com/login", http.StatusFound)
+		default:
+			http.Redirect(w, r, "/api/relative", http.StatusFound)
+		}
+	}))
+	defer mockBackend.Close()
+	backendURL = mockBackend.URL
+
+	p := NewProxy()
+	p.SetResponseRules(ResponseRules{RewriteLocation: true})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	tests := []struct {
+		path, proto, want string
+	}{
+		{"/api/old", "", "http://proxy.example.com/api/new?x=1"},
+		{"/api/old", "https", "https://proxy.example.com/api/new?x=1"},
+		{"/api/elsewhere", "", "https://example.com/login"},
+		{"/api/other", "", "/api/relative"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://proxy.example.com"+tt.path, nil)
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		p.ServeHTTP(w, req)
+
+		if w.Code != http.StatusFound {
+			t.Fatalf("%s: expected 302, got %d", tt.path, w.Code)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: expected Location %q, got %q", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestProxyStripsResponseHeaders(t *testing.T) {
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Server", "app-3")
+		w.Header().Set("X-Backend-Version", "1.2.3")
+		w.Header().Set("Server", "internal")
+		w.Header().Set("X-Public", "yes")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetResponseRules(ResponseRules{
+		Remove: []string{"x-backend-*", "Server"},
+		Set:    map[string]string{"Server": "proxy"},
+	})
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost/api/item", nil)
+	p.ServeHTTP(w, req)
+
+	for _, name := range []string{"X-Backend-Server", "X-Backend-Version"} {
+		if v := w.Header().Get(name); v != "" {
+			t.Errorf("expected %s to be stripped, got %q", name, v)
+		}
+	}
+	if v := w.Header().Get("Server"); v != "proxy" {
+		t.Errorf("expected Server to be replaced, got %q", v)
+	}
+	if v := w.Header().Get("X-Public"); v != "yes" {
+		t.Errorf("expected other headers to pass through, got %q", v)
+	}
+}
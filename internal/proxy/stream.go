@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"time"
+)
+
+// defaultFlushInterval bounds how long buffered response bytes wait before
+// being flushed to the client
+const defaultFlushInterval = 100 * time.Millisecond
+
+// SetFlushInterval sets how often response bodies are flushed to the
+// client while they are copied. Streamed responses, those without a
+// Content-Length, are always flushed after every write, as they are when
+// the interval is negative.
+func (p *Proxy) SetFlushInterval(d time.Duration) {
+	p.flushInterval = d
+}
+
+// isEventStream reports whether resp is a server-sent event stream. Like
+// any response without a Content-Length it is flushed as it arrives, but it
+// is open-ended and never cached.
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
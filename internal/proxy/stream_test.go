@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func TestProxyStreamsServerSentEvents(t *testing.T) {
+	received := make(chan struct{}, 3)
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "max-age=60")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			w.(http.Flusher).Flush()
+			// Hold the stream open until the client has seen this event,
+			// which it can only do if the proxy flushed it
+			select {
+			case <-received:
+			case <-time.After(2 * time.Second):
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetFlushInterval(time.Minute)
+	p.SetCachePolicy(CachePolicy{TTL: time.Minute})
+	completed := make(chan struct{})
+	p.OnSync("request_completed", func(Event) { close(completed) })
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "events", PathPrefix: "/events", Backend: pool})
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	resp, err := http.Get(proxyServer.URL + "/events")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event %d: read failed: %v", i, err)
+		}
+		if want := fmt.Sprintf("data: event %d\n", i); line != want {
+			t.Fatalf("expected %q, got %q", want, line)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("event %d: expected it to be flushed as it arrived, waited %v", i, elapsed)
+		}
+		reader.ReadString('\n')
+		received <- struct{}{}
+	}
+
+	select {
+	case <-completed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the request to complete")
+	}
+	if n := p.GetStats().CacheSize; n != 0 {
+		t.Errorf("expected event stream not to be cached, got %d entries", n)
+	}
+}
+
+func TestProxyFlushesChunkedResponses(t *testing.T) {
+	received := make(chan struct{})
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"n\":1}\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			return
+		}
+		w.Write([]byte("{\"n\":2}\n"))
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	p.SetFlushInterval(time.Minute)
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "feed", PathPrefix: "/feed", Backend: pool})
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	resp, err := http.Get(proxyServer.URL + "/feed")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	start := time.Now()
+	line, _ := reader.ReadString('\n')
+	if elapsed := time.Since(start); elapsed > time.Second || !strings.Contains(line, `"n":1`) {
+		t.Fatalf("expected the first chunk to arrive promptly, got %q after %v", line, elapsed)
+	}
+	close(received)
+	if line, _ := reader.ReadString('\n'); !strings.Contains(line, `"n":2`) {
+		t.Errorf("expected the second chunk, got %q", line)
+	}
+}
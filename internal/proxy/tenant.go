@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/advanced"
+	"github.com/surukanti/reverse-proxy/internal/middleware"
+)
+
+// SetTenantRateLimit limits each tenant's requests with limiter, on top of
+// the global rate limit. tenantID names the tenant of a request; requests
+// it returns "" for are only limited globally, as are tenants that are
+// neither the authenticated client nor have a limit of their own, since
+// anyone can name a fresh tenant to get a fresh default quota. A tenant's
+// quota is shared by all of its clients. It runs after middleware, so it
+// can use what authentication middleware put in the request context, and
+// should be called before the proxy starts serving.
+func (p *Proxy) SetTenantRateLimit(limiter *advanced.TenantRateLimiter, tenantID func(*http.Request) string) {
+	p.tenantLimit = limiter
+	p.tenantID = tenantID
+}
+
+// TenantFromHeader identifies tenants by the authenticated client ID,
+// falling back to the named request header for unauthenticated requests
+// when name isn't empty
+func TenantFromHeader(name string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if tenant := middleware.ClientID(r); tenant != "" || name == "" {
+			return tenant
+		}
+		return r.Header.Get(name)
+	}
+}
+
+// allowTenant checks the request against its tenant's quota, setting the
+// rate limit headers and answering 429 Too Many Requests if it is used up
+func (p *Proxy) allowTenant(w http.ResponseWriter, r *http.Request) bool {
+	if p.tenantLimit == nil || p.tenantID == nil {
+		return true
+	}
+	tenant := p.tenantID(r)
+	if tenant == "" || (tenant != middleware.ClientID(r) && !p.tenantLimit.HasLimit(tenant)) {
+		return true
+	}
+	limit, limited := p.tenantLimit.Limit(tenant, "")
+	if !limited {
+		return true
+	}
+
+	setRateLimitHeaders(w, limit)
+	if !limit.Allowed {
+		p.countError()
+		p.emitEvent(Event{
+			Type:      "rate_limit_exceeded",
+			Timestamp: time.Now(),
+			Request:   r,
+			Tenant:    tenant,
+		})
+		p.writeError(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
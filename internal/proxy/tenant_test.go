@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/advanced"
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/middleware"
+	"github.com/surukanti/reverse-proxy/internal/router"
+)
+
+func newTenantProxy(t *testing.T, limiter *advanced.TenantRateLimiter) *Proxy {
+	t.Helper()
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(newNamedBackend(t, "ok"), 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/", Backend: pool})
+	p.SetTenantRateLimit(limiter, TenantFromHeader("X-Tenant-ID"))
+	return p
+}
+
+func tenantHeader(tenant string) http.Header {
+	return http.Header{"X-Tenant-Id": {tenant}}
+}
+
+func TestTenantRateLimitThrottlesOneTenant(t *testing.T) {
+	limiter := advanced.NewTenantRateLimiter()
+	limiter.SetTenantLimit("noisy", 2, time.Hour)
+	limiter.SetTenantLimit("quiet", 100, time.Hour)
+	p := newTenantProxy(t, limiter)
+
+	var exceeded atomic.Value
+	p.OnSync("rate_limit_exceeded", func(e Event) { exceeded.Store(e.Tenant) })
+
+	for i := 0; i < 2; i++ {
+		if w := doRequest(p, "GET", "/", tenantHeader("noisy")); w.Code != http.StatusOK {
+			t.Fatalf("expected request %d within quota to pass, got %d", i+1, w.Code)
+		}
+	}
+	w := doRequest(p, "GET", "/", tenantHeader("noisy"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the tenant's quota is used, got %d", w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected the tenant's quota in the rate limit headers, got %v", w.Header())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	if tenant, _ := exceeded.Load().(string); tenant != "noisy" {
+		t.Errorf("expected a rate_limit_exceeded event for the tenant, got %q", tenant)
+	}
+
+	for i := 0; i < 10; i++ {
+		if w := doRequest(p, "GET", "/", tenantHeader("quiet")); w.Code != http.StatusOK {
+			t.Fatalf("expected the other tenant to be unaffected, got %d", w.Code)
+		}
+	}
+	if w := doRequest(p, "GET", "/", tenantHeader("quiet")); w.Header().Get("X-RateLimit-Limit") != "100" {
+		t.Errorf("expected the other tenant's quota in the headers, got %s", w.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestTenantRateLimitDefault(t *testing.T) {
+	limiter := advanced.NewTenantRateLimiter()
+	limiter.SetDefaultLimit(1, time.Hour)
+	p := newTenantProxy(t, limiter)
+	p.AddMiddleware(middleware.NewAPIKeyMiddleware(map[string]string{"key-a": "a", "key-b": "b"}).Handle)
+
+	keyA := http.Header{"X-Api-Key": {"key-a"}}
+	doRequest(p, "GET", "/", keyA)
+	if w := doRequest(p, "GET", "/", keyA); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the default quota for an unlisted tenant, got %d", w.Code)
+	}
+	keyA.Set("X-Tenant-ID", "other")
+	if w := doRequest(p, "GET", "/", keyA); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected an authenticated tenant not to escape its quota by header, got %d", w.Code)
+	}
+	if w := doRequest(p, "GET", "/", http.Header{"X-Api-Key": {"key-b"}}); w.Code != http.StatusOK {
+		t.Errorf("expected each tenant to get its own default quota, got %d", w.Code)
+	}
+}
+
+func TestTenantRateLimitDefaultNeedsAuthentication(t *testing.T) {
+	limiter := advanced.NewTenantRateLimiter()
+	limiter.SetDefaultLimit(1, time.Hour)
+	limiter.SetTenantLimit("listed", 1, time.Hour)
+	p := newTenantProxy(t, limiter)
+
+	// Unauthenticated clients could name a new tenant on every request, so
+	// only tenants with a limit of their own are limited by header
+	for i := 0; i < 5; i++ {
+		if w := doRequest(p, "GET", "/", tenantHeader("made-up")); w.Code != http.StatusOK {
+			t.Fatalf("expected an unlisted header tenant to be only globally limited, got %d", w.Code)
+		}
+		if w := doRequest(p, "GET", "/", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected requests without a tenant to be only globally limited, got %d", w.Code)
+		}
+	}
+	doRequest(p, "GET", "/", tenantHeader("listed"))
+	if w := doRequest(p, "GET", "/", tenantHeader("listed")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a listed tenant named by header to be limited, got %d", w.Code)
+	}
+}
+
+func TestTenantFromHeaderPrefersClientID(t *testing.T) {
+	limiter := advanced.NewTenantRateLimiter()
+	limiter.SetTenantLimit("acme", 1, time.Hour)
+	p := newTenantProxy(t, limiter)
+	p.AddMiddleware(middleware.NewAPIKeyMiddleware(map[string]string{"secret": "acme"}).Handle)
+
+	key := http.Header{"X-Api-Key": {"secret"}}
+	doRequest(p, "GET", "/", key)
+	if w := doRequest(p, "GET", "/", key); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the authenticated client's tenant quota to apply, got %d", w.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+var noopTracer = noop.NewTracerProvider().Tracer("")
+
+// SetTracer enables tracing: each request gets a server span, continued
+// from an incoming traceparent/tracestate and propagated to the upstream
+func (p *Proxy) SetTracer(tracer trace.Tracer) {
+	if tracer == nil {
+		tracer = noopTracer
+	}
+	p.tracer = tracer
+}
+
+// startSpan starts the request span and returns the request carrying it
+func (p *Proxy) startSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := p.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := p.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endSpan records the outcome of the request and ends its span
+func endSpan(span trace.Span, route string, status int) {
+	if span.IsRecording() {
+		if route != "" {
+			span.SetAttributes(attribute.String("proxy.route", route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+	span.End()
+}
+
+// traceUpstream tags the span with the backend and injects its context
+// into the outgoing request headers
+func (p *Proxy) traceUpstream(r, out *http.Request, backendURL string) {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attribute.String("proxy.backend", backendURL))
+	p.propagator.Inject(r.Context(), propagation.HeaderCarrier(out.Header))
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/router"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTracedProxy(t *testing.T, handler http.HandlerFunc) (*Proxy, *tracetest.InMemoryExporter, *backend.Server) {
+	mockBackend := httptest.NewServer(handler)
+	t.Cleanup(mockBackend.Close)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { provider.Shutdown(t.Context()) })
+
+	p := NewProxy()
+	p.SetTracer(provider.Tracer("test"))
+	pool := backend.NewPool()
+	server, _ := pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+	return p, exporter, server
+}
+
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestProxyTracingSpanAttributes(t *testing.T) {
+	p, exporter, server := newTracedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/users", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	attrs := spanAttributes(spans[0])
+	if got := attrs["proxy.route"].AsString(); got != "api" {
+		t.Errorf("expected route attribute api, got %q", got)
+	}
+	if got := attrs["proxy.backend"].AsString(); got != server.URL.String() {
+		t.Errorf("expected backend attribute %q, got %q", server.URL.String(), got)
+	}
+	if got := attrs["http.response.status_code"].AsInt64(); got != http.StatusOK {
+		t.Errorf("expected status attribute 200, got %d", got)
+	}
+}
+
+func TestProxyTracingPropagatesContext(t *testing.T) {
+	traceparents := make(chan string, 1)
+	p, exporter, _ := newTracedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+	})
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	req.Header.Set("traceparent", incoming)
+	req.Header.Set("tracestate", "vendor=value")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := exporter.GetSpans()[0]
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected span to continue the incoming trace, got %s", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("expected incoming span as parent, got %s", got)
+	}
+
+	want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if got := <-traceparents; got != want {
+		t.Errorf("expected upstream traceparent %q, got %q", want, got)
+	}
+}
+
+func TestProxyTracingErrorStatus(t *testing.T) {
+	p, exporter, _ := newTracedProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := exporter.GetSpans()[0]
+	if span.Status.Code != codes.Error {
+		t.Errorf("expected error status for 503, got %v", span.Status.Code)
+	}
+}
+
+func TestProxyTracingDisabledByDefault(t *testing.T) {
+	traceparents := make(chan string, 1)
+	mockBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+	}))
+	defer mockBackend.Close()
+
+	p := NewProxy()
+	pool := backend.NewPool()
+	pool.AddServer(mockBackend.URL, 1)
+	p.AddRoute(&router.Route{Name: "api", PathPrefix: "/api", Backend: pool})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api", nil)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := <-traceparents; got != "" {
+		t.Errorf("expected no traceparent without a tracer, got %q", got)
+	}
+}
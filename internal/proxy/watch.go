@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/surukanti/reverse-proxy/internal/config"
+)
+
+// DefaultWatchDebounce is how long the config file must stay unchanged
+// before a watched change is reloaded
+const DefaultWatchDebounce = 500 * time.Millisecond
+
+// ReloadFile loads the YAML config at filename and applies it with Reload
+func (p *Proxy) ReloadFile(filename string) (*config.Config, error) {
+	cfg, err := config.LoadFromYAML(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WatchConfig reloads the YAML config at filename whenever it or a file it
+// includes changes on disk, until ctx is done. A series of writes within
+// debounce of each other triggers a single reload. onReload, if set, is
+// called after every reload attempt; on error the running config is kept.
+//
+// The files' directories are watched rather than the files themselves, so
+// changes made by replacing a file (editors, Kubernetes ConfigMaps) are
+// seen too. New files matching an include glob are picked up, and the
+// watched files follow the includes of each reloaded config.
+func (p *Proxy) WatchConfig(ctx context.Context, filename string, debounce time.Duration, onReload func(*config.Config, error)) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	sources := []string{path}
+	if cfg, err := config.LoadFromYAML(path); err == nil {
+		sources = cfg.Sources()
+	}
+	watched := make(map[string]bool)
+	watch := func() error {
+		for _, source := range sources {
+			dir := filepath.Dir(source)
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				return err
+			}
+			watched[dir] = true
+		}
+		return nil
+	}
+	if err := watch(); err != nil {
+		watcher.Close()
+		return err
+	}
+	if debounce <= 0 {
+		debounce = DefaultWatchDebounce
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op != fsnotify.Chmod && matchesSource(sources, filepath.Clean(event.Name)) {
+					timer.Reset(debounce)
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// Events may have been lost (e.g. on overflow), so reload
+				// in case one of them was for a config file
+				timer.Reset(debounce)
+			case <-timer.C:
+				cfg, err := p.ReloadFile(path)
+				if err == nil {
+					sources = cfg.Sources()
+					// A directory that can't be watched only misses
+					// changes; the config itself was applied
+					watch()
+				}
+				if onReload != nil {
+					onReload(cfg, err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// matchesSource reports whether the file at path is one of a config's
+// sources, or matches one of its include globs
+func matchesSource(sources []string, path string) bool {
+	for _, source := range sources {
+		if source == path {
+			return true
+		}
+		if strings.ContainsAny(source, "*?[") {
+			if ok, _ := filepath.Match(source, path); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
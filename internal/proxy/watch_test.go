@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/surukanti/reverse-proxy/internal/config"
+)
+
+func writeProxyConfig(t *testing.T, path, serverURL, pattern string) {
+	t.Helper()
+	yaml := fmt.Sprintf(`
+backends:
+  - id: api
+    servers:
+      - %s
+routes:
+  - name: api
+    pattern: %q
+    backend_id: api
+`, serverURL, pattern)
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+type reloadResult struct {
+	cfg *config.Config
+	err error
+}
+
+func waitReload(t *testing.T, reloads <-chan reloadResult) reloadResult {
+	t.Helper()
+	select {
+	case result := <-reloads:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+	}
+	return reloadResult{}
+}
+
+func TestWatchConfig(t *testing.T) {
+	urlA, urlB := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeProxyConfig(t, path, urlA, "/")
+
+	p := NewProxy()
+	if _, err := p.ReloadFile(path); err != nil {
+		t.Fatalf("initial load failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan reloadResult, 10)
+	err := p.WatchConfig(ctx, path, 50*time.Millisecond, func(cfg *config.Config, err error) {
+		reloads <- reloadResult{cfg, err}
+	})
+	if err != nil {
+		t.Fatalf("failed to watch config: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		writeProxyConfig(t, path, urlB, "/")
+	}
+	if result := waitReload(t, reloads); result.err != nil {
+		t.Fatalf("reload failed: %v", result.err)
+	}
+	if code, body := serveBody(p, "/"); code != http.StatusOK || body != "b" {
+		t.Errorf("expected the changed config to be applied, got %d %q", code, body)
+	}
+	select {
+	case <-reloads:
+		t.Error("expected a burst of writes to trigger a single reload")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	writeProxyConfig(t, path, urlA, "([")
+	if result := waitReload(t, reloads); result.err == nil {
+		t.Error("expected an invalid config to fail to reload")
+	}
+	if code, body := serveBody(p, "/"); code != http.StatusOK || body != "b" {
+		t.Errorf("expected the running config to be kept, got %d %q", code, body)
+	}
+}
+
+func TestWatchConfigIncludes(t *testing.T) {
+	urlA, urlB := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("include: [routes/*.yaml]\n"), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "routes"), 0o755); err != nil {
+		t.Fatalf("failed to create include directory: %v", err)
+	}
+	included := filepath.Join(dir, "routes", "api.yaml")
+	writeProxyConfig(t, included, urlA, "/")
+
+	p := NewProxy()
+	if _, err := p.ReloadFile(path); err != nil {
+		t.Fatalf("initial load failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan reloadResult, 10)
+	err := p.WatchConfig(ctx, path, 10*time.Millisecond, func(cfg *config.Config, err error) {
+		reloads <- reloadResult{cfg, err}
+	})
+	if err != nil {
+		t.Fatalf("failed to watch config: %v", err)
+	}
+
+	writeProxyConfig(t, included, urlB, "/")
+	if result := waitReload(t, reloads); result.err != nil {
+		t.Fatalf("reload failed: %v", result.err)
+	}
+	if code, body := serveBody(p, "/"); code != http.StatusOK || body != "b" {
+		t.Errorf("expected the changed include to be applied, got %d %q", code, body)
+	}
+
+	// A new file matching the include glob is picked up too
+	writeProxyConfig(t, filepath.Join(dir, "routes", "zz.yaml"), urlA, "/")
+	if result := waitReload(t, reloads); result.err != nil {
+		t.Fatalf("reload failed: %v", result.err)
+	}
+	if code, body := serveBody(p, "/"); code != http.StatusOK || body != "a" {
+		t.Errorf("expected the new include to be applied, got %d %q", code, body)
+	}
+}
+
+func TestWatchConfigIgnoresOtherFiles(t *testing.T) {
+	urlA := newNamedBackend(t, "a")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeProxyConfig(t, path, urlA, "/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan reloadResult, 10)
+	err := NewProxy().WatchConfig(ctx, path, 10*time.Millisecond, func(cfg *config.Config, err error) {
+		reloads <- reloadResult{cfg, err}
+	})
+	if err != nil {
+		t.Fatalf("failed to watch config: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("routes: []\n"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	select {
+	case <-reloads:
+		t.Error("expected changes to other files to be ignored")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -1,30 +1,87 @@
 package router
 
 import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"maps"
+	"mime"
+	"net"
 	"net/http"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/surukanti/reverse-proxy/internal/backend"
+	"github.com/surukanti/reverse-proxy/internal/middleware"
 )
 
-// Route represents a routing rule
+// Route represents a routing rule. Pattern is an unanchored regular
+// expression searched anywhere in the path (so "/api" also matches
+// "/api-internal" and "/v1/api"); anchor it with ^ and $ or use Glob for
+// exact matching. Colon parameters such as /users/:id are compiled to an
+// anchored pattern.
+// Glob matches whole paths where * matches within a single segment and **
+// matches the rest of the path, e.g. /static/**.
 type Route struct {
-	Name       string
-	Pattern    string
-	PathPrefix string
-	Subdomain  string
-	Headers    map[string]string
-	Methods    []string
-	Backend    *backend.Pool
-	Priority   int
-	regex      *regexp.Regexp
+	Name            string
+	Pattern         string
+	Glob            string
+	PathPrefix      string
+	StripPrefix     bool   // remove PathPrefix before forwarding
+	CaseInsensitive bool   // match the path ignoring case
+	Host            string // exact host, compared without the port
+	Subdomain       string
+	Headers         map[string]string
+	HeadersNot      map[string]string // header must not have this value
+	HeadersRegex    map[string]string // header must match this regex
+	Query           map[string]string // empty value = parameter present with any value
+	Methods         []string
+	Backend         *backend.Pool
+	CanaryBackend   *backend.Pool
+	CanaryPercent   float64 // share of clients sent to CanaryBackend, 0-100
+	Priority        int
+	Disabled        bool                 // skipped by Match; routes are enabled by default
+	Timeout         time.Duration        // upstream deadline, zero for none
+	Middlewares     []middleware.Handler // run after global middleware, for this route only
+	Rewrite         *PathRewrite
+	Redirect        *Redirect         // respond with a redirect instead of proxying
+	PreserveHost    bool              // forward the client's Host instead of the backend's
+	RequestHeaders  map[string]string // set on the upstream request, after the X-Forwarded-* headers
+	regex           *regexp.Regexp
+	glob            *regexp.Regexp
+	headerRegex     map[string]*regexp.Regexp
+	params          []string
 }
 
+// PathRewrite rewrites the request path before it is forwarded
+type PathRewrite struct {
+	StripPrefix string // prefix removed from the path
+	Pattern     string // regex matched against the path
+	Replacement string // replacement for Pattern, may reference groups as $1
+	regex       *regexp.Regexp
+}
+
+// Redirect answers a route with a redirect. Target may contain {path},
+// replaced by the request path after StripPrefix and Rewrite; a Target
+// starting with / is made absolute using the request host.
+type Redirect struct {
+	Target string
+	Status int // defaults to 302 Found
+}
+
+// paramsKey is the context key for captured path parameters
+type paramsKey struct{}
+
 // Router manages routing rules
 type Router struct {
 	routes []*Route
+	trie   *prefixTrie
+	cache  *matchCache
 	mu     sync.RWMutex
 }
 
@@ -32,17 +89,17 @@ type Router struct {
 func NewRouter() *Router {
 	return &Router{
 		routes: make([]*Route, 0),
+		trie:   newPrefixTrie(nil),
 	}
 }
 
+// ErrRouteNotFound is returned when no route has the given name
+var ErrRouteNotFound = errors.New("route not found")
+
 // AddRoute adds a new routing rule
 func (r *Router) AddRoute(route *Route) error {
-	if route.Pattern != "" {
-		regex, err := regexp.Compile(route.Pattern)
-		if err != nil {
-			return err
-		}
-		route.regex = regex
+	if err := compileRoute(route); err != nil {
+		return err
 	}
 
 	r.mu.Lock()
@@ -55,13 +112,31 @@ func (r *Router) AddRoute(route *Route) error {
 	return nil
 }
 
-// Match finds the best matching route for a request
-func (r *Router) Match(req *http.Request) *Route {
+// SetRoutes replaces every route at once. Nothing changes if any route
+// fails to compile.
+func (r *Router) SetRoutes(routes []*Route) error {
+	for _, route := range routes {
+		if err := compileRoute(route); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes = append(make([]*Route, 0, len(routes)), routes...)
+	r.sortRoutes()
+
+	return nil
+}
+
+// GetRoute returns the route with the given name, or nil if none exists
+func (r *Router) GetRoute(name string) *Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	for _, route := range r.routes {
-		if r.matchRoute(route, req) {
+		if route.Name == name {
 			return route
 		}
 	}
@@ -69,8 +144,335 @@ func (r *Router) Match(req *http.Request) *Route {
 	return nil
 }
 
+// UpdateRoute replaces the route with the same name by a compiled copy of
+// route, re-sorting by priority. route may be the live one, as returned by
+// GetRoute; it is never modified, so concurrent matches aren't disturbed.
+func (r *Router) UpdateRoute(route *Route) error {
+	compiled := route.clone()
+	if err := compileRoute(compiled); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.routes {
+		if existing.Name == route.Name {
+			r.routes[i] = compiled
+			r.sortRoutes()
+			return nil
+		}
+	}
+
+	return ErrRouteNotFound
+}
+
+// SetRouteEnabled enables or disables the named route in place, keeping
+// its priority and position. It returns false if no route has the name.
+func (r *Router) SetRouteEnabled(name string, enabled bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, route := range r.routes {
+		if route.Name == name {
+			route.Disabled = !enabled
+			r.reindex()
+			return true
+		}
+	}
+
+	return false
+}
+
+// clone returns a deep copy of route, sharing nothing compileRoute writes
+// or that requests matched against route read
+func (route *Route) clone() *Route {
+	c := *route
+	c.Headers = maps.Clone(route.Headers)
+	c.HeadersNot = maps.Clone(route.HeadersNot)
+	c.HeadersRegex = maps.Clone(route.HeadersRegex)
+	c.Query = maps.Clone(route.Query)
+	c.RequestHeaders = maps.Clone(route.RequestHeaders)
+	c.Methods = slices.Clone(route.Methods)
+	c.Middlewares = slices.Clone(route.Middlewares)
+	if route.Rewrite != nil {
+		rewrite := *route.Rewrite
+		c.Rewrite = &rewrite
+	}
+	if route.Redirect != nil {
+		redirect := *route.Redirect
+		c.Redirect = &redirect
+	}
+	return &c
+}
+
+// compileRoute compiles a route's patterns, replacing any compiled earlier
+func compileRoute(route *Route) error {
+	route.regex, route.params, route.glob, route.headerRegex = nil, nil, nil, nil
+
+	if route.Pattern != "" {
+		pattern, params := compilePattern(route.Pattern)
+		if route.CaseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		route.regex = regex
+		route.params = params
+	}
+
+	if route.Rewrite != nil && route.Rewrite.Pattern != "" {
+		regex, err := regexp.Compile(route.Rewrite.Pattern)
+		if err != nil {
+			return err
+		}
+		route.Rewrite.regex = regex
+	}
+
+	if len(route.HeadersRegex) > 0 {
+		route.headerRegex = make(map[string]*regexp.Regexp, len(route.HeadersRegex))
+		for key, pattern := range route.HeadersRegex {
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			route.headerRegex[key] = regex
+		}
+	}
+
+	if route.Glob != "" {
+		pattern := compileGlob(route.Glob)
+		if route.CaseInsensitive {
+			pattern = "(?i)" + pattern
+		}
+		glob, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		route.glob = glob
+	}
+
+	return nil
+}
+
+// Match finds the best matching route for a request
+func (r *Router) Match(req *http.Request) *Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.cache == nil {
+		route, _ := r.match(req)
+		return route
+	}
+
+	key := req.Method + " " + req.Host + " " + req.URL.Path
+	if route, ok := r.cache.get(key); ok {
+		return route
+	}
+	route, cacheable := r.match(req)
+	if cacheable {
+		r.cache.add(key, route)
+	}
+	return route
+}
+
+// match evaluates candidate routes in priority order. The result is
+// cacheable when no route examined depends on headers or query parameters,
+// since those are not part of the cache key.
+func (r *Router) match(req *http.Request) (*Route, bool) {
+	cacheable := true
+	for _, i := range r.trie.candidates(req.URL.Path) {
+		route := r.routes[i]
+		if len(route.Headers) > 0 || len(route.HeadersNot) > 0 || len(route.headerRegex) > 0 || len(route.Query) > 0 {
+			cacheable = false
+		}
+		if r.matchRoute(route, req) {
+			return route, cacheable
+		}
+	}
+
+	return nil, cacheable
+}
+
+// EnableMatchCache caches up to size match results keyed on method, host
+// and path. The cache is cleared whenever routes change; a size of zero or
+// less disables it.
+func (r *Router) EnableMatchCache(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if size <= 0 {
+		r.cache = nil
+		return
+	}
+	r.cache = newMatchCache(size)
+}
+
+// RewritePath returns the path to forward to the backend. StripPrefix is
+// applied before Rewrite; without either the path is returned unchanged,
+// and a rewrite that leaves the path empty yields "/".
+func (route *Route) RewritePath(path string) string {
+	if (!route.StripPrefix || route.PathPrefix == "") && route.Rewrite == nil {
+		return path
+	}
+
+	if route.StripPrefix && route.PathPrefix != "" {
+		prefix := strings.TrimSuffix(route.PathPrefix, "/")
+		if route.hasPrefix(path, prefix) {
+			path = path[len(prefix):]
+		}
+	}
+	if route.Rewrite != nil {
+		if route.Rewrite.StripPrefix != "" {
+			path = strings.TrimPrefix(path, route.Rewrite.StripPrefix)
+		}
+		if route.Rewrite.regex != nil {
+			path = route.Rewrite.regex.ReplaceAllString(path, route.Rewrite.Replacement)
+		}
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// SelectBackend returns the pool to serve a client. When a canary is
+// configured, CanaryPercent of clients go to CanaryBackend; the choice is
+// derived from a hash of key, so a client keeps seeing the same pool.
+func (route *Route) SelectBackend(key string) *backend.Pool {
+	if route.CanaryBackend == nil || route.CanaryPercent <= 0 {
+		return route.Backend
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	if float64(h.Sum32()%10000) < route.CanaryPercent*100 {
+		return route.CanaryBackend
+	}
+	return route.Backend
+}
+
+// RedirectURL returns the Location for a redirect route. The request's
+// query string is kept unless the target sets its own. Leading slashes of
+// the substituted path are collapsed, so a request for //evil.com can't
+// turn a "{path}" target into a protocol-relative URL.
+func (route *Route) RedirectURL(req *http.Request) string {
+	path := route.RewritePath(req.URL.Path)
+	if trimmed := strings.TrimLeft(path, "/\\"); trimmed != path {
+		path = "/" + trimmed
+	}
+	location := strings.ReplaceAll(route.Redirect.Target, "{path}", path)
+
+	if req.URL.RawQuery != "" && !strings.Contains(location, "?") {
+		location += "?" + req.URL.RawQuery
+	}
+
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		scheme := "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+		location = scheme + "://" + req.Host + location
+	}
+	return location
+}
+
+// RedirectStatus returns the status code for a redirect route
+func (route *Route) RedirectStatus() int {
+	if route.Redirect.Status == 0 {
+		return http.StatusFound
+	}
+	return route.Redirect.Status
+}
+
+// hasPrefix reports whether path begins with prefix, ignoring case when
+// the route is case-insensitive
+func (route *Route) hasPrefix(path, prefix string) bool {
+	if route.CaseInsensitive {
+		return len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// MatchParams finds the best matching route for a request and returns the
+// path parameters captured by its pattern (e.g. id for /users/:id)
+func (r *Router) MatchParams(req *http.Request) (*Route, map[string]string) {
+	route := r.Match(req)
+	if route == nil || len(route.params) == 0 {
+		return route, nil
+	}
+
+	match := route.regex.FindStringSubmatch(req.URL.Path)
+	params := make(map[string]string, len(route.params))
+	for i, name := range route.regex.SubexpNames() {
+		if name != "" && i < len(match) {
+			params[name] = match[i]
+		}
+	}
+	return route, params
+}
+
+// WithParams returns a shallow copy of req carrying the path parameters
+func WithParams(req *http.Request, params map[string]string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), paramsKey{}, params))
+}
+
+// Params returns the path parameters stored on the request, if any
+func Params(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(paramsKey{}).(map[string]string)
+	return params
+}
+
+// compilePattern turns colon-style path parameters (/users/:id) into an
+// anchored regex with named capture groups. Patterns without parameters are
+// returned unchanged.
+func compilePattern(pattern string) (string, []string) {
+	if !strings.Contains(pattern, "/:") {
+		return pattern, nil
+	}
+
+	segments := strings.Split(pattern, "/")
+	params := make([]string, 0)
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") && len(segment) > 1 {
+			name := segment[1:]
+			params = append(params, name)
+			segments[i] = "(?P<" + name + ">[^/]+)"
+			continue
+		}
+		segments[i] = regexp.QuoteMeta(segment)
+	}
+
+	return "^" + strings.Join(segments, "/") + "$", params
+}
+
+// compileGlob turns a wildcard path into an anchored regex: ** matches the
+// rest of the path and * matches any characters within one segment
+func compileGlob(glob string) string {
+	segments := strings.Split(glob, "/")
+	for i, segment := range segments {
+		switch segment {
+		case "**":
+			segments[i] = ".*"
+			continue
+		case "*":
+			segments[i] = "[^/]+"
+			continue
+		}
+		segments[i] = strings.ReplaceAll(regexp.QuoteMeta(segment), `\*`, "[^/]*")
+	}
+	return "^" + strings.Join(segments, "/") + "$"
+}
+
 // matchRoute checks if a request matches a route
 func (r *Router) matchRoute(route *Route, req *http.Request) bool {
+	if route.Disabled {
+		return false
+	}
+
 	// Check method
 	if len(route.Methods) > 0 {
 		methodMatch := false
@@ -85,6 +487,13 @@ func (r *Router) matchRoute(route *Route, req *http.Request) bool {
 		}
 	}
 
+	// Check host
+	if route.Host != "" {
+		if !strings.EqualFold(stripPort(req.Host), route.Host) {
+			return false
+		}
+	}
+
 	// Check subdomain
 	if route.Subdomain != "" {
 		host := req.Host
@@ -106,9 +515,35 @@ func (r *Router) matchRoute(route *Route, req *http.Request) bool {
 		}
 	}
 
+	for key, value := range route.HeadersNot {
+		if req.Header.Get(key) == value {
+			return false
+		}
+	}
+
+	for key, regex := range route.headerRegex {
+		if !regex.MatchString(req.Header.Get(key)) {
+			return false
+		}
+	}
+
+	// Check query parameters
+	if len(route.Query) > 0 {
+		query := req.URL.Query()
+		for key, value := range route.Query {
+			if value == "" {
+				if !query.Has(key) {
+					return false
+				}
+			} else if query.Get(key) != value {
+				return false
+			}
+		}
+	}
+
 	// Check path prefix
 	if route.PathPrefix != "" {
-		if !strings.HasPrefix(req.URL.Path, route.PathPrefix) {
+		if !route.hasPrefix(req.URL.Path, route.PathPrefix) {
 			return false
 		}
 	}
@@ -120,6 +555,13 @@ func (r *Router) matchRoute(route *Route, req *http.Request) bool {
 		}
 	}
 
+	// Check glob pattern
+	if route.glob != nil {
+		if !route.glob.MatchString(req.URL.Path) {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -131,6 +573,7 @@ func (r *Router) RemoveRoute(name string) bool {
 	for i, route := range r.routes {
 		if route.Name == name {
 			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			r.reindex()
 			return true
 		}
 	}
@@ -148,15 +591,21 @@ func (r *Router) ListRoutes() []*Route {
 	return routes
 }
 
-// sortRoutes sorts routes by priority (higher first)
+// sortRoutes sorts routes by priority (higher first), keeping insertion
+// order among routes with equal priority
 func (r *Router) sortRoutes() {
-	// Simple bubble sort (in production, use better algorithm)
-	for i := 0; i < len(r.routes); i++ {
-		for j := i + 1; j < len(r.routes); j++ {
-			if r.routes[j].Priority > r.routes[i].Priority {
-				r.routes[i], r.routes[j] = r.routes[j], r.routes[i]
-			}
-		}
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].Priority > r.routes[j].Priority
+	})
+	r.reindex()
+}
+
+// reindex rebuilds the prefix index and drops cached match results after
+// the route table changes
+func (r *Router) reindex() {
+	r.trie = newPrefixTrie(r.routes)
+	if r.cache != nil {
+		r.cache.clear()
 	}
 }
 
@@ -172,16 +621,83 @@ func NewContentRouter() *ContentRouter {
 	}
 }
 
-// RouteByContentType returns a backend based on content type
+// RouteByContentType returns a backend based on content type. Parameters
+// such as charset are ignored, and wildcard keys like "image/*" and "*/*"
+// are tried after an exact match, most specific first.
 func (cr *ContentRouter) RouteByContentType(req *http.Request, contentTypeRoutes map[string]*backend.Pool) *backend.Pool {
 	contentType := req.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+
 	if pool, ok := contentTypeRoutes[contentType]; ok {
 		return pool
 	}
-	return nil
+	if i := strings.Index(contentType, "/"); i > 0 {
+		if pool, ok := contentTypeRoutes[contentType[:i]+"/*"]; ok {
+			return pool
+		}
+	}
+	return contentTypeRoutes["*/*"]
+}
+
+// RouteByAccept returns a backend based on the Accept header, trying media
+// types in order of preference (q-value) and falling back to a "*/*" entry
+func (cr *ContentRouter) RouteByAccept(req *http.Request, acceptRoutes map[string]*backend.Pool) *backend.Pool {
+	for _, mediaType := range parseAccept(req.Header.Get("Accept")) {
+		if pool, ok := acceptRoutes[mediaType]; ok {
+			return pool
+		}
+	}
+	return acceptRoutes["*/*"]
 }
 
 // GetRouter returns the underlying router
 func (cr *ContentRouter) GetRouter() *Router {
 	return cr.router
 }
+
+// stripPort removes the port, if any, from a host header value
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// parseAccept returns the media types of an Accept header ordered by
+// q-value, highest first; types with q=0 are dropped
+func parseAccept(header string) []string {
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+
+	var types []accepted
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		types = append(types, accepted{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(types, func(i, j int) bool {
+		return types[i].q > types[j].q
+	})
+
+	mediaTypes := make([]string, len(types))
+	for i, t := range types {
+		mediaTypes[i] = t.mediaType
+	}
+	return mediaTypes
+}
@@ -1,7 +1,10 @@
 package router
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/surukanti/reverse-proxy/internal/backend"
@@ -97,6 +100,47 @@ func TestRemoveRouteNotFound(t *testing.T) {
 	}
 }
 
+func TestSetRoutes(t *testing.T) {
+	r := NewRouter()
+	pool := backend.NewPool()
+	r.AddRoute(&Route{Name: "old", PathPrefix: "/old", Backend: pool})
+
+	err := r.SetRoutes([]*Route{
+		{Name: "low", PathPrefix: "/api", Priority: 1, Backend: pool},
+		{Name: "high", PathPrefix: "/api", Priority: 10, Backend: pool},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if r.GetRoute("old") != nil {
+		t.Error("expected old route to be replaced")
+	}
+	req, _ := http.NewRequest("GET", "http://localhost/api/x", nil)
+	if route := r.Match(req); route == nil || route.Name != "high" {
+		t.Errorf("expected routes to be sorted by priority, got %v", route)
+	}
+}
+
+func TestSetRoutesInvalidKeepsRoutes(t *testing.T) {
+	r := NewRouter()
+	pool := backend.NewPool()
+	r.AddRoute(&Route{Name: "old", PathPrefix: "/old", Backend: pool})
+
+	err := r.SetRoutes([]*Route{
+		{Name: "new", PathPrefix: "/new", Backend: pool},
+		{Name: "bad", Pattern: "([", Backend: pool},
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+
+	routes := r.ListRoutes()
+	if len(routes) != 1 || routes[0].Name != "old" {
+		t.Errorf("expected routes to be unchanged, got %d routes", len(routes))
+	}
+}
+
 func TestListRoutes(t *testing.T) {
 	r := NewRouter()
 	pool := backend.NewPool()
@@ -406,3 +450,760 @@ func TestContentRouterByContentType(t *testing.T) {
 		t.Fatal("expected content type route to match")
 	}
 }
+
+func TestMatchPathParam(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "user", Pattern: "/users/:id", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/users/42", nil)
+	route, params := r.MatchParams(req)
+	if route == nil || route.Name != "user" {
+		t.Fatal("expected user route to match")
+	}
+	if params["id"] != "42" {
+		t.Errorf("expected id 42, got %q", params["id"])
+	}
+}
+
+func TestMatchMultiplePathParams(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "order", Pattern: "/users/:id/orders/:oid", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/users/7/orders/abc-1", nil)
+	route, params := r.MatchParams(req)
+	if route == nil {
+		t.Fatal("expected order route to match")
+	}
+	if params["id"] != "7" || params["oid"] != "abc-1" {
+		t.Errorf("unexpected params: %v", params)
+	}
+}
+
+func TestMatchPathParamNoMatch(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "order", Pattern: "/users/:id/orders/:oid", Backend: backend.NewPool()})
+
+	for _, path := range []string{"/users/7/orders", "/users/7/orders/1/items", "/users//orders/1"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if route, _ := r.MatchParams(req); route != nil {
+			t.Errorf("expected %s not to match", path)
+		}
+	}
+}
+
+func TestParamsContext(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost/users/42", nil)
+	if Params(req) != nil {
+		t.Fatal("expected no params on a fresh request")
+	}
+
+	req = WithParams(req, map[string]string{"id": "42"})
+	if Params(req)["id"] != "42" {
+		t.Errorf("expected id from context, got %v", Params(req))
+	}
+}
+
+func TestMatchGlobDoubleStar(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "static", Glob: "/static/**", Backend: backend.NewPool()})
+
+	for path, want := range map[string]bool{
+		"/static/a/b":      true,
+		"/static/app.css":  true,
+		"/other":           false,
+		"/other/static/a":  false,
+		"/static-internal": false,
+	} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if got := r.Match(req) != nil; got != want {
+			t.Errorf("path %s: expected match=%v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestMatchGlobSingleStar(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "avatar", Glob: "/users/*/avatar", Backend: backend.NewPool()})
+
+	for path, want := range map[string]bool{
+		"/users/42/avatar":   true,
+		"/users/42/7/avatar": false,
+		"/users//avatar":     false,
+	} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if got := r.Match(req) != nil; got != want {
+			t.Errorf("path %s: expected match=%v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestMatchPatternIsUnanchored(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "api", Pattern: "/api", Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "exact", Pattern: "^/exact$", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/v1/api-internal", nil)
+	if r.Match(req) == nil {
+		t.Fatal("expected unanchored pattern to match a substring")
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/exact/more", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected anchored pattern not to match a longer path")
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "v2", PathPrefix: "/api", Query: map[string]string{"version": "2"}, Priority: 10, Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/users?version=2", nil)
+	if matched := r.Match(req); matched == nil || matched.Name != "v2" {
+		t.Fatal("expected query route to match")
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/api/users?version=1", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected different query value not to match")
+	}
+
+	req, _ = http.NewRequest("GET", "http://localhost/api/users", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected missing query parameter not to match")
+	}
+}
+
+func TestMatchQueryPresentAnyValue(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "debug", PathPrefix: "/", Query: map[string]string{"debug": ""}, Backend: backend.NewPool()})
+
+	for _, rawQuery := range []string{"debug", "debug=1", "debug=true&x=y"} {
+		req, _ := http.NewRequest("GET", "http://localhost/?"+rawQuery, nil)
+		if r.Match(req) == nil {
+			t.Errorf("expected ?%s to match", rawQuery)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost/?other=1", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected request without the parameter not to match")
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		rewrite *PathRewrite
+		path    string
+		want    string
+	}{
+		{nil, "/api/users", "/api/users"},
+		{&PathRewrite{StripPrefix: "/api"}, "/api/users", "/users"},
+		{&PathRewrite{StripPrefix: "/api"}, "/api", "/"},
+		{&PathRewrite{Pattern: `^/v1/(.*)$`, Replacement: "/v2/$1"}, "/v1/users", "/v2/users"},
+		{&PathRewrite{StripPrefix: "/api", Pattern: `^/old`, Replacement: "/new"}, "/api/old/x", "/new/x"},
+	}
+
+	for _, tt := range tests {
+		r := NewRouter()
+		route := &Route{Name: "r", PathPrefix: "/", Rewrite: tt.rewrite, Backend: backend.NewPool()}
+		if err := r.AddRoute(route); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := route.RewritePath(tt.path); got != tt.want {
+			t.Errorf("RewritePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteInvalidPattern(t *testing.T) {
+	r := NewRouter()
+	err := r.AddRoute(&Route{Name: "bad", PathPrefix: "/", Rewrite: &PathRewrite{Pattern: "("}, Backend: backend.NewPool()})
+	if err == nil {
+		t.Fatal("expected error for invalid rewrite pattern")
+	}
+}
+
+func TestRewritePathStripPrefix(t *testing.T) {
+	route := &Route{Name: "api", PathPrefix: "/api", StripPrefix: true}
+
+	tests := map[string]string{
+		"/api/users": "/users",
+		"/api":       "/",
+		"/api/":      "/",
+	}
+	for path, want := range tests {
+		if got := route.RewritePath(path); got != want {
+			t.Errorf("RewritePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	route.StripPrefix = false
+	if got := route.RewritePath("/api/users"); got != "/api/users" {
+		t.Errorf("expected path unchanged without StripPrefix, got %q", got)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "admin", PathPrefix: "/", Host: "admin.example.com", Priority: 10, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "default", PathPrefix: "/", Backend: backend.NewPool()})
+
+	tests := map[string]string{
+		"admin.example.com":      "admin",
+		"admin.example.com:8080": "admin",
+		"example.com":            "default",
+		"api.admin.example.com":  "default",
+	}
+	for host, want := range tests {
+		req, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		if matched := r.Match(req); matched == nil || matched.Name != want {
+			t.Errorf("host %s: expected route %s, got %v", host, want, matched)
+		}
+	}
+}
+
+func TestMatchHeadersNot(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "no-beta", PathPrefix: "/", HeadersNot: map[string]string{"X-Channel": "beta"}, Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if r.Match(req) == nil {
+		t.Fatal("expected request without the header to match")
+	}
+
+	req.Header.Set("X-Channel", "stable")
+	if r.Match(req) == nil {
+		t.Fatal("expected other header value to match")
+	}
+
+	req.Header.Set("X-Channel", "beta")
+	if r.Match(req) != nil {
+		t.Fatal("expected excluded header value not to match")
+	}
+}
+
+func TestMatchHeadersRegex(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "mobile", PathPrefix: "/", HeadersRegex: map[string]string{"User-Agent": "^Mobile"}, Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("User-Agent", "Mobile Safari")
+	if r.Match(req) == nil {
+		t.Fatal("expected mobile user agent to match")
+	}
+
+	req.Header.Set("User-Agent", "Desktop Mobile")
+	if r.Match(req) != nil {
+		t.Fatal("expected non-matching user agent not to match")
+	}
+}
+
+func TestHeadersRegexInvalid(t *testing.T) {
+	r := NewRouter()
+	err := r.AddRoute(&Route{Name: "bad", PathPrefix: "/", HeadersRegex: map[string]string{"User-Agent": "("}, Backend: backend.NewPool()})
+	if err == nil {
+		t.Fatal("expected error for invalid header regex")
+	}
+}
+
+func TestContentRouterByAccept(t *testing.T) {
+	cr := NewContentRouter()
+	jsonPool := backend.NewPool()
+	htmlPool := backend.NewPool()
+	defaultPool := backend.NewPool()
+
+	routes := map[string]*backend.Pool{
+		"application/json": jsonPool,
+		"text/html":        htmlPool,
+		"*/*":              defaultPool,
+	}
+
+	tests := []struct {
+		accept string
+		want   *backend.Pool
+	}{
+		{"application/json", jsonPool},
+		{"text/html", htmlPool},
+		{"text/html;q=0.5, application/json", jsonPool},
+		{"application/json;q=0.2, text/html;q=0.9", htmlPool},
+		{"application/json;q=0, text/html;q=0.1", htmlPool},
+		{"image/png", defaultPool},
+		{"", defaultPool},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if got := cr.RouteByAccept(req, routes); got != tt.want {
+			t.Errorf("Accept %q: got wrong pool", tt.accept)
+		}
+	}
+}
+
+func TestContentRouterByAcceptNoFallback(t *testing.T) {
+	cr := NewContentRouter()
+	routes := map[string]*backend.Pool{"application/json": backend.NewPool()}
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("Accept", "text/html")
+	if cr.RouteByAccept(req, routes) != nil {
+		t.Fatal("expected no pool without a */* fallback")
+	}
+}
+
+func TestContentRouterByContentTypeParameters(t *testing.T) {
+	cr := NewContentRouter()
+	pool := backend.NewPool()
+
+	req, _ := http.NewRequest("POST", "http://localhost/", nil)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if cr.RouteByContentType(req, map[string]*backend.Pool{"application/json": pool}) != pool {
+		t.Fatal("expected charset parameter to be ignored")
+	}
+}
+
+func TestContentRouterByContentTypeWildcard(t *testing.T) {
+	cr := NewContentRouter()
+	pngPool := backend.NewPool()
+	imagePool := backend.NewPool()
+	defaultPool := backend.NewPool()
+
+	routes := map[string]*backend.Pool{
+		"image/png": pngPool,
+		"image/*":   imagePool,
+		"*/*":       defaultPool,
+	}
+
+	tests := map[string]*backend.Pool{
+		"image/png":  pngPool,
+		"image/jpeg": imagePool,
+		"text/plain": defaultPool,
+	}
+	for contentType, want := range tests {
+		req, _ := http.NewRequest("POST", "http://localhost/", nil)
+		req.Header.Set("Content-Type", contentType)
+		if got := cr.RouteByContentType(req, routes); got != want {
+			t.Errorf("Content-Type %s: got wrong pool", contentType)
+		}
+	}
+
+	delete(routes, "*/*")
+	req, _ := http.NewRequest("POST", "http://localhost/", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	if cr.RouteByContentType(req, routes) != nil {
+		t.Fatal("expected no pool without a fallback")
+	}
+}
+
+func TestMatchCaseInsensitive(t *testing.T) {
+	routes := []*Route{
+		{Name: "pattern", Pattern: "^/api/users$"},
+		{Name: "prefix", PathPrefix: "/api/users"},
+		{Name: "glob", Glob: "/api/*"},
+	}
+
+	for _, route := range routes {
+		req, _ := http.NewRequest("GET", "http://localhost/API/USERS", nil)
+
+		r := NewRouter()
+		route.Backend = backend.NewPool()
+		r.AddRoute(route)
+		if r.Match(req) != nil {
+			t.Errorf("%s: expected case-sensitive route not to match", route.Name)
+		}
+
+		r = NewRouter()
+		route.CaseInsensitive = true
+		r.AddRoute(route)
+		if r.Match(req) == nil {
+			t.Errorf("%s: expected case-insensitive route to match", route.Name)
+		}
+		if req.URL.Path != "/API/USERS" {
+			t.Errorf("%s: expected path to be left unchanged, got %s", route.Name, req.URL.Path)
+		}
+	}
+}
+
+func TestRewritePathStripPrefixCaseInsensitive(t *testing.T) {
+	route := &Route{Name: "api", PathPrefix: "/api", StripPrefix: true, CaseInsensitive: true}
+	if got := route.RewritePath("/API/Users"); got != "/Users" {
+		t.Errorf("expected /Users, got %s", got)
+	}
+}
+
+func TestEqualPriorityKeepsInsertionOrder(t *testing.T) {
+	r := NewRouter()
+	for _, name := range []string{"first", "second", "third", "fourth"} {
+		r.AddRoute(&Route{Name: name, PathPrefix: "/", Priority: 5, Backend: backend.NewPool()})
+		r.AddRoute(&Route{Name: name + "-low", PathPrefix: "/", Priority: 1, Backend: backend.NewPool()})
+
+		req, _ := http.NewRequest("GET", "http://localhost/", nil)
+		if matched := r.Match(req); matched == nil || matched.Name != "first" {
+			t.Fatalf("expected first-added route to match after adding %s, got %v", name, matched)
+		}
+	}
+
+	routes := r.ListRoutes()
+	want := []string{"first", "second", "third", "fourth", "first-low", "second-low", "third-low", "fourth-low"}
+	for i, route := range routes {
+		if route.Name != want[i] {
+			t.Errorf("position %d: expected %s, got %s", i, want[i], route.Name)
+		}
+	}
+}
+
+func TestMatchPrefixPriorityAcrossTrie(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "api", PathPrefix: "/api", Priority: 1, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "users", PathPrefix: "/api/users", Priority: 5, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "catch-all", Pattern: "/", Priority: 0, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "override", Pattern: "^/api/users/admin", Priority: 10, Backend: backend.NewPool()})
+
+	tests := map[string]string{
+		"/api/users/admin": "override",
+		"/api/users/1":     "users",
+		"/api/orders":      "api",
+		"/apix":            "api",
+		"/other":           "catch-all",
+	}
+	for path, want := range tests {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		if matched := r.Match(req); matched == nil || matched.Name != want {
+			t.Errorf("%s: expected %s, got %v", path, want, matched)
+		}
+	}
+
+	r.RemoveRoute("users")
+	req, _ := http.NewRequest("GET", "http://localhost/api/users/1", nil)
+	if matched := r.Match(req); matched == nil || matched.Name != "api" {
+		t.Errorf("expected api after removing users, got %v", matched)
+	}
+}
+
+func benchmarkRouter(n int) *Router {
+	r := NewRouter()
+	pool := backend.NewPool()
+	for i := 0; i < n; i++ {
+		r.AddRoute(&Route{Name: fmt.Sprintf("svc-%d", i), PathPrefix: fmt.Sprintf("/svc-%d/", i), Backend: pool})
+	}
+	return r
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	r := benchmarkRouter(2000)
+	req, _ := http.NewRequest("GET", "http://localhost/svc-1999/items", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, route := range r.routes {
+			if r.matchRoute(route, req) {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	r := benchmarkRouter(2000)
+	req, _ := http.NewRequest("GET", "http://localhost/svc-1999/items", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if r.Match(req) == nil {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+func TestMatchCache(t *testing.T) {
+	r := NewRouter()
+	r.EnableMatchCache(10)
+	r.AddRoute(&Route{Name: "api", PathPrefix: "/api", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/api/users", nil)
+	first := r.Match(req)
+	if first == nil || first.Name != "api" {
+		t.Fatal("expected api route to match")
+	}
+	if r.cache.len() != 1 {
+		t.Fatalf("expected 1 cached result, got %d", r.cache.len())
+	}
+	if second := r.Match(req); second != first {
+		t.Fatal("expected cache hit to return the same route")
+	}
+
+	r.AddRoute(&Route{Name: "users", PathPrefix: "/api/users", Priority: 10, Backend: backend.NewPool()})
+	if r.cache.len() != 0 {
+		t.Fatal("expected AddRoute to clear the cache")
+	}
+	if matched := r.Match(req); matched == nil || matched.Name != "users" {
+		t.Fatalf("expected users route after mutation, got %v", matched)
+	}
+
+	r.RemoveRoute("users")
+	if r.cache.len() != 0 {
+		t.Fatal("expected RemoveRoute to clear the cache")
+	}
+	if matched := r.Match(req); matched == nil || matched.Name != "api" {
+		t.Fatalf("expected api route after removal, got %v", matched)
+	}
+}
+
+func TestMatchCacheEviction(t *testing.T) {
+	r := NewRouter()
+	r.EnableMatchCache(2)
+	r.AddRoute(&Route{Name: "all", PathPrefix: "/", Backend: backend.NewPool()})
+
+	for _, path := range []string{"/a", "/b", "/a", "/c"} {
+		req, _ := http.NewRequest("GET", "http://localhost"+path, nil)
+		r.Match(req)
+	}
+
+	if r.cache.len() != 2 {
+		t.Fatalf("expected cache bounded to 2, got %d", r.cache.len())
+	}
+	if _, ok := r.cache.get("GET localhost /b"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, ok := r.cache.get("GET localhost /a"); !ok {
+		t.Error("expected recently used entry to be kept")
+	}
+}
+
+func TestMatchCacheSkipsHeaderRoutes(t *testing.T) {
+	r := NewRouter()
+	r.EnableMatchCache(10)
+	r.AddRoute(&Route{Name: "beta", PathPrefix: "/", Headers: map[string]string{"X-Beta": "1"}, Priority: 10, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "stable", PathPrefix: "/", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/", nil)
+	if matched := r.Match(req); matched == nil || matched.Name != "stable" {
+		t.Fatal("expected stable route without header")
+	}
+
+	req.Header.Set("X-Beta", "1")
+	if matched := r.Match(req); matched == nil || matched.Name != "beta" {
+		t.Fatal("expected beta route with header despite earlier match")
+	}
+}
+
+func TestGetRoute(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "api", PathPrefix: "/api", Backend: backend.NewPool()})
+
+	if route := r.GetRoute("api"); route == nil || route.PathPrefix != "/api" {
+		t.Fatal("expected to get api route")
+	}
+	if r.GetRoute("missing") != nil {
+		t.Fatal("expected nil for missing route")
+	}
+}
+
+func TestUpdateRoute(t *testing.T) {
+	r := NewRouter()
+	r.EnableMatchCache(10)
+	r.AddRoute(&Route{Name: "users", Pattern: "^/users$", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/people", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected no match before update")
+	}
+
+	err := r.UpdateRoute(&Route{Name: "users", Pattern: "^/people$", Backend: backend.NewPool()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if matched := r.Match(req); matched == nil || matched.Name != "users" {
+		t.Fatal("expected updated pattern to match")
+	}
+	req, _ = http.NewRequest("GET", "http://localhost/users", nil)
+	if r.Match(req) != nil {
+		t.Fatal("expected old pattern to stop matching")
+	}
+	if len(r.ListRoutes()) != 1 {
+		t.Errorf("expected 1 route, got %d", len(r.ListRoutes()))
+	}
+}
+
+func TestUpdateRouteConcurrentMatch(t *testing.T) {
+	r := NewRouter()
+	newRoute := func() *Route {
+		return &Route{
+			Name:         "users",
+			Pattern:      "/users/:id",
+			HeadersRegex: map[string]string{"Accept": ".*"},
+			Rewrite:      &PathRewrite{Pattern: "^/users", Replacement: "/people"},
+			Backend:      backend.NewPool(),
+		}
+	}
+	r.AddRoute(newRoute())
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://localhost/users/42", nil)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				matched := r.Match(req)
+				if matched == nil || matched.Name != "users" {
+					t.Error("expected every match to find the route")
+					return
+				}
+				if path := matched.RewritePath(req.URL.Path); path != "/people/42" {
+					t.Errorf("expected the rewritten path, got %s", path)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		route := r.GetRoute("users")
+		if i%2 == 1 {
+			route = newRoute()
+		}
+		if err := r.UpdateRoute(route); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestUpdateRouteErrors(t *testing.T) {
+	r := NewRouter()
+	r.AddRoute(&Route{Name: "users", Pattern: "^/users$", Backend: backend.NewPool()})
+
+	if err := r.UpdateRoute(&Route{Name: "missing", Pattern: "/x"}); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+	if err := r.UpdateRoute(&Route{Name: "users", Pattern: "("}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if route := r.GetRoute("users"); route.Pattern != "^/users$" {
+		t.Error("expected failed update to leave route unchanged")
+	}
+}
+
+func TestSetRouteEnabled(t *testing.T) {
+	r := NewRouter()
+	r.EnableMatchCache(10)
+	r.AddRoute(&Route{Name: "users", PathPrefix: "/users", Priority: 10, Backend: backend.NewPool()})
+	r.AddRoute(&Route{Name: "fallback", PathPrefix: "/", Backend: backend.NewPool()})
+
+	req, _ := http.NewRequest("GET", "http://localhost/users", nil)
+	if matched := r.Match(req); matched == nil || matched.Name != "users" {
+		t.Fatal("expected users route to match")
+	}
+
+	if !r.SetRouteEnabled("users", false) {
+		t.Fatal("expected SetRouteEnabled to find users route")
+	}
+	if matched := r.Match(req); matched == nil || matched.Name != "fallback" {
+		t.Fatalf("expected disabled route to be skipped, got %v", matched)
+	}
+
+	r.SetRouteEnabled("users", true)
+	if matched := r.Match(req); matched == nil || matched.Name != "users" {
+		t.Fatal("expected re-enabled route to match again")
+	}
+	if routes := r.ListRoutes(); routes[0].Name != "users" {
+		t.Error("expected route to keep its position")
+	}
+
+	if r.SetRouteEnabled("missing", false) {
+		t.Error("expected false for missing route")
+	}
+}
+
+func TestSelectBackendCanary(t *testing.T) {
+	primary := backend.NewPool()
+	canary := backend.NewPool()
+	route := &Route{Name: "api", Backend: primary, CanaryBackend: canary, CanaryPercent: 20}
+
+	const clients = 10000
+	canaryCount := 0
+	for i := 0; i < clients; i++ {
+		if route.SelectBackend(fmt.Sprintf("10.0.%d.%d", i/256, i%256)) == canary {
+			canaryCount++
+		}
+	}
+
+	share := float64(canaryCount) / clients * 100
+	if share < 18 || share > 22 {
+		t.Errorf("expected about 20%% canary traffic, got %.1f%%", share)
+	}
+}
+
+func TestSelectBackendCanaryStablePerClient(t *testing.T) {
+	route := &Route{Name: "api", Backend: backend.NewPool(), CanaryBackend: backend.NewPool(), CanaryPercent: 50}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		first := route.SelectBackend(key)
+		for j := 0; j < 10; j++ {
+			if route.SelectBackend(key) != first {
+				t.Fatalf("expected %s to keep the same pool", key)
+			}
+		}
+	}
+}
+
+func TestSelectBackendWithoutCanary(t *testing.T) {
+	primary := backend.NewPool()
+	route := &Route{Name: "api", Backend: primary, CanaryBackend: backend.NewPool()}
+	if route.SelectBackend("client") != primary {
+		t.Error("expected primary pool when canary percent is zero")
+	}
+
+	route = &Route{Name: "api", Backend: primary, CanaryPercent: 100}
+	if route.SelectBackend("client") != primary {
+		t.Error("expected primary pool without a canary backend")
+	}
+}
+
+func TestRedirectURL(t *testing.T) {
+	tests := []struct {
+		route *Route
+		url   string
+		want  string
+	}{
+		{&Route{Redirect: &Redirect{Target: "/new"}}, "http://example.com/old", "http://example.com/new"},
+		{&Route{Redirect: &Redirect{Target: "https://example.com{path}"}}, "http://example.com/a/b?x=1", "https://example.com/a/b?x=1"},
+		{&Route{PathPrefix: "/old", StripPrefix: true, Redirect: &Redirect{Target: "/new{path}"}}, "http://example.com/old/users", "http://example.com/new/users"},
+		{&Route{Redirect: &Redirect{Target: "https://other.com/?from=proxy"}}, "http://example.com/?x=1", "https://other.com/?from=proxy"},
+		{&Route{Redirect: &Redirect{Target: "{path}"}}, "http://example.com//evil.com", "http://example.com/evil.com"},
+		{&Route{Redirect: &Redirect{Target: "{path}"}}, "http://example.com/%5C%5Cevil.com", "http://example.com/evil.com"},
+		{&Route{PathPrefix: "/old", StripPrefix: true, Redirect: &Redirect{Target: "{path}"}}, "http://example.com/old//evil.com", "http://example.com/evil.com"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		if got := tt.route.RedirectURL(req); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.url, tt.want, got)
+		}
+	}
+}
+
+func TestRedirectStatus(t *testing.T) {
+	route := &Route{Redirect: &Redirect{Target: "/new"}}
+	if route.RedirectStatus() != http.StatusFound {
+		t.Errorf("expected default 302, got %d", route.RedirectStatus())
+	}
+
+	route.Redirect.Status = http.StatusMovedPermanently
+	if route.RedirectStatus() != http.StatusMovedPermanently {
+		t.Errorf("expected 301, got %d", route.RedirectStatus())
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import "sort"
+
+// prefixTrie indexes routes by PathPrefix so Match only evaluates routes
+// whose prefix is a prefix of the request path. Routes are stored by their
+// position in the sorted route list, so candidates can be checked in
+// priority order.
+type prefixTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[byte]*trieNode
+	routes   []int
+}
+
+// newPrefixTrie builds an index over routes, which must already be sorted.
+// Routes without a prefix, and case-insensitive routes, sit at the root and
+// are candidates for every path.
+func newPrefixTrie(routes []*Route) *prefixTrie {
+	t := &prefixTrie{root: &trieNode{}}
+	for i, route := range routes {
+		prefix := route.PathPrefix
+		if route.CaseInsensitive {
+			prefix = ""
+		}
+		t.insert(prefix, i)
+	}
+	return t
+}
+
+func (t *prefixTrie) insert(prefix string, index int) {
+	node := t.root
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*trieNode)
+		}
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = &trieNode{}
+			node.children[prefix[i]] = child
+		}
+		node = child
+	}
+	node.routes = append(node.routes, index)
+}
+
+// candidates returns the indexes of routes that may match path, in
+// ascending order
+func (t *prefixTrie) candidates(path string) []int {
+	var indexes []int
+	node := t.root
+	for i := 0; ; i++ {
+		indexes = append(indexes, node.routes...)
+		if i == len(path) {
+			break
+		}
+		node = node.children[path[i]]
+		if node == nil {
+			break
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
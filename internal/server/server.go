@@ -0,0 +1,166 @@
+// Package server runs the proxy's public listeners
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Listener is one address the proxy handler is served on
+type Listener struct {
+	Address  string
+	TLS      bool
+	CertFile string
+	KeyFile  string
+	// RedirectHTTPS answers every request on a plaintext listener with a
+	// permanent redirect to the same URL over HTTPS
+	RedirectHTTPS bool
+}
+
+// Group serves one handler on several listeners, starting and shutting
+// them down together
+type Group struct {
+	listeners []Listener
+	servers   []*http.Server
+	bound     []net.Listener
+	httpsPort string // port of the first TLS listener, set by Start
+	errs      chan error
+	wg        sync.WaitGroup
+}
+
+// NewGroup creates a group serving handler on each listener. Redirecting
+// listeners point clients at the port of the group's first TLS listener,
+// or the default HTTPS port if there is none.
+func NewGroup(handler http.Handler, listeners []Listener) (*Group, error) {
+	if len(listeners) == 0 {
+		return nil, errors.New("no listeners")
+	}
+
+	g := &Group{listeners: listeners, errs: make(chan error, len(listeners))}
+	for _, l := range listeners {
+		if l.TLS && (l.CertFile == "" || l.KeyFile == "") {
+			return nil, fmt.Errorf("listener %s: tls requires cert_file and key_file", l.Address)
+		}
+		h := handler
+		if l.RedirectHTTPS && !l.TLS {
+			h = redirectHTTPS(&g.httpsPort)
+		}
+		g.servers = append(g.servers, &http.Server{Addr: l.Address, Handler: h})
+	}
+	return g, nil
+}
+
+// Start binds every listener and begins serving. If any address can't be
+// bound, nothing is served.
+func (g *Group) Start() error {
+	for _, server := range g.servers {
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, bound := range g.bound {
+				bound.Close()
+			}
+			g.bound = nil
+			return err
+		}
+		g.bound = append(g.bound, ln)
+	}
+	for i, l := range g.listeners {
+		if l.TLS {
+			_, g.httpsPort, _ = net.SplitHostPort(g.bound[i].Addr().String())
+			break
+		}
+	}
+
+	for i, server := range g.servers {
+		l, ln := g.listeners[i], g.bound[i]
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			var err error
+			if l.TLS {
+				err = server.ServeTLS(ln, l.CertFile, l.KeyFile)
+			} else {
+				err = server.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				g.errs <- fmt.Errorf("listener %s: %w", l.Address, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Addrs returns the bound address of each listener, in order, once started
+func (g *Group) Addrs() []net.Addr {
+	addrs := make([]net.Addr, len(g.bound))
+	for i, ln := range g.bound {
+		addrs[i] = ln.Addr()
+	}
+	return addrs
+}
+
+// Wait blocks until every listener has stopped. If one fails, the others
+// are closed and its error is returned; after Shutdown it returns nil.
+func (g *Group) Wait() error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case err := <-g.errs:
+		for _, server := range g.servers {
+			server.Close()
+		}
+		<-done
+		return err
+	case <-done:
+		select {
+		case err := <-g.errs:
+			return err
+		default:
+			return nil
+		}
+	}
+}
+
+// Shutdown gracefully stops every listener, waiting for active requests
+// until ctx is done
+func (g *Group) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(g.servers))
+	for i, server := range g.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// redirectHTTPS redirects requests to the same host and URL over HTTPS on
+// *port, or the default HTTPS port if it is empty or 443
+func redirectHTTPS(port *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if *port != "" && *port != "443" {
+			host = net.JoinHostPort(host, *port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
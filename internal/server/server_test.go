@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key, returning their paths
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok")
+})
+
+func startGroup(t *testing.T, listeners []Listener) *Group {
+	t.Helper()
+	g, err := NewGroup(okHandler, listeners)
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatalf("failed to start group: %v", err)
+	}
+	t.Cleanup(func() { g.Shutdown(context.Background()) })
+	return g
+}
+
+var insecureClient = &http.Client{
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func TestGroupServesAllListeners(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+	g := startGroup(t, []Listener{
+		{Address: "127.0.0.1:0"},
+		{Address: "127.0.0.1:0", TLS: true, CertFile: certFile, KeyFile: keyFile},
+	})
+	addrs := g.Addrs()
+
+	for _, url := range []string{"http://" + addrs[0].String(), "https://" + addrs[1].String()} {
+		resp, err := insecureClient.Get(url)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Errorf("expected %s to serve the handler, got %d %q", url, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestGroupRedirectHTTPS(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+	g := startGroup(t, []Listener{
+		{Address: "127.0.0.1:0", RedirectHTTPS: true},
+		{Address: "127.0.0.1:0", TLS: true, CertFile: certFile, KeyFile: keyFile},
+	})
+	addrs := g.Addrs()
+
+	resp, err := insecureClient.Get("http://" + addrs[0].String() + "/api/users?page=2")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("expected 308, got %d", resp.StatusCode)
+	}
+	want := "https://" + addrs[1].String() + "/api/users?page=2"
+	if location := resp.Header.Get("Location"); location != want {
+		t.Fatalf("expected redirect to %s, got %s", want, location)
+	}
+
+	resp, err = insecureClient.Get(want)
+	if err != nil {
+		t.Fatalf("request to redirect target failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected redirect target to serve, got %d", resp.StatusCode)
+	}
+}
+
+func TestRedirectHTTPSTarget(t *testing.T) {
+	tests := []struct {
+		port, host, want string
+	}{
+		{"", "example.com:8080", "https://example.com/a?b=c"},
+		{"443", "example.com", "https://example.com/a?b=c"},
+		{"8443", "example.com", "https://example.com:8443/a?b=c"},
+		{"", "[::1]:8080", "https://[::1]/a?b=c"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://"+tt.host+"/a?b=c", nil)
+		redirectHTTPS(&tt.port).ServeHTTP(w, req)
+		if location := w.Header().Get("Location"); location != tt.want {
+			t.Errorf("port %q host %q: expected %s, got %s", tt.port, tt.host, tt.want, location)
+		}
+	}
+}
+
+func TestGroupStartFailsIfAnyAddressIsTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	g, err := NewGroup(okHandler, []Listener{{Address: "127.0.0.1:0"}, {Address: taken.Addr().String()}})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if err := g.Start(); err == nil {
+		t.Fatal("expected error for an address in use")
+	}
+	if len(g.Addrs()) != 0 {
+		t.Error("expected no listener to be left bound")
+	}
+}
+
+func TestGroupShutdown(t *testing.T) {
+	g, err := NewGroup(okHandler, []Listener{{Address: "127.0.0.1:0"}, {Address: "127.0.0.1:0"}})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatalf("failed to start group: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected Wait to return nil after shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after shutdown")
+	}
+
+	for _, addr := range g.Addrs() {
+		if _, err := http.Get("http://" + addr.String()); err == nil {
+			t.Errorf("expected %s to be closed", addr)
+		}
+	}
+}
+
+func TestNewGroupValidation(t *testing.T) {
+	if _, err := NewGroup(okHandler, nil); err == nil {
+		t.Error("expected error for no listeners")
+	}
+	if _, err := NewGroup(okHandler, []Listener{{Address: ":8443", TLS: true}}); err == nil {
+		t.Error("expected error for tls without a certificate")
+	}
+}